@@ -0,0 +1,130 @@
+// Copyright 2019 Tamás Gulácsi
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package goracle
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// QueueTableOptions are the options of CreateQueueTable.
+type QueueTableOptions struct {
+	// SortList is the sort order of the messages, such as "priority,enq_time". Empty means "enq_time".
+	SortList string
+	// Comment is a user-specified description of the queue table.
+	Comment string
+	// MultipleConsumers allows more than one consumer (subscriber) per message.
+	MultipleConsumers bool
+	// TransactionalGrouping groups the messages enqueued in one transaction (see NavNextTran).
+	TransactionalGrouping bool
+}
+
+// CreateQueueTable creates the queue table, with DBMS_AQADM.create_queue_table.
+// The payloadType is "RAW" or the name of an object type.
+func CreateQueueTable(ctx context.Context, execer Execer, table, payloadType string, opts QueueTableOptions) error {
+	const qry = `BEGIN
+  DBMS_AQADM.create_queue_table(queue_table=>:1, queue_payload_type=>:2,
+    sort_list=>:3, comment=>:4,
+    multiple_consumers=>(:5 = 1),
+    message_grouping=>CASE :6 WHEN 1 THEN DBMS_AQADM.TRANSACTIONAL ELSE DBMS_AQADM.NONE END);
+END;`
+	if _, err := execer.ExecContext(ctx, qry, table, payloadType,
+		opts.SortList, opts.Comment, b2i(opts.MultipleConsumers), b2i(opts.TransactionalGrouping),
+	); err != nil {
+		return errors.Wrapf(err, "create queue table %s", table)
+	}
+	return nil
+}
+
+// DropQueueTable drops the queue table, with DBMS_AQADM.drop_queue_table.
+// With force, its queues are stopped and dropped first.
+func DropQueueTable(ctx context.Context, execer Execer, table string, force bool) error {
+	const qry = `BEGIN DBMS_AQADM.drop_queue_table(queue_table=>:1, force=>(:2 = 1)); END;`
+	if _, err := execer.ExecContext(ctx, qry, table, b2i(force)); err != nil {
+		return errors.Wrapf(err, "drop queue table %s", table)
+	}
+	return nil
+}
+
+// QueueOptions are the options of CreateQueue.
+type QueueOptions struct {
+	// Comment is a user-specified description of the queue.
+	Comment string
+	// MaxRetries is the number of dequeue attempts (rollbacks) before a message is moved to the exception queue.
+	// Zero means the Oracle default (5).
+	MaxRetries int
+	// RetryDelay is the delay before a rolled back message can be dequeued again.
+	RetryDelay time.Duration
+	// Retention is how long the processed messages are kept in the queue table, RetainForever for infinite.
+	Retention time.Duration
+	// Exception creates an exception queue, which can be dequeued from only.
+	Exception bool
+}
+
+// CreateQueue creates the queue in the queue table, with DBMS_AQADM.create_queue.
+// The queue must be started (see StartQueue) before use.
+func CreateQueue(ctx context.Context, execer Execer, name, table string, opts QueueOptions) error {
+	const qry = `BEGIN
+  DBMS_AQADM.create_queue(queue_name=>:1, queue_table=>:2,
+    queue_type=>CASE :3 WHEN 1 THEN DBMS_AQADM.EXCEPTION_QUEUE ELSE DBMS_AQADM.NORMAL_QUEUE END,
+    max_retries=>:4, retry_delay=>:5, retention_time=>:6, comment=>:7);
+END;`
+	var maxRetries interface{}
+	if opts.MaxRetries > 0 {
+		maxRetries = opts.MaxRetries
+	}
+	retention := int64(opts.Retention / time.Second)
+	if opts.Retention == RetainForever {
+		retention = -1 // DBMS_AQADM.INFINITE
+	}
+	if _, err := execer.ExecContext(ctx, qry, name, table, b2i(opts.Exception),
+		maxRetries, int64(opts.RetryDelay/time.Second), retention, opts.Comment,
+	); err != nil {
+		return errors.Wrapf(err, "create queue %s", name)
+	}
+	return nil
+}
+
+// DropQueue drops the (stopped) queue, with DBMS_AQADM.drop_queue.
+func DropQueue(ctx context.Context, execer Execer, name string) error {
+	const qry = `BEGIN DBMS_AQADM.drop_queue(queue_name=>:1); END;`
+	if _, err := execer.ExecContext(ctx, qry, name); err != nil {
+		return errors.Wrapf(err, "drop queue %s", name)
+	}
+	return nil
+}
+
+// StartQueue enables the enqueue and/or dequeue on the queue, with DBMS_AQADM.start_queue.
+func StartQueue(ctx context.Context, execer Execer, name string, enqueue, dequeue bool) error {
+	const qry = `BEGIN DBMS_AQADM.start_queue(queue_name=>:1, enqueue=>(:2 = 1), dequeue=>(:3 = 1)); END;`
+	if _, err := execer.ExecContext(ctx, qry, name, b2i(enqueue), b2i(dequeue)); err != nil {
+		return errors.Wrapf(err, "start queue %s", name)
+	}
+	return nil
+}
+
+// StopQueue disables the enqueue and/or dequeue on the queue, with DBMS_AQADM.stop_queue.
+// With wait, it waits for the outstanding transactions on the queue to finish,
+// otherwise it returns an error if there are any.
+func StopQueue(ctx context.Context, execer Execer, name string, enqueue, dequeue, wait bool) error {
+	const qry = `BEGIN DBMS_AQADM.stop_queue(queue_name=>:1, enqueue=>(:2 = 1), dequeue=>(:3 = 1), wait=>(:4 = 1)); END;`
+	if _, err := execer.ExecContext(ctx, qry, name, b2i(enqueue), b2i(dequeue), b2i(wait)); err != nil {
+		return errors.Wrapf(err, "stop queue %s", name)
+	}
+	return nil
+}
@@ -0,0 +1,93 @@
+// Copyright 2019 Tamás Gulácsi
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package goracle_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	goracle "gopkg.in/goracle.v2"
+)
+
+func TestQueueAdmin(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
+	conn, err := testDb.Conn(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	const qName, tblName = "TEST_QADM", "TEST_QADM_TBL"
+	// Clean up the leftovers of a previous run.
+	goracle.DropQueueTable(ctx, conn, tblName, true)
+
+	if err = goracle.CreateQueueTable(ctx, conn, tblName, "RAW", goracle.QueueTableOptions{
+		SortList: "priority,enq_time", Comment: "goracle test",
+	}); err != nil {
+		t.Fatal(err)
+	}
+	defer goracle.DropQueueTable(context.Background(), conn, tblName, true)
+	if err = goracle.CreateQueue(ctx, conn, qName, tblName, goracle.QueueOptions{
+		MaxRetries: 3, RetryDelay: 2 * time.Second, Retention: time.Minute,
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err = goracle.StartQueue(ctx, conn, qName, true, true); err != nil {
+		t.Fatal(err)
+	}
+
+	q, err := goracle.NewQueue(ctx, conn, qName, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := q.Config(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (goracle.QueueConfig{MaxRetries: 3, RetryDelay: 2 * time.Second, Retention: time.Minute}); cfg != want {
+		t.Errorf("got config %+v, wanted %+v", cfg, want)
+	}
+	if err = q.SetEnqOptions(goracle.EnqOptions{Visibility: goracle.VisibleImmediate}); err != nil {
+		t.Fatal(err)
+	}
+	if err = q.Enqueue([]goracle.Message{{Raw: []byte("admin")}}); err != nil {
+		t.Fatal(err)
+	}
+	msgs := make([]goracle.Message, 1)
+	if n, err := q.DequeueWith(msgs, goracle.DeqOptions{
+		Mode: goracle.DeqRemove, Navigation: goracle.NavFirst,
+		Visibility: goracle.VisibleImmediate, Wait: goracle.NoWait,
+	}); err != nil {
+		t.Fatal(err)
+	} else if n != 1 || string(msgs[0].Raw) != "admin" {
+		t.Errorf("got %d %q, wanted the enqueued message", n, msgs[0].Raw)
+	}
+	if err = q.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = goracle.StopQueue(ctx, conn, qName, true, true, true); err != nil {
+		t.Fatal(err)
+	}
+	if err = goracle.DropQueue(ctx, conn, qName); err != nil {
+		t.Fatal(err)
+	}
+	if err = goracle.DropQueueTable(ctx, conn, tblName, false); err != nil {
+		t.Fatal(err)
+	}
+}
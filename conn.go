@@ -61,6 +61,12 @@ type conn struct {
 	newSession    bool
 	timeZone      *time.Location
 	tzOffSecs     int
+
+	objTypesMu sync.Mutex
+	objTypes   map[string]ObjectType
+
+	subscrsMu sync.Mutex
+	subscrs   map[*Subscription]struct{}
 }
 
 func (c *conn) getError() error {
@@ -148,6 +154,8 @@ func (c *conn) Close() error {
 	if dpiConn == nil {
 		return nil
 	}
+	c.releaseObjectTypes()
+	c.closeSubscriptions(dpiConn)
 	// Just to be sure, break anything in progress.
 	done := make(chan struct{})
 	go func() {
@@ -510,6 +518,7 @@ func maybeBadConn(err error) error {
 			12537, // TNS:connection closed
 			12547, // TNS:lost contact
 			12570, // TNS:packet reader failure
+			12571, // TNS:packet writer failure
 			12583, // TNS:no reader
 			27146, // post/wait initialization failed
 			28511, // lost RPC connection
@@ -520,6 +529,13 @@ func maybeBadConn(err error) error {
 	return err
 }
 
+// IsConnectionLost reports whether the error (or its cause) means that the connection is lost
+// (such as ORA-03113, ORA-03114, ORA-12571), so the connection (and the Queue on it) must be rebuilt,
+// as opposed to a logical error.
+func IsConnectionLost(err error) bool {
+	return err != nil && maybeBadConn(err) == driver.ErrBadConn
+}
+
 func (c *conn) setTraceTag(tt TraceTag) error {
 	if c == nil || c.dpiConn == nil {
 		return nil
@@ -17,6 +17,7 @@ package goracle
 
 import (
 	"database/sql/driver"
+	"fmt"
 	"strings"
 	"testing"
 
@@ -110,3 +111,27 @@ func TestMaybeBadConn(t *testing.T) {
 		t.Errorf("got %v, wanted %v", got, want)
 	}
 }
+
+func TestIsConnectionLost(t *testing.T) {
+	for code, want := range map[int]bool{
+		3113: true, 3114: true, 12571: true, 3135: true,
+		1403: false, 25228: false, 24010: false,
+	} {
+		oe := &OraErr{code: code, message: fmt.Sprintf("ORA-%05d: test", code)}
+		for _, err := range []error{
+			oe,
+			errors.Wrap(oe, "dequeue"),
+			&QueueError{Queue: "Q", Op: OpDequeue, Err: errors.WithMessage(oe, "dequeue")},
+		} {
+			if got := IsConnectionLost(err); got != want {
+				t.Errorf("%v: got %t, wanted %t", err, got, want)
+			}
+		}
+	}
+	if IsConnectionLost(nil) {
+		t.Error("nil is connection lost")
+	}
+	if !IsConnectionLost(errors.Wrap(driver.ErrBadConn, "x")) {
+		t.Error("ErrBadConn is not connection lost")
+	}
+}
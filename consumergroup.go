@@ -0,0 +1,120 @@
+// Copyright 2019 Tamás Gulácsi
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package goracle
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+)
+
+// ConsumerGroup is a group of consumers sharing the messages of a multi-consumer Queue,
+// as in a Kafka consumer group: each message is processed by one member of the group,
+// and every group gets every message.
+//
+// The group is one subscriber (named consumer) of the queue, and its members are sessions
+// dequeueing as that subscriber: AQ hands a message to one session only.
+// A member acknowledges a message by returning nil from its handler (the dequeue is committed),
+// and returning an error rolls it back, so the message is redelivered (to any member),
+// up to the queue's max_retries, then it goes to the exception queue.
+// There is no offset to track: the queue keeps the messages not yet acknowledged by the group.
+type ConsumerGroup struct {
+	// Name is the name of the group, and of the queue's subscriber.
+	Name string
+}
+
+// NewConsumerGroup returns the group of name on the multi-consumer queue Q,
+// adding the group as the queue's subscriber (an existing subscriber is not an error).
+//
+// Only the messages enqueued after the subscriber was added are delivered to the group.
+func NewConsumerGroup(ctx context.Context, Q *Queue, name string) (*ConsumerGroup, error) {
+	if name == "" {
+		return nil, errors.Wrap(ErrInvalidOptions, "consumer group: empty name")
+	}
+	if err := addSubscriber(ctx, Q, name, ""); err != nil {
+		return nil, err
+	}
+	return &ConsumerGroup{Name: name}, nil
+}
+
+// Consume runs a member of the group: calls handler with the messages of the group dequeued from Q,
+// till ctx is done (returning ctx.Err()), or the dequeue fails.
+//
+// Each member needs a Queue on its own connection (the dequeues and the commits work on the connection),
+// on the queue of the group.
+func (G *ConsumerGroup) Consume(ctx context.Context, Q *Queue, handler func(Message) error, opts ...ConsumeOption) error {
+	return consume(ctx, Q, DeqOptions{Consumer: G.Name}, handler, opts)
+}
+
+// ConsumeOption is an option of the handler based consumers (ConsumerGroup.Consume, PubSub.Subscribe),
+// wrapping the handler.
+type ConsumeOption func(handler func(Message) error) func(Message) error
+
+// DedupWindow makes the consumer skip the messages whose MsgID is among the last n handled successfully:
+// a redelivered duplicate is acknowledged (removed) without calling the handler, for effectively-once processing.
+//
+// A message the handler failed with is not recorded, so its redelivery is handled again.
+// The window is per consumer (in memory), so it does not detect duplicates across consumers or restarts.
+func DedupWindow(n int) ConsumeOption {
+	return func(handler func(Message) error) func(Message) error {
+		if n <= 0 {
+			return handler
+		}
+		w := newMsgIDWindow(n)
+		return func(m Message) error {
+			if w.contains(m.MsgID) {
+				return nil
+			}
+			if err := handler(m); err != nil {
+				return err
+			}
+			w.add(m.MsgID)
+			return nil
+		}
+	}
+}
+
+// msgIDWindow is a ring buffer of the last MsgIDs, with a set for the lookup.
+type msgIDWindow struct {
+	ids  [][MsgIDLength]byte
+	next int
+	full bool
+	set  map[[MsgIDLength]byte]struct{}
+}
+
+func newMsgIDWindow(n int) *msgIDWindow {
+	return &msgIDWindow{ids: make([][MsgIDLength]byte, n), set: make(map[[MsgIDLength]byte]struct{}, n)}
+}
+
+func (w *msgIDWindow) contains(id [MsgIDLength]byte) bool {
+	_, ok := w.set[id]
+	return ok
+}
+
+// add the id, evicting the oldest one if the window is full.
+func (w *msgIDWindow) add(id [MsgIDLength]byte) {
+	if w.contains(id) {
+		return
+	}
+	if w.full {
+		delete(w.set, w.ids[w.next])
+	}
+	w.ids[w.next] = id
+	w.set[id] = struct{}{}
+	if w.next++; w.next == len(w.ids) {
+		w.next, w.full = 0, true
+	}
+}
@@ -0,0 +1,121 @@
+// Copyright 2019 Tamás Gulácsi
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package goracle_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	goracle "gopkg.in/goracle.v2"
+)
+
+func TestConsumerGroup(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
+	conn, err := testDb.Conn(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	const qName = "TEST_QGROUP"
+	defer createQueue(ctx, t, conn, qName, "", ", multiple_consumers=>TRUE", "")()
+	q, err := goracle.NewQueue(ctx, conn, qName, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+	group, err := goracle.NewConsumerGroup(ctx, q, "TEST_GROUP")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = q.SetEnqOptions(goracle.EnqOptions{Visibility: goracle.VisibleImmediate}); err != nil {
+		t.Fatal(err)
+	}
+	const all = 20
+	for i := 0; i < all; i++ {
+		if _, err = q.EnqueueOne(goracle.Message{Raw: []byte(fmt.Sprintf("%02d", i))}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var mu sync.Mutex
+	seen := make(map[string]int) // payload -> successful handlings
+	perMember := make([]int, 2)  // member -> successful handlings
+	var failed bool
+	grpCtx, grpCancel := context.WithTimeout(ctx, 30*time.Second)
+	defer grpCancel()
+	var wg sync.WaitGroup
+	for member := range perMember {
+		member := member
+		mConn, err := testDb.Conn(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer mConn.Close()
+		mq, err := goracle.NewQueue(ctx, mConn, qName, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer mq.Close()
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := group.Consume(grpCtx, mq, func(m goracle.Message) error {
+				time.Sleep(50 * time.Millisecond) // let the other member have its share
+				mu.Lock()
+				defer mu.Unlock()
+				if string(m.Raw) == "05" && !failed {
+					// The first delivery fails, so the message is redelivered.
+					failed = true
+					return errors.New("fail once")
+				}
+				seen[string(m.Raw)]++
+				perMember[member]++
+				if len(seen) == all {
+					grpCancel()
+				}
+				return nil
+			})
+			if err != context.Canceled && err != context.DeadlineExceeded {
+				t.Errorf("member %d: %+v", member, err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if len(seen) != all {
+		t.Errorf("the group handled %d messages, wanted %d", len(seen), all)
+	}
+	for k, n := range seen {
+		if n != 1 {
+			t.Errorf("%s was handled %d times", k, n)
+		}
+	}
+	if !failed {
+		t.Error("the failing message was not delivered")
+	}
+	t.Logf("per member: %v", perMember)
+	for member, n := range perMember {
+		if n == 0 {
+			t.Errorf("member %d got no messages: %v", member, perMember)
+		}
+	}
+}
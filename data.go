@@ -344,6 +344,10 @@ func (d *Data) Set(v interface{}) error {
 		d.NativeTypeNum = C.DPI_NATIVE_TYPE_OBJECT
 		d.ObjectType = x.ObjectType
 		d.SetObject(x)
+	case *ObjectCollection:
+		d.NativeTypeNum = C.DPI_NATIVE_TYPE_OBJECT
+		d.ObjectType = x.ObjectType
+		d.SetObject(x.Object)
 	//case *stmt:
 	//d.NativeTypeNum = C.DPI_NATIVE_TYPE_STMT
 	//d.SetStmt(x)
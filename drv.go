@@ -846,13 +846,25 @@ func newErrorInfo(code int, message string) C.dpiErrorInfo {
 // against deadcode
 var _ = newErrorInfo
 
-func (d *drv) getError() *OraErr {
+// ErrUnknownDPIFailure is returned when an ODPI-C call failed, but reported no error.
+var ErrUnknownDPIFailure = errors.New("DPI_FAILURE without error information")
+
+// errorFromInfo returns the error of errInfo, or ErrUnknownDPIFailure if errInfo is empty,
+// so a failed call is never reported with a nil (or blank) error.
+func errorFromInfo(errInfo C.dpiErrorInfo) error {
+	if oe := fromErrorInfo(errInfo); oe.code != 0 || oe.message != "" {
+		return oe
+	}
+	return ErrUnknownDPIFailure
+}
+
+func (d *drv) getError() error {
 	if d == nil || d.dpiContext == nil {
 		return &OraErr{code: -12153, message: driver.ErrBadConn.Error()}
 	}
 	var errInfo C.dpiErrorInfo
 	C.dpiContext_getError(d.dpiContext, &errInfo)
-	return fromErrorInfo(errInfo)
+	return errorFromInfo(errInfo)
 }
 
 func b2i(b bool) uint8 {
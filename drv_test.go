@@ -82,3 +82,21 @@ func TestParseTZ(t *testing.T) {
 		}
 	}
 }
+
+func TestErrorFromEmptyInfo(t *testing.T) {
+	// A DPI_FAILURE with no error information must not be reported as a nil or blank error.
+	for _, msg := range []string{"", " \n"} {
+		err := errorFromInfo(newErrorInfo(0, msg))
+		if err != ErrUnknownDPIFailure {
+			t.Errorf("%q: got %#v, wanted ErrUnknownDPIFailure", msg, err)
+		}
+		if err = errors.WithMessage(err, "enqueue"); err == nil || errors.Cause(err) != ErrUnknownDPIFailure {
+			t.Errorf("%q: wrapped got %#v", msg, err)
+		}
+	}
+	if err := errorFromInfo(newErrorInfo(0, "ORA-24315: illegal attribute type")); err == ErrUnknownDPIFailure {
+		t.Errorf("got %v for a real error", err)
+	} else if oe, ok := err.(*OraErr); !ok || oe.Code() != 24315 {
+		t.Errorf("got %#v, wanted ORA-24315", err)
+	}
+}
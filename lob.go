@@ -109,7 +109,8 @@ func (dlr *dpiLobReader) Read(p []byte) (int, error) {
 	}
 	if C.dpiLob_readBytes(dlr.dpiLob, dlr.offset+1, n, (*C.char)(unsafe.Pointer(&p[0])), &n) == C.DPI_FAILURE {
 		err := dlr.getError()
-		if dlr.finished = err.(interface{ Code() int }).Code() == 1403; dlr.finished {
+		if ec, ok := err.(interface{ Code() int }); ok && ec.Code() == 1403 {
+			dlr.finished = true
 			dlr.offset += n
 			return int(n), io.EOF
 		}
@@ -401,6 +401,41 @@ func (c *conn) GetObjectType(name string) (ObjectType, error) {
 	return t, t.init()
 }
 
+// getObjectTypeCached returns the ObjectType from the connection's cache,
+// calling GetObjectType only for the first time.
+//
+// The returned ObjectType is shared, so must not be Closed - the cache is released when the connection is closed.
+func (c *conn) getObjectTypeCached(name string) (ObjectType, error) {
+	key := name
+	if !strings.Contains(key, "\"") {
+		key = strings.ToUpper(key)
+	}
+	c.objTypesMu.Lock()
+	defer c.objTypesMu.Unlock()
+	if t, ok := c.objTypes[key]; ok {
+		return t, nil
+	}
+	t, err := c.GetObjectType(name)
+	if err != nil {
+		return t, err
+	}
+	if c.objTypes == nil {
+		c.objTypes = make(map[string]ObjectType)
+	}
+	c.objTypes[key] = t
+	return t, nil
+}
+
+// releaseObjectTypes releases the cached ObjectTypes, before the dpiConn is released.
+func (c *conn) releaseObjectTypes() {
+	c.objTypesMu.Lock()
+	defer c.objTypesMu.Unlock()
+	for k, t := range c.objTypes {
+		_ = t.Close()
+		delete(c.objTypes, k)
+	}
+}
+
 // NewObject returns a new Object with ObjectType type.
 func (t ObjectType) NewObject() (*Object, error) {
 	obj := (*C.dpiObject)(C.malloc(C.sizeof_void))
@@ -0,0 +1,134 @@
+// Copyright 2019 Tamás Gulácsi
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package goracle
+
+import (
+	"context"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// PubSub is a publish/subscribe facade over a multi-consumer Queue:
+// the topics are message correlations, the subscribers are the queue's named consumers
+// (added with a rule on the correlation, so each gets the messages of its topic only).
+type PubSub struct {
+	Q *Queue
+}
+
+// NewPubSub returns a PubSub on the multi-consumer queue Q,
+// which publishes with VisibleImmediate (so no commit is needed).
+// The other enqueue options of Q are kept.
+func NewPubSub(Q *Queue) (*PubSub, error) {
+	E, err := Q.EnqOptions()
+	if err != nil {
+		return nil, err
+	}
+	E.Visibility = VisibleImmediate
+	if err = Q.SetEnqOptions(E); err != nil {
+		return nil, err
+	}
+	return &PubSub{Q: Q}, nil
+}
+
+// Publish the payload on the topic. Only the subscribers added before receive it.
+func (P *PubSub) Publish(topic string, payload []byte) ([MsgIDLength]byte, error) {
+	return P.Q.EnqueueOne(Message{Correlation: topic, Raw: payload})
+}
+
+// AddSubscriber adds the subscriber of topic to the queue, with DBMS_AQADM.add_subscriber.
+// Adding an existing subscriber is not an error.
+//
+// A subscriber name identifies one subscription on the queue: use distinct names for distinct topics.
+func (P *PubSub) AddSubscriber(ctx context.Context, topic, subscriber string) error {
+	rule := "corrid = '" + strings.Replace(topic, "'", "''", -1) + "'"
+	return addSubscriber(ctx, P.Q, subscriber, rule)
+}
+
+// addSubscriber adds the subscriber with the rule (if not empty) to the queue, with DBMS_AQADM.add_subscriber.
+// Adding an existing subscriber is not an error.
+func addSubscriber(ctx context.Context, Q *Queue, subscriber, rule string) error {
+	const qry = `DECLARE
+  v_agent SYS.AQ$_AGENT := SYS.AQ$_AGENT(:1, NULL, NULL);
+BEGIN
+  DBMS_AQADM.add_subscriber(queue_name=>:2, subscriber=>v_agent, rule=>:3);
+END;`
+	if _, err := Q.execer.ExecContext(ctx, qry, subscriber, Q.name, rule); err != nil {
+		// ORA-24034: application is already a subscriber for queue
+		if strings.Contains(errors.Cause(err).Error(), "ORA-24034:") {
+			return nil
+		}
+		return Q.wrapErr("addSubscriber", errors.Wrapf(err, "%s [%q]", subscriber, rule))
+	}
+	return nil
+}
+
+// Subscribe adds the subscriber of topic (see AddSubscriber), and calls handler with its messages,
+// till ctx is done (returning ctx.Err()), or the dequeue fails. See ConsumeOption for the options.
+//
+// Each message is dequeued in a transaction, committed if handler succeeds, and rolled back
+// (so the message is delivered again, up to the queue's max_retries) if it returns an error.
+// As the dequeues run on the Queue's connection, they block Publish on the same PubSub,
+// and the commits end its transaction: publish and subscribe on different connections.
+func (P *PubSub) Subscribe(ctx context.Context, topic, subscriber string, handler func(Message) error, opts ...ConsumeOption) error {
+	if err := P.AddSubscriber(ctx, topic, subscriber); err != nil {
+		return err
+	}
+	return consume(ctx, P.Q, DeqOptions{Consumer: subscriber, Correlation: topic}, handler, opts)
+}
+
+// consume calls handler with the messages dequeued with D (as DeqRemove, NavFirst, VisibleOnCommit, waiting 1s)
+// on a clone of Q, till ctx is done (returning ctx.Err()), or the dequeue fails.
+// Each message is committed if handler succeeds, and rolled back if it returns an error.
+func consume(ctx context.Context, Q *Queue, D DeqOptions, handler func(Message) error, opts []ConsumeOption) error {
+	for _, o := range opts {
+		handler = o(handler)
+	}
+	Q, err := Q.Clone()
+	if err != nil {
+		return err
+	}
+	defer Q.Close()
+	D.Mode, D.Navigation, D.Visibility, D.Wait = DeqRemove, NavFirst, VisibleOnCommit, 1
+	if err = Q.SetDeqOptions(D); err != nil {
+		return err
+	}
+	msgs := make([]Message, 1)
+	for {
+		n, err := Q.DequeueContext(ctx, msgs)
+		if err != nil {
+			if ctxErr := ctx.Err(); ctxErr != nil {
+				return ctxErr
+			}
+			return err
+		}
+		if n == 0 {
+			if err = ctx.Err(); err != nil {
+				return err
+			}
+			continue
+		}
+		if err = handler(msgs[0]); err != nil {
+			if rbErr := Q.Rollback(); rbErr != nil {
+				return errors.WithMessage(rbErr, "rollback after "+err.Error())
+			}
+			continue
+		}
+		if err = Q.Commit(); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,87 @@
+// Copyright 2019 Tamás Gulácsi
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package goracle_test
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"testing"
+	"time"
+
+	goracle "gopkg.in/goracle.v2"
+)
+
+func TestPubSub(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
+	conn, err := testDb.Conn(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	const qName = "TEST_QPUBSUB"
+	defer createQueue(ctx, t, conn, qName, "", ", multiple_consumers=>TRUE", "")()
+	q, err := goracle.NewQueue(ctx, conn, qName, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+	ps, err := goracle.NewPubSub(q)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	topics := map[string]string{"orders": "ORDERS_SUB", "invoices": "INVOICES_SUB"}
+	for topic, sub := range topics {
+		if err = ps.AddSubscriber(ctx, topic, sub); err != nil {
+			t.Fatal(err)
+		}
+		// Adding again is not an error.
+		if err = ps.AddSubscriber(ctx, topic, sub); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i < 3; i++ {
+		for topic := range topics {
+			if _, err = ps.Publish(topic, []byte(fmt.Sprintf("%s-%d", topic, i))); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	for topic, sub := range topics {
+		var got []string
+		subCtx, subCancel := context.WithTimeout(ctx, 3*time.Second)
+		err = ps.Subscribe(subCtx, topic, sub, func(m goracle.Message) error {
+			if m.Correlation != topic {
+				t.Errorf("%s got a message of %q", sub, m.Correlation)
+			}
+			got = append(got, string(m.Raw))
+			return nil
+		})
+		subCancel()
+		if err != context.DeadlineExceeded {
+			t.Errorf("%s: got %+v, wanted DeadlineExceeded", sub, err)
+		}
+		sort.Strings(got)
+		want := []string{topic + "-0", topic + "-1", topic + "-2"}
+		if fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Errorf("%s: got %q, wanted %q", sub, got, want)
+		}
+	}
+}
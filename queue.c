@@ -0,0 +1,48 @@
+#include "dpiImpl.h"
+
+// ODPI-C does not expose the sequence deviation of the enqueue options, so these
+// access the OCI attributes directly, the same way the dpiEnqOptions functions do.
+
+#define OCI_ATTR_SEQUENCE_DEVIATION 39
+#define OCI_ATTR_RELATIVE_MSGID 48
+
+int EnqOptionsGetSequenceDeviation(dpiEnqOptions *options, uint32_t *deviation, const char **relMsgId, uint32_t *relMsgIdLength) {
+	void *rawValue = NULL;
+	dpiError error;
+
+	if (dpiGen__startPublicFn(options, DPI_HTYPE_ENQ_OPTIONS, __func__, &error) < 0)
+		return dpiGen__endPublicFn(options, DPI_FAILURE, &error);
+	if (dpiOci__attrGet(options->handle, DPI_OCI_DTYPE_AQENQ_OPTIONS, deviation, NULL,
+			OCI_ATTR_SEQUENCE_DEVIATION, "get sequence deviation", &error) < 0)
+		return dpiGen__endPublicFn(options, DPI_FAILURE, &error);
+	*relMsgId = NULL;
+	*relMsgIdLength = 0;
+	if (dpiOci__attrGet(options->handle, DPI_OCI_DTYPE_AQENQ_OPTIONS, &rawValue, NULL,
+			OCI_ATTR_RELATIVE_MSGID, "get relative msgid", &error) < 0)
+		return dpiGen__endPublicFn(options, DPI_FAILURE, &error);
+	if (rawValue) {
+		dpiOci__rawPtr(options->env->handle, rawValue, (void**) relMsgId);
+		dpiOci__rawSize(options->env->handle, rawValue, relMsgIdLength);
+	}
+	return dpiGen__endPublicFn(options, DPI_SUCCESS, &error);
+}
+
+int EnqOptionsSetSequenceDeviation(dpiEnqOptions *options, uint32_t deviation, const char *relMsgId, uint32_t relMsgIdLength) {
+	void *rawValue = NULL;
+	dpiError error;
+	int status;
+
+	if (dpiGen__startPublicFn(options, DPI_HTYPE_ENQ_OPTIONS, __func__, &error) < 0)
+		return dpiGen__endPublicFn(options, DPI_FAILURE, &error);
+	if (dpiOci__attrSet(options->handle, DPI_OCI_DTYPE_AQENQ_OPTIONS, &deviation, 0,
+			OCI_ATTR_SEQUENCE_DEVIATION, "set sequence deviation", &error) < 0)
+		return dpiGen__endPublicFn(options, DPI_FAILURE, &error);
+	if (relMsgIdLength == 0)
+		return dpiGen__endPublicFn(options, DPI_SUCCESS, &error);
+	if (dpiOci__rawAssignBytes(options->env->handle, relMsgId, relMsgIdLength, &rawValue, &error) < 0)
+		return dpiGen__endPublicFn(options, DPI_FAILURE, &error);
+	status = dpiOci__attrSet(options->handle, DPI_OCI_DTYPE_AQENQ_OPTIONS, rawValue, 0,
+			OCI_ATTR_RELATIVE_MSGID, "set relative msgid", &error);
+	dpiOci__rawResize(options->env->handle, &rawValue, 0, &error);
+	return dpiGen__endPublicFn(options, status, &error);
+}
@@ -18,12 +18,29 @@ package goracle
 /*
 #include <stdlib.h>
 #include "dpiImpl.h"
+
+int EnqOptionsGetSequenceDeviation(dpiEnqOptions *options, uint32_t *deviation, const char **relMsgId, uint32_t *relMsgIdLength);
+int EnqOptionsSetSequenceDeviation(dpiEnqOptions *options, uint32_t deviation, const char *relMsgId, uint32_t relMsgIdLength);
 */
 import "C"
 import (
+	"bytes"
+	"compress/gzip"
 	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"reflect"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
+	"unicode"
 	"unsafe"
 
 	"github.com/pkg/errors"
@@ -33,168 +50,3759 @@ const MsgIDLength = 16
 
 var zeroMsgID [MsgIDLength]byte
 
+var zeroByte [1]byte
+
 // Queue represents an Oracle Advanced Queue.
+//
+// A Queue is bound to one connection: its methods are serialized,
+// so concurrent Enqueue calls on the same Queue do not run in parallel.
+// Concurrent enqueues on different connections acquired from the same pool
+// may hit Oracle bug 29928074 - use PooledQueue for that, which enqueues one message at a time.
 type Queue struct {
 	*conn
-	dpiQueue *C.dpiQueue
-	name     string
+	dpiQueue    *C.dpiQueue
+	name        string
+	execer      Execer
+	payloadType *ObjectType
+	enqTZ       *time.Location
+	observer    func(QueueEvent)
+	waitCap     uint32
+	keepAlive   uint32
+	maxRawSize  int
+	// redact omits the payloads from the error messages, see SetRedactPayloads.
+	redact bool
+	// copyPayloads copies the RAW payloads to payloadBuf before enqueueing them, see SetCopyPayloads.
+	copyPayloads bool
+	payloadBuf   []byte
+	// compressMin is the RAW payload size from which Enqueue compresses it, see SetCompression.
+	compressMin int
+	gzw         *gzip.Writer
+	// lookupEnqueued looks up the enqueue time of the enqueued messages, see SetEnqueuedLookup.
+	lookupEnqueued bool
+	// dedup skips the messages whose Correlation is already in the queue table, see SetDedupByCorrelation.
+	dedup bool
+	// dequeueHeaders splits the Headers envelope off the dequeued RAW payloads, see SetDequeueHeaders.
+	dequeueHeaders bool
+	// consumer is the default DeqOptions.Consumer, see NewMultiConsumerQueue.
+	consumer string
+	// grouping is the message grouping of the queue table (NONE or TRANSACTIONAL), looked up once for NavNextTran.
+	grouping     string
+	groupingOnce sync.Once
+	// enqDeliveryMode and deqDeliveryMode are the last EnqOptions.DeliveryMode and DeqOptions.DeliveryMode set,
+	// as ODPI-C cannot read them back.
+	enqDeliveryMode, deqDeliveryMode DeliveryMode
+	// transformTypes are the target types of the dequeue transformations, see deqPayloadType.
+	transformTypes map[string]*ObjectType
+	// deqApplied is the last DeqOptions set by SetDeqOptions, so the unchanged options are not set again.
+	// The direct changes of the dequeue options must be restored (or deqApplied cleared).
+	deqApplied *DeqOptions
+
+	// deqBusy is non-zero while a dequeue call is in progress, see Close.
+	deqBusy int32
+	// connLost is non-zero after an operation failed with a lost connection, see IsValid.
+	connLost int32
+	// lastMsgID is the MsgID of the last dequeued message, see LastMsgID.
+	lastMsgID [MsgIDLength]byte
+	// lastErr is the error of the last failed operation, cleared by a successful enqueue or dequeue, see LastError.
+	lastErrMu sync.Mutex
+	lastErr   error
 
-	mu    sync.Mutex
-	props []*C.dpiMsgProps
+	mu            sync.Mutex
+	props         []*C.dpiMsgProps
+	propsPool     []*C.dpiMsgProps
+	propsPoolSize int
 }
 
 // NewQueue creates a new Queue.
 //
 // WARNING: the connection given to it must not be closed before the Queue is closed!
 // So use an sql.Conn for it.
+//
+// The payload ObjectType is cached per connection, so creating many queues of the same type
+// on the same connection looks it up in the data dictionary only once.
+//
+// Both name and payloadObjectTypeName can be schema-qualified ("OWNER.QUEUE", "OWNER.TYPE"),
+// for using a queue or type of another schema. Nonquoted parts are case insensitive,
+// double-quoted ones ("Owner"."Queue") are taken as is. A malformed name returns ErrInvalidName.
+//
+// The native JSON payload type (Oracle 21c) is not supported by the ODPI-C version used,
+// so a "JSON" payloadObjectTypeName returns ErrNotSupported.
 func NewQueue(ctx context.Context, execer Execer, name string, payloadObjectTypeName string) (*Queue, error) {
+	if strings.EqualFold(payloadObjectTypeName, "JSON") || strings.EqualFold(payloadObjectTypeName, "SYS.JSON") {
+		return nil, errors.Wrapf(ErrNotSupported, "queue %s: native JSON payload", name)
+	}
+	name, err := canonicalName(name)
+	if err != nil {
+		return nil, errors.WithMessage(err, "queue name")
+	}
+	if payloadObjectTypeName != "" {
+		if payloadObjectTypeName, err = canonicalName(payloadObjectTypeName); err != nil {
+			return nil, errors.WithMessage(err, "payload type name")
+		}
+	}
 	cx, err := DriverConn(ctx, execer)
 	if err != nil {
 		return nil, err
 	}
-	Q := Queue{conn: cx.(*conn)}
+	Q := Queue{conn: cx.(*conn), name: name, execer: execer, maxRawSize: MaxRawPayloadSize}
 
 	var payloadType *C.dpiObjectType
 	if payloadObjectTypeName != "" {
-		if objType, err := Q.conn.GetObjectType(payloadObjectTypeName); err != nil {
+		if objType, err := Q.conn.getObjectTypeCached(payloadObjectTypeName); err != nil {
 			return nil, err
 		} else {
+			Q.payloadType = &objType
 			payloadType = objType.dpiObjectType
 		}
 	}
-	value := C.CString(name)
-	if C.dpiConn_newQueue(Q.conn.dpiConn, value, C.uint(len(name)), payloadType, &Q.dpiQueue) == C.DPI_FAILURE {
-		err = errors.WithMessage(Q.conn.drv.getError(), "newQueue "+name)
+	value := C.CString(name)
+	if C.dpiConn_newQueue(Q.conn.dpiConn, value, C.uint(len(name)), payloadType, &Q.dpiQueue) == C.DPI_FAILURE {
+		err = errors.WithMessage(Q.conn.drv.getError(), "newQueue "+name)
+	}
+	C.free(unsafe.Pointer(value))
+	return &Q, err
+}
+
+// NewMultiConsumerQueue creates a new Queue for a multi-consumer queue, dequeueing as the given consumer (subscriber).
+//
+// The consumer is used for every dequeue whose DeqOptions.Consumer is empty.
+// See NewQueue for the other parameters.
+func NewMultiConsumerQueue(ctx context.Context, execer Execer, name, payloadObjectTypeName, consumer string) (*Queue, error) {
+	Q, err := NewQueue(ctx, execer, name, payloadObjectTypeName)
+	if err != nil {
+		return Q, err
+	}
+	Q.consumer = consumer
+	if err = Q.setConsumer(); err != nil {
+		Q.Close()
+		return nil, err
+	}
+	return Q, nil
+}
+
+// setConsumer sets the Queue's consumer in the dequeue options, leaving the other options intact.
+func (Q *Queue) setConsumer() error {
+	if Q.consumer == "" {
+		return nil
+	}
+	var opts *C.dpiDeqOptions
+	if C.dpiQueue_getDeqOptions(Q.dpiQueue, &opts) == C.DPI_FAILURE {
+		return Q.wrapErr("setDeqOptions", errors.WithMessage(Q.drv.getError(), "getDeqOptions"))
+	}
+	value := C.CString(Q.consumer)
+	defer C.free(unsafe.Pointer(value))
+	if C.dpiDeqOptions_setConsumerName(opts, value, C.uint(len(Q.consumer))) == C.DPI_FAILURE {
+		return Q.wrapErr("setDeqOptions", errors.WithMessage(Q.drv.getError(), "setConsumerName"))
+	}
+	return nil
+}
+
+// Clone returns a new Queue for the same queue, on the same connection and with the same payload type,
+// but with its own (default) enqueue and dequeue options - except the consumer of NewMultiConsumerQueue.
+//
+// This allows several consumers (e.g. with different Correlation or Consumer) reading the same queue
+// on one connection without racing for the options, and without looking up the payload type again.
+// The location and observer settings are copied.
+//
+// The clone must be closed separately, before the connection.
+func (Q *Queue) Clone() (*Queue, error) {
+	Q.mu.Lock()
+	defer Q.mu.Unlock()
+	if Q.conn == nil {
+		return nil, errors.New("queue is closed")
+	}
+	clone := Queue{conn: Q.conn, name: Q.name, execer: Q.execer, payloadType: Q.payloadType,
+		enqTZ: Q.enqTZ, observer: Q.observer, waitCap: Q.waitCap, keepAlive: Q.keepAlive, maxRawSize: Q.maxRawSize,
+		consumer: Q.consumer, redact: Q.redact, copyPayloads: Q.copyPayloads,
+		lookupEnqueued: Q.lookupEnqueued, compressMin: Q.compressMin, dedup: Q.dedup, dequeueHeaders: Q.dequeueHeaders}
+	var payloadType *C.dpiObjectType
+	if Q.payloadType != nil {
+		payloadType = Q.payloadType.dpiObjectType
+	}
+	value := C.CString(Q.name)
+	defer C.free(unsafe.Pointer(value))
+	if C.dpiConn_newQueue(Q.conn.dpiConn, value, C.uint(len(Q.name)), payloadType, &clone.dpiQueue) == C.DPI_FAILURE {
+		return nil, errors.WithMessage(Q.conn.drv.getError(), "newQueue "+Q.name)
+	}
+	if err := clone.setConsumer(); err != nil {
+		clone.Close()
+		return nil, err
+	}
+	return &clone, nil
+}
+
+// Reset recreates the queue handle on the same connection, with the same payload type,
+// and the enqueue and dequeue options of the old handle (if they still can be read),
+// for a Queue whose handle is left unusable by an error (e.g. DPI-1002: invalid handle).
+//
+// If the connection itself is lost (see IsConnectionLost), Reset does not help:
+// the Queue must be recreated with NewQueue on a new connection.
+func (Q *Queue) Reset() error {
+	Q.mu.Lock()
+	defer Q.mu.Unlock()
+	if Q.conn == nil {
+		return Q.wrapErr("reset", errors.New("queue is closed"))
+	}
+	var E EnqOptions
+	var D DeqOptions
+	var enqErr, deqErr error = errors.New("no handle"), errors.New("no handle")
+	if Q.dpiQueue != nil {
+		E, enqErr = Q.EnqOptions()
+		D, deqErr = Q.deqOptions()
+		C.dpiQueue_release(Q.dpiQueue)
+		Q.dpiQueue = nil
+	}
+	Q.deqApplied = nil
+	var payloadType *C.dpiObjectType
+	if Q.payloadType != nil {
+		payloadType = Q.payloadType.dpiObjectType
+	}
+	value := C.CString(Q.name)
+	defer C.free(unsafe.Pointer(value))
+	if C.dpiConn_newQueue(Q.conn.dpiConn, value, C.uint(len(Q.name)), payloadType, &Q.dpiQueue) == C.DPI_FAILURE {
+		Q.dpiQueue = nil
+		return Q.wrapErr("reset", errors.WithMessage(Q.conn.drv.getError(), "newQueue "+Q.name))
+	}
+	if enqErr == nil {
+		if err := Q.SetEnqOptions(E); err != nil {
+			return err
+		}
+	}
+	if deqErr == nil {
+		return Q.setDeqOptions(D)
+	}
+	return Q.setConsumer()
+}
+
+// Close the queue.
+//
+// A dequeue in progress (e.g. waiting for a message with WaitForever) is broken first
+// (with dpiConn_breakExecution), so Close does not wait for it; the dequeue returns an error (ORA-01013).
+func (Q *Queue) Close() error {
+	if c := Q.conn; c != nil && atomic.LoadInt32(&Q.deqBusy) != 0 {
+		_ = c.Break()
+	}
+	Q.mu.Lock()
+	defer Q.mu.Unlock()
+	for _, p := range Q.propsPool {
+		C.dpiMsgProps_release(p)
+	}
+	Q.propsPool, Q.propsPoolSize = nil, 0
+	c, q := Q.conn, Q.dpiQueue
+	Q.conn, Q.dpiQueue = nil, nil
+	if q == nil {
+		return nil
+	}
+	if C.dpiQueue_release(q) == C.DPI_FAILURE {
+		return errors.WithMessage(c.getError(), "release")
+	}
+	return nil
+}
+
+// SetEnqueuedLocation sets the location the received messages' Enqueued time is interpreted in.
+//
+// Oracle returns the enqueue time without time zone, and by default it is interpreted in the
+// connection's time zone, which is a fixed offset detected at connect (so it does not follow DST).
+// Set it to time.UTC if the server returns UTC, or to a named location (time.LoadLocation)
+// to follow DST transitions. A nil loc restores the default.
+func (Q *Queue) SetEnqueuedLocation(loc *time.Location) {
+	Q.mu.Lock()
+	Q.enqTZ = loc
+	Q.mu.Unlock()
+}
+
+// Queue operations reported to the observer.
+const (
+	OpEnqueue = "enqueue"
+	OpDequeue = "dequeue"
+)
+
+// QueueEvent is reported to the observer after each enqueue and dequeue, see Queue.SetObserver.
+type QueueEvent struct {
+	Queue string
+	// Op is OpEnqueue or OpDequeue.
+	Op string
+	// Batch is the number of messages given to enqueue, or the room for dequeue.
+	Batch int
+	// Count is the number of messages enqueued or dequeued.
+	Count    int
+	Duration time.Duration
+	Err      error
+}
+
+// SetObserver sets the function called after each enqueue and dequeue (nil to remove).
+//
+// It is called synchronously, with the Queue locked, so it must be fast and must not use the Queue.
+func (Q *Queue) SetObserver(f func(QueueEvent)) {
+	Q.mu.Lock()
+	Q.observer = f
+	Q.mu.Unlock()
+}
+
+// Observer returns the function set by SetObserver, nil if none.
+func (Q *Queue) Observer() func(QueueEvent) {
+	Q.mu.Lock()
+	defer Q.mu.Unlock()
+	return Q.observer
+}
+
+// observe reports the operation to the observer, Q.mu must be held.
+func (Q *Queue) observe(op string, batch, count int, start time.Time, err error) {
+	if Q.observer == nil {
+		return
+	}
+	Q.observer(QueueEvent{
+		Queue: Q.name, Op: op, Batch: batch, Count: count,
+		Duration: time.Since(start), Err: err,
+	})
+}
+
+// QueueError is the error returned by the Queue's enqueue, dequeue and option methods,
+// naming the queue and the operation.
+//
+// errors.Cause returns the underlying error (e.g. *OraErr).
+type QueueError struct {
+	Queue, Op string
+	Err       error
+}
+
+func (qe *QueueError) Error() string {
+	return "queue " + qe.Queue + ": " + qe.Op + ": " + qe.Err.Error()
+}
+
+// Cause returns the underlying error.
+func (qe *QueueError) Cause() error { return qe.Err }
+
+// Fields returns the error's fields for structured logging: queue, op, error,
+// and the ORA error code as code, if the underlying error is an *OraErr.
+func (qe *QueueError) Fields() map[string]interface{} {
+	m := map[string]interface{}{"queue": qe.Queue, "op": qe.Op, "error": qe.Err.Error()}
+	if oe, ok := errors.Cause(qe.Err).(*OraErr); ok {
+		m["code"] = oe.Code()
+	}
+	return m
+}
+
+// wrapErr returns err as a *QueueError for op (recorded as LastError), or nil if err is nil.
+func (Q *Queue) wrapErr(op string, err error) error {
+	if err == nil {
+		return nil
+	}
+	if IsConnectionLost(err) {
+		atomic.StoreInt32(&Q.connLost, 1)
+	}
+	qe := &QueueError{Queue: Q.name, Op: op, Err: err}
+	Q.setLastError(qe)
+	return qe
+}
+
+// LastError returns the error of the last failed operation of the Queue, for diagnostics,
+// or nil if the last enqueue or dequeue succeeded: a successful Enqueue or Dequeue clears it.
+//
+// The errors are read right after the failed ODPI-C call, on the same OS thread (ODPI-C keeps
+// the error information per thread), so an error is never attributed to a later operation.
+func (Q *Queue) LastError() error {
+	Q.lastErrMu.Lock()
+	defer Q.lastErrMu.Unlock()
+	return Q.lastErr
+}
+
+func (Q *Queue) setLastError(err error) {
+	Q.lastErrMu.Lock()
+	Q.lastErr = err
+	Q.lastErrMu.Unlock()
+}
+
+// Commit the transaction of the queue's connection,
+// making the messages enqueued and dequeued with VisibleOnCommit final.
+//
+// It is a no-op for the messages enqueued or dequeued with VisibleImmediate,
+// as those are in a transaction of their own.
+func (Q *Queue) Commit() error {
+	if Q.conn == nil {
+		return errors.New("queue is closed")
+	}
+	return Q.conn.Commit()
+}
+
+// Rollback the transaction of the queue's connection,
+// undoing the enqueues and dequeues made with VisibleOnCommit:
+// the dequeued messages will be delivered again.
+//
+// It is a no-op for the messages enqueued or dequeued with VisibleImmediate.
+func (Q *Queue) Rollback() error {
+	if Q.conn == nil {
+		return errors.New("queue is closed")
+	}
+	return Q.conn.Rollback()
+}
+
+// Ping checks that the queue is usable, without side effects: it is not closed,
+// and its connection is alive (with a round trip to the server).
+//
+// A closed Queue or a lost connection is reported as driver.ErrBadConn, so IsConnectionLost reports true:
+// the Queue must be rebuilt on a new connection.
+func (Q *Queue) Ping(ctx context.Context) error {
+	Q.mu.Lock()
+	defer Q.mu.Unlock()
+	if Q.conn == nil || Q.dpiQueue == nil {
+		return Q.wrapErr("ping", errors.Wrap(driver.ErrBadConn, "queue is closed"))
+	}
+	return Q.wrapErr("ping", maybeBadConn(Q.conn.Ping(ctx)))
+}
+
+// IsValid reports whether the Queue is usable, without a round trip to the server:
+// it is not closed, its connection is not closed, and no operation on it failed
+// with a lost connection (see IsConnectionLost). Use Ping for a check with a round trip.
+//
+// It waits for the operation in progress on the Queue.
+func (Q *Queue) IsValid() bool {
+	if atomic.LoadInt32(&Q.connLost) != 0 {
+		return false
+	}
+	Q.mu.Lock()
+	c, q := Q.conn, Q.dpiQueue
+	Q.mu.Unlock()
+	if c == nil || q == nil {
+		return false
+	}
+	c.RLock()
+	defer c.RUnlock()
+	return c.dpiConn != nil
+}
+
+// Name of the queue.
+func (Q *Queue) Name() string { return Q.name }
+
+// Unwrap returns the underlying *C.dpiQueue handle, for calling ODPI-C functions not wrapped here.
+//
+// EXPERIMENTAL and UNSAFE: the handle is owned by the Queue, it is valid only till Queue.Close,
+// and must not be released by the caller (use dpiQueue_addRef/dpiQueue_release to keep it longer).
+// The Queue's methods must not be called concurrently with the calls using the handle.
+// Returns nil for a closed Queue.
+//
+// SetDeqOptions sets only the options changed since its last call: Unwrap makes the next SetDeqOptions set all of them,
+// so call Unwrap again after changing the dequeue options through a handle kept from an earlier call.
+func (Q *Queue) Unwrap() unsafe.Pointer {
+	Q.mu.Lock()
+	Q.deqApplied = nil
+	Q.mu.Unlock()
+	return unsafe.Pointer(Q.dpiQueue)
+}
+
+// PayloadType returns the ObjectType of the queue's payload, resolved once (at NewQueue), or nil for a RAW queue.
+//
+// Create the payload objects of a batch with its NewObject, without looking up the type again.
+// The ObjectType is owned by the Queue (and cached on its connection): do not close it.
+func (Q *Queue) PayloadType() *ObjectType { return Q.payloadType }
+
+// payloadTypeName returns the name of the payload type: RAW or the object type's full name.
+func (Q *Queue) payloadTypeName() string {
+	if Q.payloadType == nil {
+		return "RAW"
+	}
+	return Q.payloadType.FullName()
+}
+
+// QueueStats is returned by Queue.Stats.
+type QueueStats struct {
+	Name, PayloadType string
+	EnqOptions        EnqOptions
+	DeqOptions        DeqOptions
+	Counts            MessageCounts
+}
+
+// Stats returns the queue's name, payload type, enqueue and dequeue options in effect,
+// and the message counts.
+func (Q *Queue) Stats(ctx context.Context) (QueueStats, error) {
+	S := QueueStats{Name: Q.Name(), PayloadType: Q.payloadTypeName()}
+	var err error
+	if S.EnqOptions, err = Q.EnqOptions(); err != nil {
+		return S, err
+	}
+	if S.DeqOptions, err = Q.DeqOptions(); err != nil {
+		return S, err
+	}
+	S.Counts, err = Q.Counts(ctx)
+	return S, err
+}
+
+// MessageCounts holds the number of messages in each state.
+type MessageCounts struct {
+	Ready, Waiting, Processed, Expired int
+}
+
+// Counts returns the number of messages in the queue in each state,
+// as seen by the queue's connection.
+//
+// For multi-consumer queues this counts the messages, not the per-subscriber copies.
+func (Q *Queue) Counts(ctx context.Context) (MessageCounts, error) {
+	var counts MessageCounts
+	owner, table, err := Q.QueueTable(ctx)
+	if err != nil {
+		return counts, err
+	}
+	qr, err := Q.querier()
+	if err != nil {
+		return counts, err
+	}
+	_, name := splitQueueName(Q.name)
+	qry := `SELECT state, COUNT(0) FROM "` + owner + `"."` + table + `" WHERE q_name = :1 GROUP BY state`
+	rows, err := qr.QueryContext(ctx, qry, name)
+	if err != nil {
+		return counts, errors.Wrap(err, qry)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var state int32
+		var n int
+		if err = rows.Scan(&state, &n); err != nil {
+			return counts, errors.Wrap(err, qry)
+		}
+		switch MessageState(state) {
+		case MsgStateReady:
+			counts.Ready = n
+		case MsgStateWaiting:
+			counts.Waiting = n
+		case MsgStateProcessed:
+			counts.Processed = n
+		case MsgStateExpired:
+			counts.Expired = n
+		}
+	}
+	return counts, rows.Err()
+}
+
+// CountMatching returns the number of ready messages in the queue which match the condition,
+// as seen by the queue's connection - for example to check whether there is any work for a condition-based consumer.
+//
+// The condition is an SQL boolean expression, in the syntax of DeqOptions.Condition: it can refer to the
+// message properties (tab.priority, tab.corrid, ...) and the payload attributes (tab.user_data.attr),
+// as it is used in the WHERE clause of a query on the queue table, aliased as tab.
+// An empty condition counts all the ready messages. The condition is SQL text, so it must not come from untrusted input.
+//
+// For multi-consumer queues this counts the messages, not the per-subscriber copies.
+func (Q *Queue) CountMatching(ctx context.Context, condition string) (int, error) {
+	owner, table, err := Q.QueueTable(ctx)
+	if err != nil {
+		return 0, err
+	}
+	qr, err := Q.querier()
+	if err != nil {
+		return 0, err
+	}
+	_, name := splitQueueName(Q.name)
+	qry := `SELECT COUNT(0) FROM "` + owner + `"."` + table + `" tab WHERE tab.q_name = :1 AND tab.state = :2`
+	if condition != "" {
+		qry += " AND (" + condition + ")"
+	}
+	var n int
+	if err = qr.QueryRowContext(ctx, qry, name, int32(MsgStateReady)).Scan(&n); err != nil {
+		return 0, Q.wrapErr("countMatching", errors.Wrap(err, qry))
+	}
+	return n, nil
+}
+
+// WaitEmpty polls Counts every poll interval (a second if not positive) till the queue has no ready messages,
+// or ctx is done. The waiting and processed messages are not counted.
+func (Q *Queue) WaitEmpty(ctx context.Context, poll time.Duration) error {
+	if poll <= 0 {
+		poll = time.Second
+	}
+	ticker := time.NewTicker(poll)
+	defer ticker.Stop()
+	for {
+		counts, err := Q.Counts(ctx)
+		if err != nil {
+			if ctxErr := ctx.Err(); ctxErr != nil {
+				return ctxErr
+			}
+			return err
+		}
+		if counts.Ready == 0 {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// ErrQueueNotFound is returned when the queue is not found in the data dictionary.
+var ErrQueueNotFound = errors.New("queue not found")
+
+// QueueTable returns the owner and the name of the queue table backing the queue,
+// from the ALL_QUEUES dictionary view.
+//
+// Returns ErrQueueNotFound if the queue does not exist, or is not visible to the user.
+func (Q *Queue) QueueTable(ctx context.Context) (owner, table string, err error) {
+	qr, err := Q.querier()
+	if err != nil {
+		return "", "", err
+	}
+	owner, name := splitQueueName(Q.name)
+	const qry = "SELECT owner, queue_table FROM all_queues WHERE owner = NVL(:1, USER) AND name = :2"
+	if err = qr.QueryRowContext(ctx, qry, owner, name).Scan(&owner, &table); err != nil {
+		if err == sql.ErrNoRows {
+			return "", "", errors.Wrap(ErrQueueNotFound, Q.name)
+		}
+		return "", "", errors.Wrapf(err, "%s [%q, %q]", qry, owner, name)
+	}
+	return owner, table, nil
+}
+
+// RetainForever is the QueueConfig.Retention of queues keeping the processed messages forever.
+const RetainForever = time.Duration(-1)
+
+// QueueConfig is the configuration of a queue, set at its creation (or with DBMS_AQADM.alter_queue).
+type QueueConfig struct {
+	// Retention is how long the processed messages are kept in the queue table, RetainForever for infinite.
+	Retention time.Duration
+	// MaxRetries is the number of dequeue attempts (rollbacks) before a message is moved to the exception queue.
+	MaxRetries int
+	// RetryDelay is the delay before a rolled back message can be dequeued again.
+	RetryDelay time.Duration
+}
+
+// Config returns the queue's configuration, from the ALL_QUEUES dictionary view.
+//
+// AQ has no queue level default delay or expiration: those are per message (see Message).
+// Returns ErrQueueNotFound if the queue does not exist, or is not visible to the user.
+func (Q *Queue) Config(ctx context.Context) (QueueConfig, error) {
+	var cfg QueueConfig
+	qr, err := Q.querier()
+	if err != nil {
+		return cfg, err
+	}
+	owner, name := splitQueueName(Q.name)
+	const qry = `SELECT TRIM(retention), NVL(max_retries, 0), NVL(retry_delay, 0)
+		FROM all_queues WHERE owner = NVL(:1, USER) AND name = :2`
+	var retention string
+	var retryDelay float64
+	if err = qr.QueryRowContext(ctx, qry, owner, name).Scan(&retention, &cfg.MaxRetries, &retryDelay); err != nil {
+		if err == sql.ErrNoRows {
+			return cfg, errors.Wrap(ErrQueueNotFound, Q.name)
+		}
+		return cfg, errors.Wrapf(err, "%s [%q, %q]", qry, owner, name)
+	}
+	cfg.RetryDelay = time.Duration(retryDelay * float64(time.Second))
+	if strings.EqualFold(retention, "FOREVER") {
+		cfg.Retention = RetainForever
+	} else if secs, err := strconv.ParseInt(retention, 10, 64); err != nil {
+		return cfg, errors.Wrapf(err, "parse retention %q", retention)
+	} else {
+		cfg.Retention = time.Duration(secs) * time.Second
+	}
+	return cfg, nil
+}
+
+// querier returns the Execer given to NewQueue as a Querier.
+func (Q *Queue) querier() (queryRower, error) {
+	if qr, ok := Q.execer.(queryRower); ok {
+		return qr, nil
+	}
+	return nil, errors.Errorf("%T is not a Querier", Q.execer)
+}
+
+type queryRower interface {
+	Querier
+	QueryRowContext(context.Context, string, ...interface{}) *sql.Row
+}
+
+// splitQueueName splits the (maybe schema-qualified) queue name to owner and name,
+// as stored in the data dictionary (see parseQualifiedName). The owner is empty if the name is unqualified.
+func splitQueueName(s string) (owner, name string) {
+	owner, name, _ = parseQualifiedName(s)
+	return owner, name
+}
+
+// ErrInvalidName is returned for a malformed queue or object type name.
+var ErrInvalidName = errors.New("invalid name")
+
+// parseQualifiedName parses the (maybe schema-qualified) name of a queue or an object type: [owner.]name,
+// where each part is either a simple identifier (case insensitive, returned uppercased),
+// or a double-quoted one (returned as is, without the quotes).
+func parseQualifiedName(s string) (owner, name string, err error) {
+	var parts []string
+	for rest := s; ; {
+		var part string
+		if strings.HasPrefix(rest, `"`) {
+			i := strings.IndexByte(rest[1:], '"')
+			if i < 1 {
+				return "", "", errors.Wrapf(ErrInvalidName, "%q: unterminated or empty quoted identifier", s)
+			}
+			part, rest = rest[1:i+1], rest[i+2:]
+		} else {
+			i := strings.IndexByte(rest, '.')
+			if i < 0 {
+				i = len(rest)
+			}
+			part, rest = strings.ToUpper(rest[:i]), rest[i:]
+			if !isSimpleIdent(part) {
+				return "", "", errors.Wrapf(ErrInvalidName, "%q: %q is not an identifier", s, part)
+			}
+		}
+		parts = append(parts, part)
+		if rest == "" {
+			break
+		}
+		if rest[0] != '.' || len(parts) == 2 {
+			return "", "", errors.Wrapf(ErrInvalidName, "%q: wanted [owner.]name", s)
+		}
+		rest = rest[1:]
+	}
+	if len(parts) == 1 {
+		return "", parts[0], nil
+	}
+	return parts[0], parts[1], nil
+}
+
+// isSimpleIdent reports whether s is a nonquoted Oracle identifier:
+// a letter, followed by letters, digits, _, $ or #.
+func isSimpleIdent(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i, r := range s {
+		if !(unicode.IsLetter(r) || i > 0 && (unicode.IsDigit(r) || r == '_' || r == '$' || r == '#')) {
+			return false
+		}
+	}
+	return true
+}
+
+// qualifiedName returns the canonical form of the (maybe schema-qualified) name,
+// quoting the parts which are not simple uppercase identifiers.
+func qualifiedName(owner, name string) string {
+	quote := func(s string) string {
+		if isSimpleIdent(s) && s == strings.ToUpper(s) {
+			return s
+		}
+		return `"` + s + `"`
+	}
+	if owner == "" {
+		return quote(name)
+	}
+	return quote(owner) + "." + quote(name)
+}
+
+// canonicalName validates the (maybe schema-qualified) name, and returns its canonical form.
+func canonicalName(s string) (string, error) {
+	owner, name, err := parseQualifiedName(s)
+	if err != nil {
+		return "", err
+	}
+	return qualifiedName(owner, name), nil
+}
+
+// EnqOptions returns the queue's enqueue options in effect.
+//
+// ODPI-C has no getter for the delivery mode, so DeliveryMode is the one last set with SetEnqOptions,
+// DeliverPersistent (the Oracle default) if none was set.
+func (Q *Queue) EnqOptions() (EnqOptions, error) {
+	var E EnqOptions
+	var opts *C.dpiEnqOptions
+	if C.dpiQueue_getEnqOptions(Q.dpiQueue, &opts) == C.DPI_FAILURE {
+		return E, Q.wrapErr("getEnqOptions", Q.drv.getError())
+	}
+	err := E.fromOra(Q.conn.drv, opts)
+	if E.DeliveryMode = Q.enqDeliveryMode; E.DeliveryMode == 0 {
+		E.DeliveryMode = DeliverPersistent
+	}
+	return E, Q.wrapErr("getEnqOptions", err)
+}
+
+// DeqOptions returns the queue's dequeue options in effect.
+func (Q *Queue) DeqOptions() (DeqOptions, error) {
+	Q.mu.Lock()
+	defer Q.mu.Unlock()
+	return Q.deqOptions()
+}
+
+// deqOptions returns the dequeue options in effect, Q.mu must be held.
+func (Q *Queue) deqOptions() (DeqOptions, error) {
+	var D DeqOptions
+	var opts *C.dpiDeqOptions
+	if C.dpiQueue_getDeqOptions(Q.dpiQueue, &opts) == C.DPI_FAILURE {
+		return D, Q.wrapErr("getDeqOptions", Q.drv.getError())
+	}
+	err := D.fromOra(Q.conn.drv, opts)
+	if D.DeliveryMode = Q.deqDeliveryMode; D.DeliveryMode == 0 {
+		D.DeliveryMode = DeliverPersistent
+	}
+	return D, Q.wrapErr("getDeqOptions", err)
+}
+
+// SetEnqOptions sets all the enqueue options.
+func (Q *Queue) SetEnqOptions(E EnqOptions) error {
+	if err := E.Validate(); err != nil {
+		return Q.wrapErr("setEnqOptions", err)
+	}
+	var opts *C.dpiEnqOptions
+	if C.dpiQueue_getEnqOptions(Q.dpiQueue, &opts) == C.DPI_FAILURE {
+		return Q.wrapErr("setEnqOptions", Q.drv.getError())
+	}
+	if err := E.toOra(Q.conn.drv, opts); err != nil {
+		return Q.wrapErr("setEnqOptions", err)
+	}
+	if E.DeliveryMode != 0 {
+		Q.enqDeliveryMode = E.DeliveryMode
+	}
+	return nil
+}
+
+// SetDeqOptions sets all the dequeue options.
+//
+// An empty Consumer is replaced with the Queue's consumer, see NewMultiConsumerQueue.
+//
+// Only the options changed since the last SetDeqOptions call are set in ODPI-C,
+// so a consumer loop can set the same options before each dequeue cheaply.
+// The dequeue options changed through the handle of Unwrap are not noticed: see Unwrap.
+//
+// NavNextTran needs a queue table created with transactional message grouping: for other queues,
+// SetDeqOptions returns an ErrInvalidOptions error (if the Execer given to NewQueue can query the data dictionary),
+// instead of the ORA-25237 of the next dequeue.
+func (Q *Queue) SetDeqOptions(D DeqOptions) error {
+	Q.mu.Lock()
+	defer Q.mu.Unlock()
+	return Q.setDeqOptions(D)
+}
+
+// setDeqOptions sets the dequeue options, Q.mu must be held.
+func (Q *Queue) setDeqOptions(D DeqOptions) error {
+	if err := D.Validate(); err != nil {
+		return Q.wrapErr("setDeqOptions", err)
+	}
+	if err := Q.checkNavigation(D.Navigation); err != nil {
+		return Q.wrapErr("setDeqOptions", err)
+	}
+	if D.Consumer == "" {
+		D.Consumer = Q.consumer
+	}
+	var opts *C.dpiDeqOptions
+	if C.dpiQueue_getDeqOptions(Q.dpiQueue, &opts) == C.DPI_FAILURE {
+		return Q.wrapErr("setDeqOptions", Q.drv.getError())
+	}
+	if err := D.toOra(Q.conn.drv, opts, Q.deqApplied); err != nil {
+		// Some of the options may have been set: set all of them the next time.
+		Q.deqApplied = nil
+		return Q.wrapErr("setDeqOptions", err)
+	}
+	Q.deqApplied = &D
+	if Q.deqDeliveryMode = D.DeliveryMode; Q.deqDeliveryMode == 0 {
+		Q.deqDeliveryMode = DeliverPersistent
+	}
+	return nil
+}
+
+// checkNavigation returns an ErrInvalidOptions error for NavNextTran on a queue without transactional grouping.
+// If the grouping cannot be looked up, the check is left to Oracle.
+func (Q *Queue) checkNavigation(nav DeqNavigation) error {
+	if nav != NavNextTran {
+		return nil
+	}
+	Q.groupingOnce.Do(func() {
+		qr, err := Q.querier()
+		if err != nil {
+			return
+		}
+		owner, name := splitQueueName(Q.name)
+		const qry = `SELECT T.grouping
+			FROM all_queues Q, all_queue_tables T
+			WHERE Q.owner = NVL(:1, USER) AND Q.name = :2 AND
+				T.owner = Q.owner AND T.queue_table = Q.queue_table`
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err = qr.QueryRowContext(ctx, qry, owner, name).Scan(&Q.grouping); err != nil && Log != nil {
+			Log("msg", "queue grouping", "queue", Q.name, "error", err)
+		}
+	})
+	if Q.grouping == "" || strings.EqualFold(Q.grouping, "TRANSACTIONAL") {
+		return nil
+	}
+	return errors.Wrapf(ErrInvalidOptions,
+		"NavNextTran needs a queue table with transactional message grouping, queue %s has grouping %s",
+		Q.name, Q.grouping)
+}
+
+// Dequeues messages into the given slice.
+// Returns the number of messages filled in the given slice.
+func (Q *Queue) Dequeue(messages []Message) (int, error) {
+	Q.mu.Lock()
+	defer Q.mu.Unlock()
+	return Q.dequeue(messages, nil)
+}
+
+// DequeueUnsafe is Dequeue without locking the Queue.
+//
+// It is NOT safe for concurrent use: the caller must guarantee that no other goroutine
+// uses the Queue meanwhile - this includes Close, which cannot break a DequeueUnsafe waiting for messages.
+func (Q *Queue) DequeueUnsafe(messages []Message) (int, error) {
+	return Q.dequeue(messages, nil)
+}
+
+// SetWaitCap caps the wait of each underlying dequeue call made by DequeueContext to d,
+// rounded up to whole seconds. Zero disables the cap.
+func (Q *Queue) SetWaitCap(d time.Duration) {
+	Q.mu.Lock()
+	Q.waitCap = uint32((d + time.Second - 1) / time.Second)
+	Q.mu.Unlock()
+}
+
+// SetKeepAlive makes dequeues waiting longer than d (rounded up to whole seconds) check the connection
+// every d with a ping, so a connection dropped silently (e.g. by a firewall or NAT idle timeout)
+// is detected within about 2*d, instead of waiting forever.
+// The dequeue then returns the ping's error (IsConnectionLost reports true for it).
+// Zero disables the keep-alive.
+func (Q *Queue) SetKeepAlive(d time.Duration) {
+	Q.mu.Lock()
+	Q.keepAlive = uint32((d + time.Second - 1) / time.Second)
+	Q.mu.Unlock()
+}
+
+// DequeueContext dequeues messages into the given slice, just as Dequeue,
+// but checks ctx before each underlying dequeue call.
+//
+// If a wait cap is set with SetWaitCap, a longer DeqOptions.Wait (even WaitForever) is split
+// into dequeues waiting at most the cap, checking ctx between them,
+// so cancellation ends the wait within the cap interval.
+//
+// The trace id propagated by EnqueueContext is returned by Message.TraceContext.
+func (Q *Queue) DequeueContext(ctx context.Context, messages []Message) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	Q.mu.Lock()
+	defer Q.mu.Unlock()
+	if Q.waitCap == 0 {
+		return Q.dequeue(messages, nil)
+	}
+	var opts *C.dpiDeqOptions
+	if C.dpiQueue_getDeqOptions(Q.dpiQueue, &opts) == C.DPI_FAILURE {
+		return 0, Q.wrapErr(OpDequeue, errors.WithMessage(Q.drv.getError(), "getDeqOptions"))
+	}
+	var wait C.uint
+	if C.dpiDeqOptions_getWait(opts, &wait) == C.DPI_FAILURE {
+		return 0, Q.wrapErr(OpDequeue, errors.WithMessage(Q.drv.getError(), "getWait"))
+	}
+	if uint32(wait) <= Q.waitCap {
+		return Q.dequeue(messages, nil)
+	}
+	if C.dpiDeqOptions_setWait(opts, C.uint(Q.waitCap)) == C.DPI_FAILURE {
+		return 0, Q.wrapErr(OpDequeue, errors.WithMessage(Q.drv.getError(), "setWait"))
+	}
+	defer C.dpiDeqOptions_setWait(opts, wait)
+	for remaining := uint32(wait); ; {
+		n, err := Q.dequeue(messages, nil)
+		if n != 0 || err != nil {
+			return n, err
+		}
+		if uint32(wait) != WaitForever {
+			if remaining <= Q.waitCap {
+				return 0, nil
+			}
+			remaining -= Q.waitCap
+		}
+		if err = ctx.Err(); err != nil {
+			return 0, err
+		}
+	}
+}
+
+// DequeueAtLeast dequeues messages till at least n (but at most max) are collected, maxWait elapses, or ctx is done,
+// accumulating the messages of several dequeue calls, for micro-batching.
+//
+// The other dequeue options in effect are used, but Wait is set for each call to at most a second,
+// to check ctx and the deadline between the calls.
+// The messages dequeued so far are returned even with an error.
+func (Q *Queue) DequeueAtLeast(ctx context.Context, n, max int, maxWait time.Duration) ([]Message, error) {
+	if max < 1 {
+		max = 1
+	}
+	if n > max {
+		n = max
+	}
+	deadline := time.Now().Add(maxWait)
+	Q.mu.Lock()
+	defer Q.mu.Unlock()
+	var opts *C.dpiDeqOptions
+	if C.dpiQueue_getDeqOptions(Q.dpiQueue, &opts) == C.DPI_FAILURE {
+		return nil, Q.wrapErr(OpDequeue, errors.WithMessage(Q.drv.getError(), "getDeqOptions"))
+	}
+	var wait C.uint
+	if C.dpiDeqOptions_getWait(opts, &wait) == C.DPI_FAILURE {
+		return nil, Q.wrapErr(OpDequeue, errors.WithMessage(Q.drv.getError(), "getWait"))
+	}
+	defer C.dpiDeqOptions_setWait(opts, wait)
+
+	msgs := make([]Message, max)
+	var got int
+	for got < n {
+		if err := ctx.Err(); err != nil {
+			return msgs[:got], err
+		}
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			break
+		}
+		var w C.uint
+		if remaining >= time.Second {
+			w = 1
+		}
+		if C.dpiDeqOptions_setWait(opts, w) == C.DPI_FAILURE {
+			return msgs[:got], Q.wrapErr(OpDequeue, errors.WithMessage(Q.drv.getError(), "setWait"))
+		}
+		k, err := Q.dequeue(msgs[got:], nil)
+		got += k
+		if err != nil {
+			return msgs[:got], err
+		}
+		if k == 0 && w == 0 {
+			if remaining > consumePollInterval {
+				remaining = consumePollInterval
+			}
+			time.Sleep(remaining)
+		}
+	}
+	return msgs[:got], nil
+}
+
+// DequeueWith sets the dequeue options (which remain in effect) and dequeues messages into the given slice.
+//
+// With DeqLocked, concurrent dequeuers (on different connections) skip the messages locked by each other,
+// as with SELECT FOR UPDATE SKIP LOCKED. The lock is held till the end of the transaction.
+func (Q *Queue) DequeueWith(messages []Message, D DeqOptions) (int, error) {
+	Q.mu.Lock()
+	defer Q.mu.Unlock()
+	if err := Q.setDeqOptions(D); err != nil {
+		return 0, err
+	}
+	return Q.dequeue(messages, nil)
+}
+
+// DequeueAck dequeues at most max messages locked (DeqLocked, in a transaction), calls handler for each in turn,
+// and acknowledges (removes by MsgID) each message handler succeeded with.
+// Returns the number of acknowledged messages.
+//
+// At the first handler error (or when ctx is done), the rest of the messages is not handled, and the error is returned.
+// In any case, the transaction is committed at the end: the acknowledged messages are removed,
+// the others are unlocked, to be delivered again (without incrementing their NumAttempts).
+// The other dequeue options in effect (correlation, condition, consumer, ...) are used, and kept.
+func (Q *Queue) DequeueAck(ctx context.Context, max int, handler func(Message) error) (int, error) {
+	if max < 1 {
+		max = 1
+	}
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	Q.mu.Lock()
+	defer Q.mu.Unlock()
+	D, err := Q.deqOptions()
+	if err != nil {
+		return 0, err
+	}
+	defer Q.setDeqOptions(D)
+	L := D
+	L.Mode, L.Visibility, L.MsgID = DeqLocked, VisibleOnCommit, ""
+	if err = Q.setDeqOptions(L); err != nil {
+		return 0, err
+	}
+	msgs := make([]Message, max)
+	n, err := Q.dequeue(msgs, nil)
+	if err != nil || n == 0 {
+		return 0, err
+	}
+
+	ack := DeqOptions{Consumer: L.Consumer, DeliveryMode: L.DeliveryMode,
+		Mode: DeqConfirm, Navigation: NavFirst, Visibility: VisibleOnCommit, Wait: NoWait}
+	one := make([]Message, 1)
+	var acked int
+	var handlerErr error
+	for i, m := range msgs[:n] {
+		if handlerErr = ctx.Err(); handlerErr != nil {
+			break
+		}
+		if handlerErr = handler(m); handlerErr != nil {
+			handlerErr = errors.WithMessage(handlerErr, fmt.Sprintf("%d. message", i))
+			break
+		}
+		ack.MsgID = string(m.MsgID[:])
+		if err = Q.setDeqOptions(ack); err != nil {
+			handlerErr = err
+			break
+		}
+		k, err := Q.dequeue(one, nil)
+		if err != nil {
+			handlerErr = errors.WithMessage(err, fmt.Sprintf("acknowledge %x", m.MsgID))
+			break
+		}
+		acked += k
+	}
+	if err = Q.conn.Commit(); err != nil {
+		return 0, Q.wrapErr(OpDequeue, errors.WithMessage(err, "commit"))
+	}
+	return acked, handlerErr
+}
+
+// RemoveNoData removes one message from the queue, dequeueing it into msg with DeqConfirm
+// (and the other dequeue options in effect), so only the message's metadata (correlation, priority, state, ...)
+// is transferred, not the payload. Reports whether a message was found.
+//
+// For a non-destructive look at the queue's head, see Head.
+func (Q *Queue) RemoveNoData(msg *Message) (bool, error) {
+	Q.mu.Lock()
+	defer Q.mu.Unlock()
+	var opts *C.dpiDeqOptions
+	if C.dpiQueue_getDeqOptions(Q.dpiQueue, &opts) == C.DPI_FAILURE {
+		return false, Q.wrapErr(OpDequeue, errors.WithMessage(Q.drv.getError(), "getDeqOptions"))
+	}
+	var mode C.dpiDeqMode
+	if C.dpiDeqOptions_getMode(opts, &mode) == C.DPI_FAILURE {
+		return false, Q.wrapErr(OpDequeue, errors.WithMessage(Q.drv.getError(), "getMode"))
+	}
+	if C.dpiDeqOptions_setMode(opts, C.dpiDeqMode(DeqConfirm)) == C.DPI_FAILURE {
+		return false, Q.wrapErr(OpDequeue, errors.WithMessage(Q.drv.getError(), "setMode"))
+	}
+	defer C.dpiDeqOptions_setMode(opts, mode)
+	msgs := []Message{*msg}
+	n, err := Q.dequeue(msgs, nil)
+	if n == 1 {
+		*msg = msgs[0]
+	}
+	return n == 1, err
+}
+
+// Head returns the message at the head of the queue (the oldest ready one, in the queue's sort order),
+// without removing it, for example to compute the queue's lag from its Enqueued time.
+// Returns nil if the queue has no ready message.
+//
+// The message is browsed (DeqBrowse, NavFirst, NoWait) with the other dequeue options in effect
+// (consumer, correlation, condition), which are kept. The head's Object (if any) must be closed after use.
+// Head does not change LastMsgID, but it moves the browse cursor to the head.
+func (Q *Queue) Head(ctx context.Context) (*Message, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	Q.mu.Lock()
+	defer Q.mu.Unlock()
+	D, err := Q.deqOptions()
+	if err != nil {
+		return nil, err
+	}
+	defer Q.setDeqOptions(D)
+	H := D
+	H.Mode, H.Navigation, H.Wait, H.MsgID = DeqBrowse, NavFirst, NoWait, ""
+	if err = Q.setDeqOptions(H); err != nil {
+		return nil, err
+	}
+	lastMsgID := Q.lastMsgID
+	defer func() { Q.lastMsgID = lastMsgID }()
+	msgs := make([]Message, 1)
+	if n, err := Q.dequeue(msgs, nil); err != nil || n == 0 {
+		return nil, err
+	}
+	return &msgs[0], nil
+}
+
+// DequeueOriginal dequeues the message with the given OriginalMsgID into msg,
+// for example to reprocess a failed message from an exception queue.
+// Reports whether such a message was found.
+//
+// The message is looked up in the queue table's AQ$ view, then dequeued by its MsgID,
+// with the other dequeue options in effect (a Correlation or Condition still applies).
+func (Q *Queue) DequeueOriginal(ctx context.Context, originalMsgID [MsgIDLength]byte, msg *Message) (bool, error) {
+	owner, table, err := Q.QueueTable(ctx)
+	if err != nil {
+		return false, err
+	}
+	qr, err := Q.querier()
+	if err != nil {
+		return false, err
+	}
+	_, name := splitQueueName(Q.name)
+	qry := `SELECT msg_id FROM "` + owner + `"."AQ$` + table + `" WHERE queue = :1 AND original_msgid = :2 AND ROWNUM = 1`
+	var msgID []byte
+	if err = qr.QueryRowContext(ctx, qry, name, originalMsgID[:]).Scan(&msgID); err != nil {
+		if err == sql.ErrNoRows {
+			return false, nil
+		}
+		return false, errors.Wrapf(err, "%s [%q, %x]", qry, name, originalMsgID)
+	}
+	if len(msgID) == 0 {
+		return false, nil
+	}
+
+	Q.mu.Lock()
+	defer Q.mu.Unlock()
+	var opts *C.dpiDeqOptions
+	if C.dpiQueue_getDeqOptions(Q.dpiQueue, &opts) == C.DPI_FAILURE {
+		return false, Q.wrapErr(OpDequeue, errors.WithMessage(Q.drv.getError(), "getDeqOptions"))
+	}
+	var value *C.char
+	var length C.uint
+	if C.dpiDeqOptions_getMsgId(opts, &value, &length) == C.DPI_FAILURE {
+		return false, Q.wrapErr(OpDequeue, errors.WithMessage(Q.drv.getError(), "getMsgId"))
+	}
+	prev := C.GoStringN(value, C.int(length))
+	if C.dpiDeqOptions_setMsgId(opts, (*C.char)(unsafe.Pointer(&msgID[0])), C.uint(len(msgID))) == C.DPI_FAILURE {
+		return false, Q.wrapErr(OpDequeue, errors.WithMessage(Q.drv.getError(), "setMsgId"))
+	}
+	defer func() {
+		value := C.CString(prev)
+		C.dpiDeqOptions_setMsgId(opts, value, C.uint(len(prev)))
+		C.free(unsafe.Pointer(value))
+	}()
+	msgs := []Message{*msg}
+	n, err := Q.dequeue(msgs, nil)
+	if n == 1 {
+		*msg = msgs[0]
+	}
+	return n == 1, err
+}
+
+// DequeueNewest dequeues the newest ready message into msg (last in, first out), for stack-like work queues.
+// Reports whether a message was found.
+//
+// AQ dequeues in the sort order of the queue table (enqueue time, maybe after priority), ascending only,
+// so the newest messages are looked up in the queue table's AQ$ view (by descending enqueue time),
+// then dequeued by their MsgID, with NoWait, and the other dequeue options in effect (which are kept).
+// If the newest message is taken by another consumer meanwhile, or does not match the correlation
+// or condition in effect, the next newest is tried, among the newestCandidates ones.
+//
+// For a cheaper LIFO order on a queue table sorted by priority, enqueue each message with a lower
+// Priority than the previous one.
+func (Q *Queue) DequeueNewest(ctx context.Context, msg *Message) (bool, error) {
+	owner, table, err := Q.QueueTable(ctx)
+	if err != nil {
+		return false, err
+	}
+	qr, err := Q.querier()
+	if err != nil {
+		return false, err
+	}
+	_, name := splitQueueName(Q.name)
+	qry := `SELECT msg_id FROM (
+  SELECT msg_id FROM "` + owner + `"."AQ$` + table + `"
+    WHERE queue = :1 AND msg_state = 'READY' AND (:2 IS NULL OR consumer_name = :3)
+    ORDER BY enq_time DESC)
+  WHERE ROWNUM <= ` + strconv.Itoa(newestCandidates)
+	rows, err := qr.QueryContext(ctx, qry, name, Q.consumer, Q.consumer)
+	if err != nil {
+		return false, errors.Wrapf(err, "%s [%q]", qry, name)
+	}
+	var msgIDs [][]byte
+	for rows.Next() {
+		var msgID []byte
+		if err = rows.Scan(&msgID); err != nil {
+			rows.Close()
+			return false, errors.Wrap(err, qry)
+		}
+		msgIDs = append(msgIDs, msgID)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return false, errors.Wrap(err, qry)
+	}
+	if len(msgIDs) == 0 {
+		return false, nil
+	}
+
+	Q.mu.Lock()
+	defer Q.mu.Unlock()
+	D, err := Q.deqOptions()
+	if err != nil {
+		return false, err
+	}
+	defer Q.setDeqOptions(D)
+	N := D
+	N.Navigation, N.Wait = NavFirst, NoWait
+	msgs := []Message{*msg}
+	for _, msgID := range msgIDs {
+		if err = ctx.Err(); err != nil {
+			return false, err
+		}
+		N.MsgID = string(msgID)
+		if err = Q.setDeqOptions(N); err != nil {
+			return false, err
+		}
+		if n, err := Q.dequeue(msgs, nil); err != nil {
+			if isNoSuchMsgID(err) {
+				continue
+			}
+			return false, err
+		} else if n == 1 {
+			*msg = msgs[0]
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// Remove removes the messages with the given MsgIDs (e.g. browsed with DeqBrowse) from the queue,
+// dequeueing each by its MsgID with DeqConfirm (without delivering its payload), without waiting,
+// with the Consumer and Visibility of the dequeue options in effect (which are kept).
+//
+// Returns the number of the messages removed: MsgIDs not in the queue (e.g. removed by another consumer
+// meanwhile, ORA-25263) are skipped.
+func (Q *Queue) Remove(msgIDs [][MsgIDLength]byte) (int, error) {
+	Q.mu.Lock()
+	defer Q.mu.Unlock()
+	D, err := Q.deqOptions()
+	if err != nil {
+		return 0, err
+	}
+	defer Q.setDeqOptions(D)
+	N := D
+	N.Mode, N.Navigation, N.Wait = DeqConfirm, NavFirst, NoWait
+	N.Condition, N.Correlation = "", ""
+	msgs := make([]Message, 1)
+	var removed int
+	for _, msgID := range msgIDs {
+		N.MsgID = string(msgID[:])
+		if err = Q.setDeqOptions(N); err != nil {
+			return removed, err
+		}
+		n, err := Q.dequeue(msgs, nil)
+		if err != nil {
+			if isNoSuchMsgID(err) {
+				continue
+			}
+			return removed, err
+		}
+		removed += n
+	}
+	return removed, nil
+}
+
+// isNoSuchMsgID reports whether err is ORA-25263: no message in queue with message ID.
+func isNoSuchMsgID(err error) bool {
+	cd, ok := errors.Cause(err).(interface{ Code() int })
+	return ok && cd.Code() == 25263
+}
+
+// newestCandidates is the number of the newest messages DequeueNewest tries.
+const newestCandidates = 16
+
+// DequeueInto dequeues messages into the given slice, just as Dequeue,
+// but copies the RAW payload of messages[i] into bufs[i] (if i < len(bufs)), growing it if needed.
+//
+// bufs[i] is set to the resulting messages[i].Raw, so the grown buffers can be recycled:
+// the RAW payloads are valid only till the next DequeueInto call with the same bufs.
+func (Q *Queue) DequeueInto(bufs [][]byte, messages []Message) (int, error) {
+	Q.mu.Lock()
+	n, err := Q.dequeue(messages, bufs)
+	Q.mu.Unlock()
+	for i := 0; i < n && i < len(bufs); i++ {
+		if messages[i].Raw != nil {
+			bufs[i] = messages[i].Raw
+		}
+	}
+	return n, err
+}
+
+// dequeue messages, Q.mu must be held.
+func (Q *Queue) dequeue(messages []Message, bufs [][]byte) (int, error) {
+	if Q.keepAlive == 0 {
+		return Q.dequeueOnce(messages, bufs)
+	}
+	var opts *C.dpiDeqOptions
+	if C.dpiQueue_getDeqOptions(Q.dpiQueue, &opts) == C.DPI_FAILURE {
+		return 0, Q.wrapErr(OpDequeue, errors.WithMessage(Q.drv.getError(), "getDeqOptions"))
+	}
+	var wait C.uint
+	if C.dpiDeqOptions_getWait(opts, &wait) == C.DPI_FAILURE {
+		return 0, Q.wrapErr(OpDequeue, errors.WithMessage(Q.drv.getError(), "getWait"))
+	}
+	if uint32(wait) <= Q.keepAlive {
+		return Q.dequeueOnce(messages, bufs)
+	}
+	if C.dpiDeqOptions_setWait(opts, C.uint(Q.keepAlive)) == C.DPI_FAILURE {
+		return 0, Q.wrapErr(OpDequeue, errors.WithMessage(Q.drv.getError(), "setWait"))
+	}
+	defer C.dpiDeqOptions_setWait(opts, wait)
+	for remaining := uint32(wait); ; {
+		n, err := Q.dequeueOnce(messages, bufs)
+		if n != 0 || err != nil {
+			return n, err
+		}
+		if uint32(wait) != WaitForever {
+			if remaining <= Q.keepAlive {
+				return 0, nil
+			}
+			remaining -= Q.keepAlive
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), time.Duration(Q.keepAlive)*time.Second)
+		err = Q.conn.Ping(ctx)
+		cancel()
+		if err != nil {
+			return 0, Q.wrapErr(OpDequeue, errors.WithMessage(maybeBadConn(err), "keep-alive ping"))
+		}
+	}
+}
+
+// dequeueOnce calls dequeue once, with the options in effect.
+func (Q *Queue) dequeueOnce(messages []Message, bufs [][]byte) (int, error) {
+	start := time.Now()
+	n, err := Q.dequeueMessages(messages, bufs)
+	if n > 0 {
+		Q.lastMsgID = messages[n-1].MsgID
+	}
+	Q.observe(OpDequeue, len(messages), n, start, err)
+	if err == nil {
+		Q.setLastError(nil)
+	}
+	return n, Q.wrapErr(OpDequeue, err)
+}
+
+// LastMsgID returns the MsgID of the last message dequeued (in any mode, also browsed) with the Queue,
+// or a zero MsgID if none was dequeued yet.
+//
+// It is the checkpoint of a browse scan: to resume it (even in another process), browse the message with
+// DeqOptions.MsgID set to it (and NavFirst), then continue with NavNext and an empty MsgID.
+func (Q *Queue) LastMsgID() [MsgIDLength]byte {
+	Q.mu.Lock()
+	defer Q.mu.Unlock()
+	return Q.lastMsgID
+}
+
+func (Q *Queue) dequeueMessages(messages []Message, bufs [][]byte) (int, error) {
+	// ODPI-C keeps the error information per thread: stay on this one till getError.
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	typ, err := Q.deqPayloadType()
+	if err != nil {
+		return 0, err
+	}
+	var props []*C.dpiMsgProps
+	if cap(Q.props) >= len(messages) {
+		props = Q.props[:len(messages)]
+	} else {
+		props = make([]*C.dpiMsgProps, len(messages))
+	}
+	Q.props = props
+
+	var ok C.int
+	num := C.uint(len(props))
+	atomic.StoreInt32(&Q.deqBusy, 1)
+	if num == 1 {
+		ok = C.dpiQueue_deqOne(Q.dpiQueue, &props[0])
+	} else {
+		ok = C.dpiQueue_deqMany(Q.dpiQueue, &num, &props[0])
+	}
+	atomic.StoreInt32(&Q.deqBusy, 0)
+	if ok == C.DPI_FAILURE {
+		return 0, errors.WithMessage(Q.conn.getError(), "dequeue")
+	}
+	if len(props) == 1 && props[0] == nil {
+		// deqOne signals "no message" with a nil props.
+		num = 0
+	}
+	var firstErr error
+	for i, p := range props[:dequeuedCount(uint64(num), len(props))] {
+		var buf []byte
+		if i < len(bufs) {
+			buf = bufs[i]
+		}
+		if err := messages[i].fromOra(Q.conn, p, buf, Q.enqTZ, typ, Q.dequeueHeaders); err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+		C.dpiMsgProps_release(p)
+	}
+	return dequeuedCount(uint64(num), len(props)), firstErr
+}
+
+// deqPayloadType returns the ObjectType of the dequeued payloads: the target type of the dequeue transformation
+// in effect (looked up once per transformation, in the data dictionary and the connection's object type cache),
+// or the Queue's payload type.
+//
+// If the target type cannot be looked up (e.g. the Execer given to NewQueue cannot query),
+// the Queue's payload type is used.
+func (Q *Queue) deqPayloadType() (*ObjectType, error) {
+	if Q.payloadType == nil {
+		return nil, nil
+	}
+	var opts *C.dpiDeqOptions
+	if C.dpiQueue_getDeqOptions(Q.dpiQueue, &opts) == C.DPI_FAILURE {
+		return nil, errors.WithMessage(Q.conn.getError(), "getDeqOptions")
+	}
+	var value *C.char
+	var length C.uint
+	if C.dpiDeqOptions_getTransformation(opts, &value, &length) == C.DPI_FAILURE {
+		return nil, errors.WithMessage(Q.conn.getError(), "getTransformation")
+	}
+	if length == 0 {
+		return Q.payloadType, nil
+	}
+	transformation := C.GoStringN(value, C.int(length))
+	if typ, ok := Q.transformTypes[transformation]; ok {
+		return typ, nil
+	}
+	typ, err := Q.lookupTransformType(transformation)
+	if err != nil {
+		if Log != nil {
+			Log("msg", "transformation target type", "queue", Q.name, "transformation", transformation, "error", err)
+		}
+		typ = Q.payloadType
+	}
+	if Q.transformTypes == nil {
+		Q.transformTypes = make(map[string]*ObjectType)
+	}
+	Q.transformTypes[transformation] = typ
+	return typ, nil
+}
+
+// lookupTransformType returns the target type of the transformation, from the connection's object type cache.
+func (Q *Queue) lookupTransformType(transformation string) (*ObjectType, error) {
+	qr, err := Q.querier()
+	if err != nil {
+		return nil, err
+	}
+	owner, name := splitQueueName(transformation)
+	const qry = `SELECT to_type FROM all_transformations WHERE owner = NVL(:1, USER) AND name = :2`
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	var toType string
+	if err = qr.QueryRowContext(ctx, qry, owner, name).Scan(&toType); err != nil {
+		return nil, errors.Wrapf(err, "%s [%q, %q]", qry, owner, name)
+	}
+	typ, err := Q.conn.getObjectTypeCached(toType)
+	if err != nil {
+		return nil, err
+	}
+	return &typ, nil
+}
+
+// dequeuedCount returns the number of messages deqMany reported (num), clamped to the n props it was given,
+// as a defense against reading beyond the props.
+func dequeuedCount(num uint64, n int) int {
+	if num > uint64(n) {
+		if Log != nil {
+			Log("msg", "deqMany returned more messages than asked", "num", num, "asked", n)
+		}
+		return n
+	}
+	return int(num)
+}
+
+// Subscribe registers cb for the AQ notifications of the queue (EvtAQ events, one per enqueued message),
+// for the Queue's consumer on a multi-consumer queue (see NewMultiConsumerQueue).
+//
+// The connection must be opened with enableEvents=1. The Subscription must be closed before the Queue's connection;
+// see Subscription for its lifecycle.
+func (Q *Queue) Subscribe(ctx context.Context, cb func(Event)) (*Subscription, error) {
+	owner, _, err := Q.QueueTable(ctx)
+	if err != nil {
+		return nil, Q.wrapErr("subscribe", err)
+	}
+	_, name := splitQueueName(Q.name)
+	name = owner + "." + name
+	if Q.consumer != "" {
+		name += ":" + Q.consumer
+	}
+	s, err := Q.conn.newSubscription(C.DPI_SUBSCR_NAMESPACE_AQ, C.DPI_SUBSCR_QOS_BEST_EFFORT, name, cb)
+	return s, Q.wrapErr("subscribe", err)
+}
+
+// Consume starts dequeueing messages in the background, one at a time, with the queue's dequeue options in effect,
+// and sends them to the returned Consumer's Messages channel, which is buffered with bufSize.
+//
+// When the buffer is full, dequeueing pauses, so the messages stay in the queue till the reader catches up.
+// The context is checked between the dequeues, so a long DeqOptions.Wait delays the stop.
+// The loop stops when ctx is done, Consumer.Close is called, or on the first error.
+//
+// The Queue must not be used by anyone else while consumed.
+func (Q *Queue) Consume(ctx context.Context, bufSize int) *Consumer {
+	ctx, cancel := context.WithCancel(ctx)
+	c := &Consumer{
+		msgs:   make(chan Message, bufSize),
+		done:   make(chan struct{}),
+		drain:  make(chan struct{}),
+		cancel: cancel,
+	}
+	go c.loop(ctx, Q)
+	return c
+}
+
+// consumePollInterval is the time the Consumer sleeps when the buffer is full or there was no message.
+const consumePollInterval = 100 * time.Millisecond
+
+// Consumer dequeues messages in the background, see Queue.Consume.
+type Consumer struct {
+	msgs      chan Message
+	done      chan struct{}
+	drain     chan struct{}
+	drainOnce sync.Once
+	cancel    context.CancelFunc
+	err       error
+}
+
+// Messages returns the channel of the dequeued messages. It is closed when the Consumer stops.
+func (c *Consumer) Messages() <-chan Message { return c.msgs }
+
+// Done is closed when the Consumer stopped.
+func (c *Consumer) Done() <-chan struct{} { return c.done }
+
+// Err returns the error which stopped the Consumer, after it's Done.
+func (c *Consumer) Err() error {
+	<-c.done
+	return c.err
+}
+
+// Close stops the Consumer and waits for its stop.
+//
+// It aborts at once: a message already dequeued but not yet received from Messages is lost
+// (unless dequeued with VisibleOnCommit and rolled back). Use Drain for a graceful stop.
+func (c *Consumer) Close() error {
+	c.cancel()
+	return c.Err()
+}
+
+// Drain stops the Consumer gracefully and waits for its stop: no new message is dequeued,
+// but the message already dequeued is still sent on Messages, so it must be read till closed.
+//
+// Cancelling the Consumer's context (or Close) still aborts at once.
+func (c *Consumer) Drain() error {
+	c.drainOnce.Do(func() { close(c.drain) })
+	return c.Err()
+}
+
+func (c *Consumer) loop(ctx context.Context, Q *Queue) {
+	defer close(c.done)
+	defer close(c.msgs)
+	sleep := func() bool {
+		select {
+		case <-ctx.Done():
+			return false
+		case <-c.drain:
+			return false
+		case <-time.After(consumePollInterval):
+			return true
+		}
+	}
+	msgs := make([]Message, 1)
+	for ctx.Err() == nil {
+		select {
+		case <-c.drain:
+			return
+		default:
+		}
+		// Wait for room in the buffer, to leave the messages in the queue while the reader is slow.
+		if cap(c.msgs) != 0 && len(c.msgs) == cap(c.msgs) {
+			if !sleep() {
+				return
+			}
+			continue
+		}
+		n, err := Q.Dequeue(msgs)
+		if err != nil {
+			c.err = err
+			return
+		}
+		if n == 0 {
+			if !sleep() {
+				return
+			}
+			continue
+		}
+		select {
+		case c.msgs <- msgs[0]:
+		case <-ctx.Done():
+			return
+		}
+		msgs[0] = Message{}
+	}
+}
+
+// ExceptionQueue returns the name ("owner.name") of the default exception queue of the queue's table,
+// where the expired messages, and the ones exceeding the max_retries are moved to
+// (unless the message names another one in its ExceptionQ).
+func (Q *Queue) ExceptionQueue(ctx context.Context) (string, error) {
+	owner, table, err := Q.QueueTable(ctx)
+	if err != nil {
+		return "", err
+	}
+	qr, err := Q.querier()
+	if err != nil {
+		return "", err
+	}
+	const qry = `SELECT name FROM all_queues
+		WHERE owner = :1 AND queue_table = :2 AND queue_type = 'EXCEPTION_QUEUE' AND name = 'AQ$_'||queue_table||'_E'`
+	var name string
+	if err = qr.QueryRowContext(ctx, qry, owner, table).Scan(&name); err != nil {
+		if err == sql.ErrNoRows {
+			return "", errors.Wrapf(ErrQueueNotFound, "exception queue of %s.%s", owner, table)
+		}
+		return "", errors.Wrapf(err, "%s [%q, %q]", qry, owner, table)
+	}
+	return owner + "." + name, nil
+}
+
+// ExpiredWatcher calls a function for each message moved to the exception queue, see Queue.WatchExpired.
+type ExpiredWatcher struct {
+	eq   *Queue
+	c    *Consumer
+	done chan struct{}
+}
+
+// WatchExpired consumes (removes) the messages of the queue's exception queue (see ExceptionQueue)
+// in the background, and calls cb for each, till ctx is done or the watcher is closed.
+//
+// AQ moves a message to the exception queue with its MsgID unchanged, so the OriginalMsgID of the message
+// given to cb is set to its MsgID (if not set already), and its State is MsgStateExpired.
+// The exception queue must be started for dequeue (see StartQueue), and the messages are moved
+// there by the queue monitor processes, so they may arrive a while after their expiration.
+// The exception queue is dequeued on the Queue's connection, polling without wait.
+func (Q *Queue) WatchExpired(ctx context.Context, cb func(Message)) (*ExpiredWatcher, error) {
+	name, err := Q.ExceptionQueue(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var typeName string
+	if Q.payloadType != nil {
+		typeName = Q.payloadTypeName()
+	}
+	eq, err := NewQueue(ctx, Q.execer, name, typeName)
+	if err != nil {
+		return nil, err
+	}
+	if err = eq.SetDeqOptions(DeqOptions{
+		Mode: DeqRemove, Navigation: NavFirst, Visibility: VisibleImmediate, Wait: NoWait,
+	}); err != nil {
+		eq.Close()
+		return nil, err
+	}
+	w := &ExpiredWatcher{eq: eq, c: eq.Consume(ctx, 0), done: make(chan struct{})}
+	go func() {
+		defer close(w.done)
+		for msg := range w.c.Messages() {
+			if msg.OriginalMsgID == zeroMsgID {
+				msg.OriginalMsgID = msg.MsgID
+			}
+			cb(msg)
+		}
+	}()
+	return w, nil
+}
+
+// Done returns a channel which is closed when the watcher stops (after the last call of its function).
+func (w *ExpiredWatcher) Done() <-chan struct{} { return w.done }
+
+// Err returns the error which stopped the watcher, after waiting for its stop.
+func (w *ExpiredWatcher) Err() error {
+	<-w.done
+	return w.c.Err()
+}
+
+// Close stops the watcher, waits for its stop, and closes the exception Queue.
+func (w *ExpiredWatcher) Close() error {
+	err := w.c.Close()
+	<-w.done
+	if closeErr := w.eq.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// QueueMessage is a Message tagged with the name of its source queue, see Listen.
+type QueueMessage struct {
+	Queue string
+	Message
+}
+
+// Listen dequeues from all the queues in one goroutine, and sends the messages,
+// tagged with the queue's key in the map, to the returned message channel.
+//
+// The queues are polled round-robin, one message from each queue per round,
+// so a busy queue does not starve the others. When a round brings no message,
+// Listen sleeps a bit before the next round. The dequeue options of the queues are used,
+// so they should have a short (or NoWait) Wait, as a waiting queue delays the others.
+//
+// Listen stops when ctx is done, or on the first error, which is sent on the error channel.
+// Both channels are closed on stop.
+// The queues must not be used by anyone else while listened to.
+func Listen(ctx context.Context, queues map[string]*Queue) (<-chan QueueMessage, <-chan error) {
+	names := make([]string, 0, len(queues))
+	for nm := range queues {
+		names = append(names, nm)
+	}
+	sort.Strings(names)
+	msgCh, errCh := make(chan QueueMessage), make(chan error, 1)
+	go func() {
+		defer close(errCh)
+		defer close(msgCh)
+		msgs := make([]Message, 1)
+		for ctx.Err() == nil {
+			var got bool
+			for _, nm := range names {
+				n, err := queues[nm].Dequeue(msgs)
+				if err != nil {
+					errCh <- err
+					return
+				}
+				if n == 0 {
+					continue
+				}
+				got = true
+				select {
+				case msgCh <- QueueMessage{Queue: nm, Message: msgs[0]}:
+				case <-ctx.Done():
+					return
+				}
+				msgs[0] = Message{}
+			}
+			if !got {
+				select {
+				case <-ctx.Done():
+					return
+				case <-time.After(consumePollInterval):
+				}
+			}
+		}
+	}()
+	return msgCh, errCh
+}
+
+// AdaptiveDequeuer dequeues in batches, adapting the batch size to the load:
+// it doubles the batch (up to Max) when the previous batch came back full,
+// and halves it (down to Min) when it came back less than half full.
+//
+// The chosen batch size is reported in the observer's QueueEvent.Batch.
+type AdaptiveDequeuer struct {
+	Q        *Queue
+	Min, Max int
+	batch    int
+	buf      []Message
+}
+
+// NewAdaptiveDequeuer returns a new AdaptiveDequeuer, starting with min batch size.
+func NewAdaptiveDequeuer(Q *Queue, min, max int) *AdaptiveDequeuer {
+	if min < 1 {
+		min = 1
+	}
+	if max < min {
+		max = min
+	}
+	return &AdaptiveDequeuer{Q: Q, Min: min, Max: max, batch: min}
+}
+
+// Batch returns the current batch size.
+func (A *AdaptiveDequeuer) Batch() int { return A.batch }
+
+// Dequeue a batch of messages. The returned slice is valid only till the next call.
+func (A *AdaptiveDequeuer) Dequeue() ([]Message, error) {
+	if cap(A.buf) < A.batch {
+		A.buf = make([]Message, A.Max)
+	}
+	msgs := A.buf[:A.batch]
+	n, err := A.Q.Dequeue(msgs)
+	if err != nil {
+		return msgs[:n], err
+	}
+	if n == A.batch {
+		if A.batch *= 2; A.batch > A.Max {
+			A.batch = A.Max
+		}
+	} else if n < A.batch/2 {
+		if A.batch /= 2; A.batch < A.Min {
+			A.batch = A.Min
+		}
+	}
+	return msgs[:n], nil
+}
+
+// FairDequeuer dequeues round-robin across correlations, so a flood of messages
+// of one correlation (e.g. a noisy tenant) cannot starve the others.
+//
+// Each Dequeue tries the correlations in turn, starting after the one served last,
+// with the Queue's dequeue options in effect, except that Correlation is set to the one tried,
+// and the tries are made with NavFirst and NoWait. The options are restored after.
+type FairDequeuer struct {
+	Q            *Queue
+	Correlations []string
+	next         int
+}
+
+// NewFairDequeuer returns a new FairDequeuer cycling through the given correlations.
+func NewFairDequeuer(Q *Queue, correlations ...string) *FairDequeuer {
+	return &FairDequeuer{Q: Q, Correlations: correlations}
+}
+
+// Dequeue one message into msg, of the next correlation that has one.
+// Reports whether a message was found.
+func (F *FairDequeuer) Dequeue(msg *Message) (bool, error) {
+	Q := F.Q
+	Q.mu.Lock()
+	defer Q.mu.Unlock()
+	var opts *C.dpiDeqOptions
+	if C.dpiQueue_getDeqOptions(Q.dpiQueue, &opts) == C.DPI_FAILURE {
+		return false, Q.wrapErr(OpDequeue, errors.WithMessage(Q.drv.getError(), "getDeqOptions"))
+	}
+	var value *C.char
+	var length C.uint
+	if C.dpiDeqOptions_getCorrelation(opts, &value, &length) == C.DPI_FAILURE {
+		return false, Q.wrapErr(OpDequeue, errors.WithMessage(Q.drv.getError(), "getCorrelation"))
+	}
+	correlation := C.GoStringN(value, C.int(length))
+	var nav C.dpiDeqNavigation
+	if C.dpiDeqOptions_getNavigation(opts, &nav) == C.DPI_FAILURE {
+		return false, Q.wrapErr(OpDequeue, errors.WithMessage(Q.drv.getError(), "getNavigation"))
+	}
+	var wait C.uint
+	if C.dpiDeqOptions_getWait(opts, &wait) == C.DPI_FAILURE {
+		return false, Q.wrapErr(OpDequeue, errors.WithMessage(Q.drv.getError(), "getWait"))
+	}
+	defer func() {
+		value := C.CString(correlation)
+		C.dpiDeqOptions_setCorrelation(opts, value, C.uint(len(correlation)))
+		C.free(unsafe.Pointer(value))
+		C.dpiDeqOptions_setNavigation(opts, nav)
+		C.dpiDeqOptions_setWait(opts, wait)
+	}()
+	if C.dpiDeqOptions_setNavigation(opts, C.dpiDeqNavigation(NavFirst)) == C.DPI_FAILURE ||
+		C.dpiDeqOptions_setWait(opts, C.uint(NoWait)) == C.DPI_FAILURE {
+		return false, Q.wrapErr(OpDequeue, errors.WithMessage(Q.drv.getError(), "setDeqOptions"))
+	}
+
+	msgs := []Message{*msg}
+	for i := 0; i < len(F.Correlations); i++ {
+		k := (F.next + i) % len(F.Correlations)
+		corr := F.Correlations[k]
+		value := C.CString(corr)
+		ok := C.dpiDeqOptions_setCorrelation(opts, value, C.uint(len(corr)))
+		C.free(unsafe.Pointer(value))
+		if ok == C.DPI_FAILURE {
+			return false, Q.wrapErr(OpDequeue, errors.WithMessage(Q.drv.getError(), "setCorrelation"))
+		}
+		n, err := Q.dequeue(msgs, nil)
+		if err != nil {
+			return false, err
+		}
+		if n == 1 {
+			*msg = msgs[0]
+			F.next = k + 1
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// DequeueRaw dequeues at most max messages, returning only their RAW payloads.
+//
+// When no message is ready, it returns an empty slice and nil error.
+func (Q *Queue) DequeueRaw(max int) ([][]byte, error) {
+	if max < 1 {
+		max = 1
+	}
+	msgs := make([]Message, max)
+	n, err := Q.Dequeue(msgs)
+	payloads := make([][]byte, n)
+	for i, m := range msgs[:n] {
+		payloads[i] = m.Raw
+	}
+	return payloads, err
+}
+
+// ErrNoMessages is returned when there is no message to dequeue.
+var ErrNoMessages = errors.New("no messages")
+
+// DequeuePoison dequeues one message into msg, and reports whether it is a poison message:
+// it has been attempted (and rolled back) more than maxAttempts times.
+//
+// Such messages should be routed to a dead-letter path.
+// Returns ErrNoMessages if there's no message.
+func (Q *Queue) DequeuePoison(maxAttempts int32, msg *Message) (bool, error) {
+	msgs := []Message{*msg}
+	n, err := Q.Dequeue(msgs)
+	if err != nil {
+		return false, err
+	}
+	if n == 0 {
+		return false, ErrNoMessages
+	}
+	*msg = msgs[0]
+	return msg.IsPoison(maxAttempts), nil
+}
+
+// IsPoison reports whether the message has been attempted more than maxAttempts times.
+func (M Message) IsPoison(maxAttempts int32) bool { return M.NumAttempts > maxAttempts }
+
+// Enqueue all the messages given, setting their MsgID.
+//
+// Use it for batches (a single round trip for all the messages); for a single message,
+// EnqueueOne is more convenient, as it returns the MsgID.
+// An error with the ErrEnqueuedNoMsgID cause means the messages are enqueued, without all their MsgIDs.
+//
+// WARNING: calling this function in parallel on different connections acquired from the same pool may fail due to Oracle bug 29928074. Ensure that this function is not run in parallel, use standalone connections or connections from different pools, or make multiple calls to Queue.enqOne() instead. The function Queue.Dequeue() call is not affected.
+func (Q *Queue) Enqueue(messages []Message) error {
+	Q.mu.Lock()
+	defer Q.mu.Unlock()
+	return Q.enqueueChecked(messages)
+}
+
+// EnqueueOne enqueues the given message, and returns its MsgID.
+//
+// It is equivalent to Enqueue([]Message{msg}), without the need to dig the MsgID out of the slice.
+func (Q *Queue) EnqueueOne(msg Message) ([MsgIDLength]byte, error) {
+	msgs := []Message{msg}
+	err := Q.Enqueue(msgs)
+	return msgs[0].MsgID, err
+}
+
+// EnqueueContext enqueues the messages just as Enqueue, after checking ctx,
+// and propagates the trace id of ctx (see ContextWithTraceID) in the TraceIDHeader of the messages
+// not having one yet, for Message.TraceContext on the consumer side (which must SetDequeueHeaders(true)).
+//
+// The trace id is propagated only with RAW payloads, as Headers need RAW payload.
+func (Q *Queue) EnqueueContext(ctx context.Context, messages []Message) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if id := TraceIDFromContext(ctx); id != "" && Q.payloadType == nil {
+		for i := range messages {
+			M := &messages[i]
+			if _, ok := M.Headers[TraceIDHeader]; ok {
+				continue
+			}
+			// Do not modify the caller's map.
+			headers := make(map[string]string, len(M.Headers)+1)
+			for k, v := range M.Headers {
+				headers[k] = v
+			}
+			headers[TraceIDHeader] = id
+			M.Headers = headers
+		}
+	}
+	return Q.Enqueue(messages)
+}
+
+// EnqueueUnsafe is Enqueue without locking the Queue.
+//
+// It is NOT safe for concurrent use: the caller must guarantee that no other goroutine
+// uses the Queue (or its Consumer) meanwhile. Use it only in single-goroutine hot loops,
+// where the lock shows up in profiles.
+func (Q *Queue) EnqueueUnsafe(messages []Message) error {
+	return Q.enqueueChecked(messages)
+}
+
+// EnqueueDryRun validates the messages just as Enqueue would (payload type and size, Message.Validate,
+// and the conversion to message properties), without enqueueing them.
+// The returned error names the first invalid message.
+func (Q *Queue) EnqueueDryRun(messages []Message) error {
+	Q.mu.Lock()
+	defer Q.mu.Unlock()
+	for i := range messages {
+		M := &messages[i]
+		err := Q.checkPayload(M)
+		if err == nil {
+			err = M.Validate()
+		}
+		if err == nil {
+			var props *C.dpiMsgProps
+			if props, err = Q.getProps(); err == nil {
+				err = M.toOra(Q.drv, props)
+				Q.putProps(props, M.OriginalMsgID == zeroMsgID)
+			}
+		}
+		if err != nil {
+			return Q.wrapErr("enqueueDryRun", errors.WithMessage(err, fmt.Sprintf("%d. message", i)))
+		}
+	}
+	return nil
+}
+
+// enqueueChecked checks the payloads and enqueues the messages, Q.mu must be held.
+func (Q *Queue) enqueueChecked(messages []Message) error {
+	for i := range messages {
+		if err := Q.checkPayload(&messages[i]); err != nil {
+			return Q.wrapErr(OpEnqueue, errors.WithMessage(err, fmt.Sprintf("%d. message", i)))
+		}
+	}
+	start := time.Now()
+	var err error
+	if Q.dedup {
+		err = Q.enqueueDedup(messages)
+	} else {
+		err = Q.enqueueVisible(messages)
+	}
+	if err == nil {
+		Q.observe(OpEnqueue, len(messages), len(messages), start, nil)
+		Q.setLastError(nil)
+	} else if errors.Cause(err) == ErrEnqueuedNoMsgID {
+		Q.observe(OpEnqueue, len(messages), len(messages), start, err)
+	} else {
+		Q.observe(OpEnqueue, len(messages), 0, start, err)
+	}
+	return Q.wrapErr(OpEnqueue, err)
+}
+
+// EnqueueWith enqueues the messages with the enqueue options E, restoring the previous options afterwards.
+//
+// A message with a Visibility overrides E.Visibility: as the enqueue options apply to the whole enqueue call,
+// the batch is split into runs of consecutive messages with the same visibility, and each run
+// is enqueued in turn with its own visibility, keeping the order of the messages.
+//
+// The runs are not atomic together: the VisibleImmediate messages are committed at once
+// (in a transaction of their own), independently of the transaction of the VisibleOnCommit ones,
+// so a rollback (or an error in a later run) does not undo them. If a run fails,
+// the messages of the previous runs remain enqueued (with their MsgID set).
+func (Q *Queue) EnqueueWith(messages []Message, E EnqOptions) error {
+	Q.mu.Lock()
+	defer Q.mu.Unlock()
+	prev, err := Q.EnqOptions()
+	if err != nil {
+		return err
+	}
+	defer Q.SetEnqOptions(prev)
+	if err = Q.SetEnqOptions(E); err != nil {
+		return err
+	}
+	return Q.enqueueChecked(messages)
+}
+
+// enqueueVisible enqueues the messages in runs of the same visibility (see Message.Visibility), Q.mu must be held.
+func (Q *Queue) enqueueVisible(messages []Message) error {
+	split := false
+	for _, m := range messages {
+		if m.Visibility != 0 {
+			split = true
+			break
+		}
+	}
+	if !split {
+		return Q.enqueue(messages)
+	}
+	var opts *C.dpiEnqOptions
+	if C.dpiQueue_getEnqOptions(Q.dpiQueue, &opts) == C.DPI_FAILURE {
+		return errors.WithMessage(Q.drv.getError(), "getEnqOptions")
+	}
+	var queueVis C.dpiVisibility
+	if C.dpiEnqOptions_getVisibility(opts, &queueVis) == C.DPI_FAILURE {
+		return errors.WithMessage(Q.drv.getError(), "getVisibility")
+	}
+	defer C.dpiEnqOptions_setVisibility(opts, queueVis)
+	visibility := func(m Message) C.dpiVisibility {
+		if m.Visibility != 0 {
+			return C.dpiVisibility(m.Visibility)
+		}
+		return queueVis
+	}
+	var msgIDErr error
+	for start := 0; start < len(messages); {
+		vis := visibility(messages[start])
+		end := start + 1
+		for end < len(messages) && visibility(messages[end]) == vis {
+			end++
+		}
+		if C.dpiEnqOptions_setVisibility(opts, vis) == C.DPI_FAILURE {
+			return errors.WithMessage(Q.drv.getError(), "setVisibility")
+		}
+		if err := Q.enqueue(messages[start:end]); err != nil {
+			err = errors.WithMessage(err, fmt.Sprintf("messages %d-%d", start, end-1))
+			if errors.Cause(err) != ErrEnqueuedNoMsgID {
+				return err
+			}
+			if msgIDErr == nil {
+				msgIDErr = err
+			}
+		}
+		start = end
+	}
+	return msgIDErr
+}
+
+// SetDedupByCorrelation makes Enqueue idempotent, using the Correlation of the messages as a dedup key
+// (for example a client-generated request id): a message whose Correlation is already in the queue table
+// (in any state, including the processed messages still retained) is not enqueued again,
+// but gets the MsgID of the one in the queue. A message with an empty Correlation is always enqueued.
+// Within one batch, only the first message of a key is enqueued.
+//
+// This makes a retried Enqueue (after a timeout, for example) a no-op. The check queries the queue table's AQ$ view
+// (with one query per Enqueue call), so it needs an Execer (given to NewQueue) which can query,
+// and sees only the committed messages, and the ones enqueued in the current transaction:
+// concurrent producers of the same key must serialize their enqueues themselves.
+func (Q *Queue) SetDedupByCorrelation(dedup bool) {
+	Q.mu.Lock()
+	Q.dedup = dedup
+	Q.mu.Unlock()
+}
+
+// enqueueDedup enqueues the messages whose Correlation is not in the queue table yet, see SetDedupByCorrelation.
+// Q.mu must be held.
+func (Q *Queue) enqueueDedup(messages []Message) error {
+	existing, err := Q.lookupCorrelations(messages)
+	if err != nil {
+		return errors.WithMessage(err, "dedup")
+	}
+	fresh := make([]Message, 0, len(messages))
+	idx := make([]int, 0, len(messages))
+	first := make(map[string]int, len(messages))
+	var dups []int
+	for i, m := range messages {
+		if m.Correlation == "" {
+			fresh, idx = append(fresh, m), append(idx, i)
+			continue
+		}
+		if msgID, ok := existing[m.Correlation]; ok {
+			messages[i].MsgID = msgID
+			continue
+		}
+		if _, ok := first[m.Correlation]; ok {
+			dups = append(dups, i)
+			continue
+		}
+		first[m.Correlation] = len(fresh)
+		fresh, idx = append(fresh, m), append(idx, i)
+	}
+	if len(fresh) != 0 {
+		if err = Q.enqueueVisible(fresh); err != nil && errors.Cause(err) != ErrEnqueuedNoMsgID {
+			return err
+		}
+	}
+	for j, i := range idx {
+		messages[i].setEnqueued(fresh[j])
+	}
+	for _, i := range dups {
+		j := first[messages[i].Correlation]
+		messages[i].setEnqueued(fresh[j])
+	}
+	return err
+}
+
+// lookupCorrelations returns the MsgID of the messages in the queue table with the Correlation of the messages,
+// from the AQ$ view, querying at most maxInList correlations at once. Q.mu must be held.
+func (Q *Queue) lookupCorrelations(messages []Message) (map[string][MsgIDLength]byte, error) {
+	var corrs []string
+	seen := make(map[string]struct{}, len(messages))
+	for _, m := range messages {
+		if m.Correlation == "" {
+			continue
+		}
+		if _, ok := seen[m.Correlation]; ok {
+			continue
+		}
+		seen[m.Correlation] = struct{}{}
+		corrs = append(corrs, m.Correlation)
+	}
+	existing := make(map[string][MsgIDLength]byte)
+	if len(corrs) == 0 {
+		return existing, nil
+	}
+	qr, err := Q.querier()
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	owner, table, err := Q.QueueTable(ctx)
+	if err != nil {
+		return nil, err
+	}
+	_, name := splitQueueName(Q.name)
+	for start := 0; start < len(corrs); start += maxInList {
+		end := start + maxInList
+		if end > len(corrs) {
+			end = len(corrs)
+		}
+		var buf strings.Builder
+		buf.WriteString(`SELECT corr_id, msg_id FROM "` + owner + `"."AQ$` + table + `" WHERE queue = :1 AND corr_id IN (`)
+		params := make([]interface{}, 1, end-start+1)
+		params[0] = name
+		for _, corr := range corrs[start:end] {
+			if len(params) != 1 {
+				buf.WriteByte(',')
+			}
+			params = append(params, corr)
+			fmt.Fprintf(&buf, ":%d", len(params))
+		}
+		buf.WriteByte(')')
+		qry := buf.String()
+		rows, err := qr.QueryContext(ctx, qry, params...)
+		if err != nil {
+			return nil, errors.Wrap(err, qry)
+		}
+		for rows.Next() {
+			var corrID string
+			var msgID []byte
+			if err = rows.Scan(&corrID, &msgID); err != nil {
+				rows.Close()
+				return nil, errors.Wrap(err, qry)
+			}
+			var id [MsgIDLength]byte
+			copy(id[:], msgID)
+			existing[corrID] = id
+		}
+		rows.Close()
+		if err = rows.Err(); err != nil {
+			return nil, errors.Wrap(err, qry)
+		}
+	}
+	return existing, nil
+}
+
+// enqueue the messages, Q.mu must be held.
+func (Q *Queue) enqueue(messages []Message) error {
+	// ODPI-C keeps the error information per thread: stay on this one till getError.
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	var props []*C.dpiMsgProps
+	if cap(Q.props) >= len(messages) {
+		props = Q.props[:len(messages)]
+	} else {
+		props = make([]*C.dpiMsgProps, len(messages))
+	}
+	Q.props = props
+	for i := range props {
+		props[i] = nil
+	}
+	defer func() {
+		for i, p := range props {
+			if p != nil {
+				Q.putProps(p, messages[i].OriginalMsgID == zeroMsgID)
+				props[i] = nil
+			}
+		}
+	}()
+	var buf []byte
+	if Q.copyPayloads {
+		var n int
+		for i := range messages {
+			n += len(messages[i].Raw)
+		}
+		if cap(Q.payloadBuf) < n {
+			Q.payloadBuf = make([]byte, 0, n)
+		}
+		buf = Q.payloadBuf[:0]
+	}
+	for i, m := range messages {
+		if Q.copyPayloads && m.Raw != nil {
+			start := len(buf)
+			buf = append(buf, m.Raw...)
+			m.Raw = buf[start:len(buf):len(buf)]
+		}
+		var err error
+		if Q.compresses(&m) {
+			if m, err = Q.compress(m); err != nil {
+				return errors.WithMessage(err, fmt.Sprintf("%d. message", i))
+			}
+		}
+		if props[i], err = Q.getProps(); err != nil {
+			return err
+		}
+		if err = m.toOra(Q.drv, props[i]); err != nil {
+			return err
+		}
+	}
+
+	var ok C.int
+	if len(messages) == 1 {
+		ok = C.dpiQueue_enqOne(Q.dpiQueue, props[0])
+	} else {
+		ok = C.dpiQueue_enqMany(Q.dpiQueue, C.uint(len(props)), &props[0])
+	}
+	if ok == C.DPI_FAILURE {
+		return errors.Wrapf(Q.conn.getError(), "enqueue %s", describeMessages(messages, Q.redact))
+	}
+	// The delivery mode is an enqueue option, not a message property.
+	mode := Q.enqDeliveryMode
+	if mode == 0 {
+		mode = DeliverPersistent
+	}
+	// The messages are enqueued: a missing MsgID must not look like a failed enqueue.
+	var msgIDErr error
+	for i, p := range props {
+		messages[i].DeliveryMode = mode
+		var value *C.char
+		var length C.uint
+		if C.dpiMsgProps_getMsgId(p, &value, &length) == C.DPI_FAILURE {
+			if msgIDErr == nil {
+				msgIDErr = errors.Wrapf(ErrEnqueuedNoMsgID, "%d. message: getMsgId: %v", i, Q.conn.getError())
+			}
+		} else {
+			messages[i].MsgID = msgIDFromOra(value, length)
+		}
+		// OCI may not report the enqueue time at all: leave Enqueued zero then.
+		var ts C.dpiTimestamp
+		if C.dpiMsgProps_getEnqTime(p, &ts) == C.DPI_FAILURE {
+			Q.conn.getError()
+		} else {
+			messages[i].Enqueued = enqTimeFromOra(ts, Q.conn, Q.enqTZ)
+		}
+	}
+	if Q.lookupEnqueued {
+		// The messages are enqueued (maybe committed, with VisibleImmediate): a failed lookup leaves Enqueued as is.
+		if err := Q.lookupEnqueueTimes(messages); err != nil && Log != nil {
+			Log("msg", "lookup enqueue times", "queue", Q.name, "error", err)
+		}
+	}
+	return msgIDErr
+}
+
+// ErrEnqueuedNoMsgID is returned (as the cause) when the messages are enqueued,
+// but the MsgID of some of them could not be read back: those are left empty.
+// The messages must not be enqueued again.
+var ErrEnqueuedNoMsgID = errors.New("enqueued, but message ID is not available")
+
+// SetEnqueuedLookup makes Enqueue look up the server's enqueue time of the messages
+// in the queue table's AQ$ view (with one query per Enqueue call), and set their Enqueued.
+//
+// Oracle returns the enqueue time of the dequeued messages only, so without the lookup,
+// Enqueued of the enqueued messages is set only if OCI happens to report it, and is zero otherwise.
+// Buffered messages are not in the queue table, so their Enqueued is not looked up.
+// The lookup needs an Execer (given to NewQueue) which can query.
+// A failed lookup does not fail the Enqueue (the messages are enqueued already): it is logged (see Log),
+// and leaves Enqueued as OCI reported it.
+func (Q *Queue) SetEnqueuedLookup(lookup bool) {
+	Q.mu.Lock()
+	Q.lookupEnqueued = lookup
+	Q.mu.Unlock()
+}
+
+// lookupEnqueueTimes sets the Enqueued time of the enqueued messages from the AQ$ view, Q.mu must be held.
+//
+// The messages are enqueued already, so its error must not fail the enqueue.
+func (Q *Queue) lookupEnqueueTimes(messages []Message) error {
+	qr, err := Q.querier()
+	if err != nil {
+		return err
+	}
+	if Q.enqDeliveryMode == DeliverBuffered {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	owner, table, err := Q.QueueTable(ctx)
+	if err != nil {
+		return err
+	}
+	idx := make(map[[MsgIDLength]byte]int, len(messages))
+	for i := range messages {
+		idx[messages[i].MsgID] = i
+	}
+	for start := 0; start < len(messages); start += maxInList {
+		end := start + maxInList
+		if end > len(messages) {
+			end = len(messages)
+		}
+		var buf strings.Builder
+		buf.WriteString(`SELECT msg_id, enq_time FROM "` + owner + `"."AQ$` + table + `" WHERE msg_id IN (`)
+		params := make([]interface{}, 0, end-start)
+		for i := start; i < end; i++ {
+			if len(params) != 0 {
+				buf.WriteByte(',')
+			}
+			params = append(params, messages[i].MsgID[:])
+			fmt.Fprintf(&buf, ":%d", len(params))
+		}
+		buf.WriteByte(')')
+		qry := buf.String()
+		rows, err := qr.QueryContext(ctx, qry, params...)
+		if err != nil {
+			return errors.Wrap(err, qry)
+		}
+		for rows.Next() {
+			var msgID []byte
+			var t time.Time
+			if err = rows.Scan(&msgID, &t); err != nil {
+				rows.Close()
+				return errors.Wrap(err, qry)
+			}
+			if Q.enqTZ != nil {
+				t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), Q.enqTZ)
+			}
+			var id [MsgIDLength]byte
+			copy(id[:], msgID)
+			if i, ok := idx[id]; ok {
+				messages[i].Enqueued = t
+			}
+		}
+		rows.Close()
+		if err = rows.Err(); err != nil {
+			return errors.Wrap(err, qry)
+		}
+	}
+	return nil
+}
+
+// maxInList is the maximum number of expressions in an IN list (ORA-01795).
+const maxInList = 1000
+
+// EnqueueOrdered enqueues the messages one by one, in order, so each gets a distinct enqueue time,
+// and messages of the same priority dequeue in the given order (with the default ENQ_TIME sort order of the queue table).
+//
+// The ENQ_TIME column of the queue table is a TIMESTAMP(6) of the server's clock (unlike the whole seconds
+// reported in Message.Enqueued): EnqueueOrdered waits orderedGap between the enqueues,
+// so the clock advances between them even with a coarse resolution.
+//
+// A batch Enqueue gives the same enqueue time for all the messages, leaving their relative order unspecified.
+// The order is kept only among the messages of one producer: concurrent producers interleave.
+func (Q *Queue) EnqueueOrdered(messages []Message) error {
+	Q.mu.Lock()
+	defer Q.mu.Unlock()
+	for i := range messages {
+		if err := Q.checkPayload(&messages[i]); err != nil {
+			return Q.wrapErr(OpEnqueue, errors.WithMessage(err, fmt.Sprintf("%d. message", i)))
+		}
+	}
+	var last time.Time
+	for i := range messages {
+		if i != 0 {
+			if d := orderedGap - time.Since(last); d > 0 {
+				time.Sleep(d)
+			}
+		}
+		// Just as Enqueue: with the Visibility of the message, and SetDedupByCorrelation.
+		if err := Q.enqueueChecked(messages[i : i+1]); err != nil {
+			return errors.WithMessage(err, fmt.Sprintf("%d. message", i))
+		}
+		last = time.Now()
+	}
+	return nil
+}
+
+// orderedGap is the minimal time between the enqueues of EnqueueOrdered, for distinct enqueue times.
+const orderedGap = time.Millisecond
+
+// StreamOptions configure EnqueueStream.
+type StreamOptions struct {
+	// BatchSize is the number of messages enqueued in one round trip, 1 if not positive.
+	BatchSize int
+	// CommitInterval commits the transaction after every CommitInterval enqueued messages,
+	// to bound the transaction size. If not positive, EnqueueStream does not commit.
+	CommitInterval int
+	// Progress, if not nil, is called after each batch with the number of messages enqueued
+	// and committed so far.
+	Progress func(enqueued, committed int)
+}
+
+// EnqueueStream enqueues the messages received from the channel, until it is closed or ctx is done,
+// in batches of opts.BatchSize, committing after every opts.CommitInterval messages.
+//
+// It returns the number of enqueued messages. With a positive CommitInterval, the remainder is committed
+// when the channel is closed, and the uncommitted messages are rolled back on error - so only the committed
+// ones are counted, and the stream can be restarted after them.
+//
+// Commits are meaningful only with the (default) VisibleOnCommit enqueue visibility.
+func (Q *Queue) EnqueueStream(ctx context.Context, messages <-chan Message, opts StreamOptions) (int, error) {
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = 1
+	}
+	var enqueued, committed int
+	commit := func() error {
+		if opts.CommitInterval <= 0 || enqueued == committed {
+			return nil
+		}
+		if err := Q.Commit(); err != nil {
+			return Q.wrapErr(OpEnqueue, errors.WithMessage(err, "commit"))
+		}
+		committed = enqueued
+		return nil
+	}
+	fail := func(err error) (int, error) {
+		if opts.CommitInterval <= 0 {
+			return enqueued, err
+		}
+		if enqueued != committed {
+			if rbErr := Q.Rollback(); rbErr != nil {
+				err = errors.WithMessage(err, "rollback: "+rbErr.Error())
+			}
+		}
+		return committed, err
+	}
+	batch := make([]Message, 0, opts.BatchSize)
+	for done := false; !done; {
+		// A batch never crosses a commit boundary.
+		n := opts.BatchSize
+		if opts.CommitInterval > 0 {
+			if rem := opts.CommitInterval - (enqueued - committed); rem < n {
+				n = rem
+			}
+		}
+		batch = batch[:0]
+		for len(batch) < n && !done {
+			select {
+			case <-ctx.Done():
+				return fail(ctx.Err())
+			case msg, ok := <-messages:
+				if !ok {
+					done = true
+				} else {
+					batch = append(batch, msg)
+				}
+			}
+		}
+		if len(batch) != 0 {
+			if err := Q.Enqueue(batch); err != nil {
+				return fail(errors.WithMessage(err, fmt.Sprintf("after %d messages", enqueued)))
+			}
+			enqueued += len(batch)
+		}
+		if done || enqueued-committed >= opts.CommitInterval {
+			if err := commit(); err != nil {
+				return fail(err)
+			}
+		}
+		if opts.Progress != nil && len(batch) != 0 {
+			opts.Progress(enqueued, committed)
+		}
+	}
+	return enqueued, nil
+}
+
+// EnqueueReader enqueues a message with the contents of r as payload, streamed into a temporary LOB,
+// without holding the whole payload in memory - for example to enqueue large files.
+//
+// AQ payloads cannot be LOBs themselves, so the queue's payload type must be an object type with exactly one
+// BLOB attribute: the payload is a new object, with the LOB in that attribute (the others are NULL).
+// Message.PayloadReader of the dequeued message reads the LOB back.
+//
+// With a non-negative size, exactly size bytes are read from r (fewer is an io.ErrUnexpectedEOF error);
+// with a negative (unknown) size, r is read till io.EOF. ctx is checked between the chunks.
+func (Q *Queue) EnqueueReader(ctx context.Context, r io.Reader, size int64) error {
+	Q.mu.Lock()
+	defer Q.mu.Unlock()
+	attr, err := blobAttribute(Q.payloadType)
+	if err != nil {
+		return Q.wrapErr(OpEnqueue, errors.Wrapf(err, "queue %s", Q.name))
+	}
+	lob, err := Q.conn.NewTempLob(false)
+	if err != nil {
+		return Q.wrapErr(OpEnqueue, err)
+	}
+	defer C.dpiLob_release(lob.dpiLob)
+	w := &dpiLobWriter{conn: Q.conn, dpiLob: lob.dpiLob}
+	if size >= 0 {
+		r = io.LimitReader(r, size)
+	}
+	var written int64
+	buf := make([]byte, lobChunkSize)
+	for {
+		if err = ctx.Err(); err != nil {
+			w.Close()
+			return err
+		}
+		n, rErr := io.ReadFull(r, buf)
+		if n != 0 {
+			if _, err = w.Write(buf[:n]); err != nil {
+				w.Close()
+				return Q.wrapErr(OpEnqueue, err)
+			}
+			written += int64(n)
+		}
+		if rErr == io.EOF || rErr == io.ErrUnexpectedEOF {
+			break
+		}
+		if rErr != nil {
+			w.Close()
+			return Q.wrapErr(OpEnqueue, errors.Wrap(rErr, "read"))
+		}
+	}
+	if err = w.Close(); err != nil {
+		return Q.wrapErr(OpEnqueue, err)
+	}
+	if size >= 0 && written != size {
+		return Q.wrapErr(OpEnqueue, errors.Wrapf(io.ErrUnexpectedEOF, "read %d bytes of %d", written, size))
+	}
+
+	obj, err := Q.payloadType.NewObject()
+	if err != nil {
+		return Q.wrapErr(OpEnqueue, err)
+	}
+	defer obj.Close()
+	if err = obj.Set(attr, lob); err != nil {
+		return Q.wrapErr(OpEnqueue, errors.WithMessage(err, attr))
+	}
+	return Q.enqueueChecked([]Message{{Object: obj}})
+}
+
+// lobChunkSize is the size of the chunks EnqueueReader writes to the LOB.
+const lobChunkSize = 1 << 20
+
+// blobAttribute returns the name of the only BLOB attribute of the object type.
+func blobAttribute(typ *ObjectType) (string, error) {
+	if typ == nil {
+		return "", errors.Wrap(ErrWrongPayloadType, "RAW payload has no BLOB attribute")
+	}
+	var name string
+	for k, a := range typ.Attributes {
+		if a.OracleTypeNum != C.DPI_ORACLE_TYPE_BLOB {
+			continue
+		}
+		if name != "" {
+			return "", errors.Wrapf(ErrWrongPayloadType, "%s has more than one BLOB attribute (%s, %s)", typ.FullName(), name, k)
+		}
+		name = k
+	}
+	if name == "" {
+		return "", errors.Wrapf(ErrWrongPayloadType, "%s has no BLOB attribute", typ.FullName())
+	}
+	return name, nil
+}
+
+// EnqueueObject creates a payload object of the queue's payload type, sets its attributes by name from attrs,
+// and enqueues it.
+//
+// The attribute names are case-insensitive, unless quoted (e.g. `"MixedCase"`, for an attribute created quoted).
+// An unknown name is an ErrNoSuchKey error.
+// The values are of the types accepted by Object.Set, and any Go integer type; a nil value leaves the attribute NULL.
+func (Q *Queue) EnqueueObject(attrs map[string]interface{}) error {
+	if Q.payloadType == nil {
+		return Q.wrapErr(OpEnqueue, errors.Wrapf(ErrWrongPayloadType, "queue %s has RAW payload", Q.name))
+	}
+	obj, err := Q.payloadType.NewObject()
+	if err != nil {
+		return Q.wrapErr(OpEnqueue, errors.WithMessage(err, "newObject"))
+	}
+	defer obj.Close()
+	for name, v := range attrs {
+		if len(name) > 1 && name[0] == '"' && name[len(name)-1] == '"' {
+			name = name[1 : len(name)-1]
+		} else {
+			name = strings.ToUpper(name)
+		}
+		if _, ok := obj.Attributes[name]; !ok {
+			return Q.wrapErr(OpEnqueue, errors.Wrapf(ErrNoSuchKey, "%s has no attribute %s", Q.payloadType.FullName(), name))
+		}
+		if v == nil {
+			continue
+		}
+		if err = obj.Set(name, coerceInt(v)); err != nil {
+			return Q.wrapErr(OpEnqueue, errors.WithMessage(err, name))
+		}
+	}
+	return Q.Enqueue([]Message{{Object: obj}})
+}
+
+// DequeueTyped dequeues at most max messages of an object queue, and decodes their payloads
+// into dest, which must be a pointer to a slice of structs (or of struct pointers).
+// The decoded elements are appended to the slice; the number of messages dequeued is returned.
+//
+// A struct field is filled from the attribute named in its `goracle:"NAME"` tag,
+// or from the attribute with its upper-cased name; a field tagged `goracle:"-"` is skipped.
+// The attributes without a field are ignored, NULL attributes leave the field's zero value.
+//
+// A message which cannot be decoded is skipped (it is dequeued, so its payload is lost): the others are appended,
+// and a *DecodeError (see errors.Cause) lists the failed ones.
+func (Q *Queue) DequeueTyped(dest interface{}, max int) (int, error) {
+	rv := reflect.ValueOf(dest)
+	if rv.Kind() != reflect.Ptr || rv.Elem().Kind() != reflect.Slice {
+		return 0, errors.Errorf("dest must be a pointer to a slice, got %T", dest)
+	}
+	slice := rv.Elem()
+	elemType := slice.Type().Elem()
+	structType := elemType
+	if elemType.Kind() == reflect.Ptr {
+		structType = elemType.Elem()
+	}
+	if structType.Kind() != reflect.Struct {
+		return 0, errors.Errorf("dest must be a pointer to a slice of structs, got %T", dest)
+	}
+	if Q.payloadType == nil {
+		return 0, Q.wrapErr(OpDequeue, errors.Wrapf(ErrWrongPayloadType, "queue %s has RAW payload", Q.name))
+	}
+	if max < 1 {
+		max = 1
+	}
+	msgs := make([]Message, max)
+	n, err := Q.Dequeue(msgs)
+	defer func() {
+		for _, m := range msgs[:n] {
+			if m.Object != nil {
+				m.Object.Close()
+			}
+		}
+	}()
+	// The messages are removed already: decode all of them, and report the ones failed.
+	var decErr DecodeError
+	for i, m := range msgs[:n] {
+		if m.Object == nil {
+			decErr.add(i, errors.Wrap(ErrWrongPayloadType, "no Object payload"))
+			continue
+		}
+		elem := reflect.New(structType)
+		if err := decodeObject(m.Object, elem.Elem()); err != nil {
+			decErr.add(i, err)
+			continue
+		}
+		if elemType.Kind() != reflect.Ptr {
+			elem = elem.Elem()
+		}
+		slice = reflect.Append(slice, elem)
+	}
+	rv.Elem().Set(slice)
+	if err == nil && len(decErr.Indexes) != 0 {
+		err = Q.wrapErr(OpDequeue, &decErr)
+	}
+	return n, err
+}
+
+// DecodeError is returned by DequeueTyped for the dequeued messages which could not be decoded:
+// their payloads are lost, but the other messages are decoded.
+type DecodeError struct {
+	// Indexes are the indexes of the failed messages among the dequeued ones, Errs are their errors.
+	Indexes []int
+	Errs    []error
+}
+
+func (de *DecodeError) add(i int, err error) {
+	de.Indexes = append(de.Indexes, i)
+	de.Errs = append(de.Errs, err)
+}
+
+func (de *DecodeError) Error() string {
+	return fmt.Sprintf("%d messages could not be decoded, the first (%d.): %v", len(de.Indexes), de.Indexes[0], de.Errs[0])
+}
+
+// decodeObject sets the fields of the struct rv from the attributes of O, see DequeueTyped.
+func decodeObject(O *Object, rv reflect.Value) error {
+	typ := rv.Type()
+	for i := 0; i < typ.NumField(); i++ {
+		f := typ.Field(i)
+		if f.PkgPath != "" {
+			continue
+		}
+		name := f.Tag.Get("goracle")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = strings.ToUpper(f.Name)
+		}
+		if _, ok := O.Attributes[name]; !ok {
+			continue
+		}
+		v, err := O.Get(name)
+		if err != nil {
+			return errors.WithMessage(err, name)
+		}
+		if err = setField(rv.Field(i), v); err != nil {
+			return errors.WithMessage(err, f.Name)
+		}
+	}
+	return nil
+}
+
+// setField sets the field to the attribute value v, converting numbers and strings.
+func setField(field reflect.Value, v interface{}) error {
+	if b, ok := v.([]byte); ok {
+		if b == nil {
+			return nil
+		}
+		if field.Kind() == reflect.Slice && field.Type().Elem().Kind() == reflect.Uint8 {
+			field.SetBytes(append([]byte(nil), b...))
+			return nil
+		}
+		v = string(b)
+	}
+	if v == nil {
+		return nil
+	}
+	if s, ok := v.(string); ok {
+		switch field.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			i, err := strconv.ParseInt(s, 10, 64)
+			if err != nil {
+				return err
+			}
+			field.SetInt(i)
+			return nil
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			u, err := strconv.ParseUint(s, 10, 64)
+			if err != nil {
+				return err
+			}
+			field.SetUint(u)
+			return nil
+		case reflect.Float32, reflect.Float64:
+			f, err := strconv.ParseFloat(s, 64)
+			if err != nil {
+				return err
+			}
+			field.SetFloat(f)
+			return nil
+		}
+	}
+	rv := reflect.ValueOf(v)
+	if rv.Type().AssignableTo(field.Type()) {
+		field.Set(rv)
+		return nil
+	}
+	if field.Kind() == reflect.String {
+		field.SetString(fmt.Sprintf("%v", v))
+		return nil
+	}
+	if rv.Type().ConvertibleTo(field.Type()) && rv.Kind() != reflect.String {
+		field.Set(rv.Convert(field.Type()))
+		return nil
+	}
+	return errors.Errorf("cannot set %s from %T", field.Type(), v)
+}
+
+// coerceInt converts the Go integer types to int64 or uint64, as accepted by Data.Set.
+func coerceInt(v interface{}) interface{} {
+	switch x := v.(type) {
+	case int:
+		return int64(x)
+	case int8:
+		return int64(x)
+	case int16:
+		return int64(x)
+	case int32:
+		return int64(x)
+	case uint:
+		return uint64(x)
+	case uint8:
+		return uint64(x)
+	case uint16:
+		return uint64(x)
+	case uint32:
+		return uint64(x)
+	}
+	return v
+}
+
+// SetCopyPayloads makes Enqueue copy the RAW payloads of the messages first (into a buffer reused by the Queue),
+// and work on the copies: the caller's Message.Raw is read only once, at the start.
+//
+// This narrows, but does not remove, the window of a data race with a concurrent modification:
+// a Message must not be modified while Enqueue runs. The Queue never keeps a reference to the enqueued messages
+// after Enqueue returns, so reusing a Message (overwriting its Raw) between the calls is safe in any case.
+func (Q *Queue) SetCopyPayloads(copyPayloads bool) {
+	Q.mu.Lock()
+	Q.copyPayloads = copyPayloads
+	if !copyPayloads {
+		Q.payloadBuf = nil
+	}
+	Q.mu.Unlock()
+}
+
+// SetRedactPayloads makes the enqueue errors omit the payload contents
+// (for example, when they contain personal data): the error names the count, the payload sizes
+// and the MsgIDs of the messages, if they have one.
+func (Q *Queue) SetRedactPayloads(redact bool) {
+	Q.mu.Lock()
+	Q.redact = redact
+	Q.mu.Unlock()
+}
+
+// describeMessages returns a size-bounded summary of the messages for error messages:
+// their count, the payload sizes of the first few, and a short prefix of the first payload -
+// or, with redact, the MsgIDs of the first few instead of the payload.
+func describeMessages(messages []Message, redact bool) string {
+	const maxSizes, maxPrefix = 8, 32
+	var buf strings.Builder
+	fmt.Fprintf(&buf, "%d messages, payload sizes [", len(messages))
+	for i, m := range messages {
+		if i == maxSizes {
+			buf.WriteString(" ...")
+			break
+		}
+		if i != 0 {
+			buf.WriteByte(' ')
+		}
+		if m.Object != nil {
+			buf.WriteString(m.Object.FullName())
+		} else {
+			fmt.Fprintf(&buf, "%d", len(m.Raw))
+		}
+	}
+	buf.WriteByte(']')
+	if redact {
+		var ids []string
+		for i, m := range messages {
+			if i == maxSizes {
+				break
+			}
+			if m.MsgID != zeroMsgID {
+				ids = append(ids, fmt.Sprintf("%x", m.MsgID))
+			}
+		}
+		if len(ids) != 0 {
+			fmt.Fprintf(&buf, ", MsgIDs %v", ids)
+		}
+		return buf.String()
+	}
+	if len(messages) != 0 && messages[0].Object == nil {
+		p := messages[0].Raw
+		if len(p) > maxPrefix {
+			p = p[:maxPrefix]
+		}
+		fmt.Fprintf(&buf, ", first payload %q", p)
+		if len(p) < len(messages[0].Raw) {
+			buf.WriteString("...")
+		}
+	}
+	return buf.String()
+}
+
+// SetPropsPoolSize sets the number of message properties handles kept for reuse by Enqueue,
+// sparing their allocation for each enqueued message. Zero (the default) disables the reuse.
+//
+// The dequeued messages' properties are allocated by ODPI-C, so they are not pooled.
+// A pooled handle keeps a reference to the last enqueued payload (Object) till it is reused.
+func (Q *Queue) SetPropsPoolSize(n int) {
+	if n < 0 {
+		n = 0
+	}
+	Q.mu.Lock()
+	defer Q.mu.Unlock()
+	Q.propsPoolSize = n
+	for len(Q.propsPool) > n {
+		last := len(Q.propsPool) - 1
+		C.dpiMsgProps_release(Q.propsPool[last])
+		Q.propsPool = Q.propsPool[:last]
+	}
+}
+
+// getProps returns a message properties handle from the pool, or a new one. Q.mu must be held.
+func (Q *Queue) getProps() (*C.dpiMsgProps, error) {
+	if n := len(Q.propsPool); n != 0 {
+		p := Q.propsPool[n-1]
+		Q.propsPool = Q.propsPool[:n-1]
+		return p, nil
+	}
+	var p *C.dpiMsgProps
+	if C.dpiConn_newMsgProps(Q.conn.dpiConn, &p) == C.DPI_FAILURE {
+		return nil, errors.WithMessage(Q.conn.getError(), "newMsgProps")
+	}
+	return p, nil
+}
+
+// putProps puts p back into the pool if it is reusable and there is room, or releases it.
+// The properties which toOra does not always set are reset to their defaults. Q.mu must be held.
+func (Q *Queue) putProps(p *C.dpiMsgProps, reusable bool) {
+	if reusable && len(Q.propsPool) < Q.propsPoolSize &&
+		C.dpiMsgProps_setCorrelation(p, nil, 0) != C.DPI_FAILURE &&
+		C.dpiMsgProps_setDelay(p, 0) != C.DPI_FAILURE &&
+		C.dpiMsgProps_setExceptionQ(p, nil, 0) != C.DPI_FAILURE &&
+		C.dpiMsgProps_setExpiration(p, -1) != C.DPI_FAILURE &&
+		C.dpiMsgProps_setPriority(p, DefaultPriority) != C.DPI_FAILURE {
+		Q.propsPool = append(Q.propsPool, p)
+		return
+	}
+	C.dpiMsgProps_release(p)
+}
+
+// EnqueueRaw enqueues the RAW payloads, one by one (to avoid Oracle bug 29928074),
+// and returns the MsgIDs of the enqueued messages.
+func (Q *Queue) EnqueueRaw(payloads ...[]byte) ([][MsgIDLength]byte, error) {
+	ids := make([][MsgIDLength]byte, 0, len(payloads))
+	msgs := make([]Message, 1)
+	for _, p := range payloads {
+		msgs[0] = Message{Raw: p}
+		if err := Q.Enqueue(msgs); err != nil {
+			return ids, err
+		}
+		ids = append(ids, msgs[0].MsgID)
+	}
+	return ids, nil
+}
+
+// EnqueueBroadcast enqueues the message to each of the queues, one by one.
+//
+// Each queue gets the message in a separate call to enqOne, to avoid Oracle bug 29928074.
+// An Object payload must be of each queue's payload type.
+//
+// The returned error is nil, or a *BroadcastError telling which queues failed.
+func EnqueueBroadcast(msg Message, queues ...*Queue) error {
+	var errs []error
+	var failed int
+	for i, Q := range queues {
+		err := Q.Enqueue([]Message{msg})
+		if err == nil {
+			continue
+		}
+		if errs == nil {
+			errs = make([]error, len(queues))
+		}
+		errs[i] = err
+		failed++
+	}
+	if failed == 0 {
+		return nil
+	}
+	return &BroadcastError{Errs: errs, Failed: failed}
+}
+
+// BroadcastError is returned by EnqueueBroadcast.
+type BroadcastError struct {
+	// Errs holds the error for each queue, in the order of the queues given to EnqueueBroadcast.
+	// It is nil for the queues which succeeded.
+	Errs []error
+	// Failed is the number of failed queues.
+	Failed int
+}
+
+func (be *BroadcastError) Error() string {
+	var buf strings.Builder
+	fmt.Fprintf(&buf, "enqueue failed to %d of %d queues:", be.Failed, len(be.Errs))
+	for i, err := range be.Errs {
+		if err != nil {
+			fmt.Fprintf(&buf, " [%d] %v;", i, err)
+		}
+	}
+	return buf.String()
+}
+
+// ErrNoRoute is returned by Router.Enqueue for a message without a target queue.
+var ErrNoRoute = errors.New("no route")
+
+// Router enqueues the messages of a mixed batch to different queues: each to the Queue chosen by Route,
+// with one Enqueue call per target queue.
+type Router struct {
+	// Route returns the target Queue of the message, or nil if it has none.
+	Route func(*Message) *Queue
+}
+
+// NewPayloadRouter returns a Router routing the messages by their payload type:
+// the RAW payloads to the (first) RAW queue, the Object payloads to the (first) queue of their ObjectType.
+func NewPayloadRouter(queues ...*Queue) *Router {
+	return &Router{Route: func(M *Message) *Queue {
+		for _, Q := range queues {
+			if M.Object == nil {
+				if Q.payloadType == nil {
+					return Q
+				}
+			} else if Q.payloadType != nil && Q.payloadType.FullName() == M.Object.ObjectType.FullName() {
+				return Q
+			}
+		}
+		return nil
+	}}
+}
+
+// Enqueue partitions the messages by their target queue (keeping their order within each partition),
+// and enqueues each partition, in the order of their first message. The MsgIDs (and Enqueued, DeliveryMode) are set in messages.
+//
+// A message without a target queue is an ErrNoRoute error, before enqueueing anything.
+// If a partition fails, the earlier ones stay enqueued, and the later ones are not enqueued.
+func (R *Router) Enqueue(messages []Message) error {
+	type partition struct {
+		Q       *Queue
+		indexes []int
+	}
+	var parts []partition
+	partOf := make(map[*Queue]int)
+	for i := range messages {
+		Q := R.Route(&messages[i])
+		if Q == nil {
+			return errors.Wrapf(ErrNoRoute, "%d. message", i)
+		}
+		j, ok := partOf[Q]
+		if !ok {
+			j = len(parts)
+			partOf[Q] = j
+			parts = append(parts, partition{Q: Q})
+		}
+		parts[j].indexes = append(parts[j].indexes, i)
+	}
+	if len(parts) == 1 {
+		return parts[0].Q.Enqueue(messages)
+	}
+	var batch []Message
+	for _, p := range parts {
+		batch = batch[:0]
+		for _, i := range p.indexes {
+			batch = append(batch, messages[i])
+		}
+		err := p.Q.Enqueue(batch)
+		for k, i := range p.indexes {
+			messages[i].setEnqueued(batch[k])
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Forward moves one message from src to dst atomically: dequeues it from src (DeqRemove, VisibleOnCommit,
+// with the other dequeue options in effect), enqueues the result of transform to dst (VisibleOnCommit),
+// and commits both in one transaction. Reports whether a message was forwarded.
+//
+// If transform or the enqueue fails, the transaction is rolled back, so the message stays in src
+// (to be delivered again, up to its max_retries), and nothing is enqueued to dst.
+// The atomicity needs one transaction, so src and dst must be on the same connection.
+// The transaction also commits anything else pending on the connection.
+//
+// The options of src and dst are kept. The Object of the dequeued message (if any) is closed after the enqueue.
+func Forward(ctx context.Context, src, dst *Queue, transform func(Message) (Message, error)) (bool, error) {
+	if src.conn == nil || dst.conn == nil {
+		return false, errors.New("queue is closed")
+	}
+	if src.conn != dst.conn {
+		return false, errors.Wrapf(ErrInvalidOptions, "forward from %s to %s: queues must share a connection", src.name, dst.name)
+	}
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+
+	msgs := make([]Message, 1)
+	n, err := func() (int, error) {
+		src.mu.Lock()
+		defer src.mu.Unlock()
+		D, err := src.deqOptions()
+		if err != nil {
+			return 0, err
+		}
+		defer src.setDeqOptions(D)
+		F := D
+		F.Mode, F.Visibility = DeqRemove, VisibleOnCommit
+		if err = src.setDeqOptions(F); err != nil {
+			return 0, err
+		}
+		return src.dequeue(msgs, nil)
+	}()
+	if err != nil || n == 0 {
+		return false, err
+	}
+	if msgs[0].Object != nil {
+		defer msgs[0].Object.Close()
+	}
+	rollback := func(err error) (bool, error) {
+		if rbErr := src.Rollback(); rbErr != nil {
+			return false, errors.WithMessage(rbErr, "rollback after "+err.Error())
+		}
+		return false, err
+	}
+
+	out, err := transform(msgs[0])
+	if err != nil {
+		return rollback(errors.WithMessage(err, "transform"))
+	}
+	if err = func() error {
+		dst.mu.Lock()
+		defer dst.mu.Unlock()
+		E, err := dst.EnqOptions()
+		if err != nil {
+			return err
+		}
+		defer dst.SetEnqOptions(E)
+		F := E
+		F.Visibility = VisibleOnCommit
+		if err = dst.SetEnqOptions(F); err != nil {
+			return err
+		}
+		return dst.enqueueChecked([]Message{out})
+	}(); err != nil {
+		return rollback(err)
+	}
+	if err = src.Commit(); err != nil {
+		return false, src.wrapErr("forward", errors.WithMessage(err, "commit"))
+	}
+	return true, nil
+}
+
+// PooledQueue enqueues on a connection checked out from the pool for each Enqueue call,
+// so it is safe to call concurrently.
+//
+// Object payloads are bound to the connection they've been created on,
+// so PooledQueue supports RAW payloads only.
+type PooledQueue struct {
+	db   *sql.DB
+	name string
+	// EnqOptions are set on the Queue before each Enqueue, if not zero.
+	EnqOptions EnqOptions
+}
+
+// NewPooledQueue returns a new PooledQueue for the named (RAW) queue.
+func NewPooledQueue(db *sql.DB, name string) *PooledQueue {
+	return &PooledQueue{db: db, name: name}
+}
+
+// Name of the queue.
+func (P *PooledQueue) Name() string { return P.name }
+
+// Enqueue the messages one by one (to avoid Oracle bug 29928074) on a connection
+// checked out from the pool just for this call, and commit.
+func (P *PooledQueue) Enqueue(ctx context.Context, messages []Message) error {
+	for i, m := range messages {
+		if m.Object != nil {
+			return errors.Errorf("%d. message: PooledQueue supports RAW payloads only", i)
+		}
+	}
+	conn, err := P.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	Q, err := NewQueue(ctx, conn, P.name, "")
+	if err != nil {
+		return err
+	}
+	defer Q.Close()
+	if P.EnqOptions != (EnqOptions{}) {
+		if err = Q.SetEnqOptions(P.EnqOptions); err != nil {
+			return err
+		}
+	}
+	for _, m := range messages {
+		if err = Q.Enqueue([]Message{m}); err != nil {
+			Q.Rollback()
+			return err
+		}
+	}
+	return Q.Commit()
+}
+
+// ErrWrongPayloadType is returned when the message's payload does not match the queue's payload type.
+var ErrWrongPayloadType = errors.New("wrong payload type")
+
+// ErrPayloadTooLarge is returned when the message's RAW payload is larger than the queue's limit.
+var ErrPayloadTooLarge = errors.New("payload too large")
+
+// MaxRawPayloadSize is the maximum size of a RAW payload Oracle accepts, and the default limit of a Queue.
+const MaxRawPayloadSize = 32767
+
+// SetMaxRawSize sets the size limit of the RAW payloads checked by Enqueue before calling Oracle.
+// A non-positive n restores the default MaxRawPayloadSize.
+func (Q *Queue) SetMaxRawSize(n int) {
+	if n <= 0 {
+		n = MaxRawPayloadSize
+	}
+	Q.mu.Lock()
+	Q.maxRawSize = n
+	Q.mu.Unlock()
+}
+
+// checkPayload checks that the message's payload kind matches the queue's payload type,
+// and that a RAW payload is within the size limit. Q.mu must be held.
+func (Q *Queue) checkPayload(M *Message) error {
+	if Q.payloadType == nil {
+		if M.Object != nil {
+			return errors.Wrapf(ErrWrongPayloadType, "queue %s has RAW payload, message has Object (%s)", Q.name, M.Object.FullName())
+		}
+		// The size of a compressed payload is checked after the compression.
+		if n := len(M.Raw) + headersSize(M.Headers); n > Q.maxRawSize && !Q.compresses(M) {
+			return errors.Wrapf(ErrPayloadTooLarge, "queue %s accepts at most %d bytes, message has %d", Q.name, Q.maxRawSize, n)
+		}
+		return nil
+	}
+	if len(M.Headers) != 0 {
+		return errors.Errorf("queue %s has %s payload, Headers need RAW payload", Q.name, Q.payloadType.FullName())
+	}
+	if M.Object == nil {
+		return errors.Wrapf(ErrWrongPayloadType, "queue %s has %s payload, message has RAW", Q.name, Q.payloadType.FullName())
+	}
+	if got, want := M.Object.FullName(), Q.payloadType.FullName(); got != want {
+		return errors.Wrapf(ErrWrongPayloadType, "queue %s has %s payload, message has %s", Q.name, want, got)
+	}
+	return nil
+}
+
+// DefaultPriority is the priority of the messages enqueued without one.
+const DefaultPriority = 1
+
+// Message is a message - either received or being sent.
+//
+// The Object of a dequeued message carries its actual ObjectType - with a dequeue transformation,
+// the transformation's target type - and must be closed after use. The ObjectType is shared, must not be closed.
+//
+// Enqueued has second precision only: Oracle returns the enqueue time as an OCIDate,
+// so its sub-second part is always zero. For finer ordering, use the ENQ_TIME column of the queue table.
+// Enqueue sets Enqueued of the enqueued messages with the server's time only with Queue.SetEnqueuedLookup.
+//
+// The DeliveryMode of a message is not sent: Enqueue uses the EnqOptions.DeliveryMode in effect
+// (persistent by default), and sets it in DeliveryMode of the enqueued messages.
+//
+// A Message must not be modified while it is being enqueued. Enqueue keeps no reference to it afterwards,
+// so one Message can be reused for consecutive enqueues (see also Queue.SetCopyPayloads).
+type Message struct {
+	DeliveryMode          DeliveryMode
+	Enqueued              time.Time
+	Delay, Expiration     int32
+	Priority, NumAttempts int32
+	// PriorityValid makes a zero Priority be set on enqueue, too.
+	// An unset (zero and not valid) Priority leaves the default priority of AQ (DefaultPriority).
+	PriorityValid           bool
+	Correlation, ExceptionQ string
+	MsgID, OriginalMsgID    [16]byte
+	State                   MessageState
+	Raw                     []byte
+	Object                  *Object
+	// Headers are key/value pairs sent in an envelope of the RAW payload, see the framing at headersMagic.
+	// They are set on dequeue only by a Queue with SetDequeueHeaders(true).
+	Headers map[string]string
+	// Visibility, when set, overrides the enqueue Visibility of the Queue for this message, see Queue.EnqueueWith.
+	// It is not set on dequeue.
+	Visibility Visibility
+}
+
+// TraceIDHeader is the header carrying the trace id, see EnqueueContext.
+const TraceIDHeader = "goracle-trace-id"
+
+const traceIDCtxKey = ctxKey("traceID")
+
+// ContextWithTraceID returns a context with the specified trace id, to be propagated
+// through the queue by EnqueueContext.
+func ContextWithTraceID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, traceIDCtxKey, id)
+}
+
+// TraceIDFromContext returns the trace id of ctx, set by ContextWithTraceID; empty if none.
+func TraceIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(traceIDCtxKey).(string)
+	return id
+}
+
+// TraceContext returns ctx with the trace id of the message (see EnqueueContext),
+// for correlating the consumer's span with the producer's.
+// The message must be dequeued by a Queue with SetDequeueHeaders(true).
+// If the message has no trace id, ctx is returned as is.
+func (M Message) TraceContext(ctx context.Context) context.Context {
+	id, ok := M.Headers[TraceIDHeader]
+	if !ok {
+		return ctx
+	}
+	return ContextWithTraceID(ctx, id)
+}
+
+// headersMagic starts the envelope of a RAW payload with Headers:
+//
+//	"GOQH1" | uvarint(number of headers) | (uvarint(len(key)) key uvarint(len(value)) value)... | payload
+//
+// The headers are sorted by key. A dequeued RAW payload starting with a valid envelope
+// is split to Headers and Raw by a Queue with SetDequeueHeaders(true); other consumers see the envelope.
+// A message without Headers is sent as is.
+var headersMagic = []byte("GOQH1")
+
+// headersSize returns the size of the envelope of the headers.
+func headersSize(headers map[string]string) int {
+	if len(headers) == 0 {
+		return 0
+	}
+	var tmp [binary.MaxVarintLen64]byte
+	n := len(headersMagic) + binary.PutUvarint(tmp[:], uint64(len(headers)))
+	for k, v := range headers {
+		n += binary.PutUvarint(tmp[:], uint64(len(k))) + len(k) + binary.PutUvarint(tmp[:], uint64(len(v))) + len(v)
+	}
+	return n
+}
+
+// appendHeaders appends the envelope of the headers to dst.
+func appendHeaders(dst []byte, headers map[string]string) []byte {
+	keys := make([]string, 0, len(headers))
+	for k := range headers {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var tmp [binary.MaxVarintLen64]byte
+	dst = append(dst, headersMagic...)
+	dst = append(dst, tmp[:binary.PutUvarint(tmp[:], uint64(len(keys)))]...)
+	for _, k := range keys {
+		v := headers[k]
+		dst = append(dst, tmp[:binary.PutUvarint(tmp[:], uint64(len(k)))]...)
+		dst = append(dst, k...)
+		dst = append(dst, tmp[:binary.PutUvarint(tmp[:], uint64(len(v)))]...)
+		dst = append(dst, v...)
+	}
+	return dst
+}
+
+// parseHeaders splits the payload to headers and body, if it starts with a valid envelope.
+// Otherwise it returns nil headers and the payload.
+func parseHeaders(p []byte) (map[string]string, []byte) {
+	if !bytes.HasPrefix(p, headersMagic) {
+		return nil, p
+	}
+	b := p[len(headersMagic):]
+	next := func() (string, bool) {
+		n, k := binary.Uvarint(b)
+		if k <= 0 || uint64(len(b)-k) < n {
+			return "", false
+		}
+		s := string(b[k : k+int(n)])
+		b = b[k+int(n):]
+		return s, true
+	}
+	count, k := binary.Uvarint(b)
+	if k <= 0 || count > uint64(len(b)) {
+		return nil, p
+	}
+	b = b[k:]
+	headers := make(map[string]string, int(count))
+	for i := uint64(0); i < count; i++ {
+		key, ok := next()
+		if !ok {
+			return nil, p
+		}
+		value, ok := next()
+		if !ok {
+			return nil, p
+		}
+		headers[key] = value
+	}
+	return headers, b
+}
+
+// WriteEnvelope returns the versioned payload of body: the version byte, followed by body.
+// ReadEnvelope parses it on the consumer side.
+func WriteEnvelope(version byte, body []byte) []byte {
+	p := make([]byte, 1+len(body))
+	p[0] = version
+	copy(p[1:], body)
+	return p
+}
+
+// ErrShortEnvelope is returned by ReadEnvelope for a payload without a version byte.
+var ErrShortEnvelope = errors.New("payload too short for a versioned envelope")
+
+// ReadEnvelope splits the versioned payload p (see WriteEnvelope) to its version and body.
+// The body shares the memory of p.
+func ReadEnvelope(p []byte) (byte, []byte, error) {
+	if len(p) < 1 {
+		return 0, nil, errors.Wrapf(ErrShortEnvelope, "got %d bytes", len(p))
+	}
+	return p[0], p[1:], nil
+}
+
+// compressMagic starts a compressed RAW payload (see Queue.SetCompression):
+//
+//	"GOQZ1" | gzip(payload, with the Headers envelope if any)
+//
+// A dequeued RAW payload starting with a valid compressed frame is decompressed
+// (whatever the setting of the dequeuing Queue is); consumers not using this package see the frame.
+var compressMagic = []byte("GOQZ1")
+
+// SetDequeueHeaders makes the dequeue split the Headers envelope (see headersMagic) off the RAW payloads,
+// setting Headers and the rest as Raw. It is off by default, so a payload which happens to start
+// with "GOQH1" is dequeued as is - enqueue with Headers only to consumers which turn it on.
+func (Q *Queue) SetDequeueHeaders(parse bool) {
+	Q.mu.Lock()
+	Q.dequeueHeaders = parse
+	Q.mu.Unlock()
+}
+
+// SetCompression makes Enqueue compress the RAW payloads (with their Headers) of at least min bytes with gzip,
+// framed as described at compressMagic. A payload whose compressed form is not smaller is sent as is.
+// The size limit (see SetMaxRawSize) applies to the compressed payload.
+//
+// The dequeue decompresses the payloads (up to MaxDecompressedSize) transparently, so the consumers using this package
+// see the original Raw (and Headers, with SetDequeueHeaders). Other consumers see the framed bytes, and must strip
+// the 5 bytes of "GOQZ1" and gunzip the rest themselves.
+//
+// A non-positive min turns the compression off (the default).
+func (Q *Queue) SetCompression(min int) {
+	if min < 0 {
+		min = 0
+	}
+	Q.mu.Lock()
+	Q.compressMin = min
+	if min == 0 {
+		Q.gzw = nil
+	}
+	Q.mu.Unlock()
+}
+
+// compresses reports whether the message's RAW payload is to be compressed. Q.mu must be held.
+func (Q *Queue) compresses(M *Message) bool {
+	return Q.compressMin > 0 && M.Object == nil && len(M.Raw)+headersSize(M.Headers) >= Q.compressMin
+}
+
+// compress returns the message with its payload (and Headers) compressed,
+// or unchanged if compression does not make it smaller. Q.mu must be held.
+func (Q *Queue) compress(M Message) (Message, error) {
+	var b bytes.Buffer
+	n := len(M.Raw) + headersSize(M.Headers)
+	b.Grow(n)
+	b.Write(compressMagic)
+	if Q.gzw == nil {
+		Q.gzw = gzip.NewWriter(&b)
+	} else {
+		Q.gzw.Reset(&b)
+	}
+	if len(M.Headers) != 0 {
+		if _, err := Q.gzw.Write(appendHeaders(make([]byte, 0, headersSize(M.Headers)), M.Headers)); err != nil {
+			return M, errors.Wrap(err, "compress")
+		}
+	}
+	if _, err := Q.gzw.Write(M.Raw); err != nil {
+		return M, errors.Wrap(err, "compress")
+	}
+	if err := Q.gzw.Close(); err != nil {
+		return M, errors.Wrap(err, "compress")
+	}
+	if b.Len() >= n {
+		if n > Q.maxRawSize {
+			return M, errors.Wrapf(ErrPayloadTooLarge, "queue %s accepts at most %d bytes, message has %d", Q.name, Q.maxRawSize, n)
+		}
+		return M, nil
+	}
+	if b.Len() > Q.maxRawSize {
+		return M, errors.Wrapf(ErrPayloadTooLarge, "queue %s accepts at most %d bytes, message has %d compressed", Q.name, Q.maxRawSize, b.Len())
 	}
-	C.free(unsafe.Pointer(value))
-	return &Q, err
+	M.Raw, M.Headers = b.Bytes(), nil
+	return M, nil
 }
 
-// Close the queue.
-func (Q *Queue) Close() error {
-	c, q := Q.conn, Q.dpiQueue
-	Q.conn, Q.dpiQueue = nil, nil
-	if q == nil {
-		return nil
+// MaxDecompressedSize is the maximum size of a decompressed RAW payload (see Queue.SetCompression):
+// a compressed frame which would expand beyond it is left as is, as the message may come from anyone
+// with enqueue privilege, and gzip compresses uniform data about a thousandfold.
+const MaxDecompressedSize = 64 * MaxRawPayloadSize
+
+// decompress returns the decompressed payload, if p is a valid compressed frame (see compressMagic)
+// of at most MaxDecompressedSize bytes decompressed. Otherwise it returns p.
+func decompress(p []byte) []byte {
+	if !bytes.HasPrefix(p, compressMagic) {
+		return p
 	}
-	if C.dpiQueue_release(q) == C.DPI_FAILURE {
-		return errors.WithMessage(c.getError(), "release")
+	zr, err := gzip.NewReader(bytes.NewReader(p[len(compressMagic):]))
+	if err != nil {
+		return p
 	}
-	return nil
+	var b bytes.Buffer
+	if _, err = io.Copy(&b, io.LimitReader(zr, MaxDecompressedSize+1)); err != nil {
+		return p
+	}
+	if b.Len() > MaxDecompressedSize {
+		return p
+	}
+	if err = zr.Close(); err != nil {
+		return p
+	}
+	return b.Bytes()
 }
 
-// Name of the queue.
-func (Q *Queue) Name() string { return Q.name }
+// maxCorrelationLength is the maximum length of the Correlation of a message.
+const maxCorrelationLength = 128
 
-// EnqOptions returns the queue's enqueue options in effect.
-func (Q *Queue) EnqOptions() (EnqOptions, error) {
-	var E EnqOptions
-	var opts *C.dpiEnqOptions
-	if C.dpiQueue_getEnqOptions(Q.dpiQueue, &opts) == C.DPI_FAILURE {
-		return E, errors.WithMessage(Q.drv.getError(), "getEnqOptions")
+// PayloadReader returns an io.Reader over the RAW payload, for example to io.Copy it to a client.
+//
+// For an Object payload with exactly one BLOB attribute, it reads that LOB (see Queue.EnqueueReader),
+// which is valid till the Object is closed. An error getting the attribute is returned by Read.
+func (M *Message) PayloadReader() io.Reader {
+	if M.Object == nil {
+		return bytes.NewReader(M.Raw)
 	}
-	err := E.fromOra(Q.conn.drv, opts)
-	return E, err
+	attr, err := blobAttribute(&M.Object.ObjectType)
+	if err != nil {
+		return bytes.NewReader(M.Raw)
+	}
+	v, err := M.Object.Get(attr)
+	if err != nil {
+		return errReader{errors.WithMessage(err, attr)}
+	}
+	lob, ok := v.(*Lob)
+	if !ok || lob == nil || lob.Reader == nil {
+		// A NULL LOB.
+		return bytes.NewReader(nil)
+	}
+	if lr, ok := lob.Reader.(*dpiLobReader); ok && lr.conn == nil {
+		lr.conn = M.Object.conn
+	}
+	return lob
 }
 
-// DeqOptions returns the queue's dequeue options in effect.
-func (Q *Queue) DeqOptions() (DeqOptions, error) {
-	var D DeqOptions
-	var opts *C.dpiDeqOptions
-	if C.dpiQueue_getDeqOptions(Q.dpiQueue, &opts) == C.DPI_FAILURE {
-		return D, errors.WithMessage(Q.drv.getError(), "getDeqOptions")
-	}
-	err := D.fromOra(Q.conn.drv, opts)
-	return D, err
+type errReader struct{ err error }
+
+func (r errReader) Read([]byte) (int, error) { return 0, r.err }
+
+// PayloadWriter returns an io.Writer appending to the RAW payload, to be enqueued.
+// Set Raw to Raw[:0] before writing to reuse its buffer.
+func (M *Message) PayloadWriter() io.Writer { return payloadWriter{M} }
+
+type payloadWriter struct{ M *Message }
+
+func (w payloadWriter) Write(p []byte) (int, error) {
+	w.M.Raw = append(w.M.Raw, p...)
+	return len(p), nil
 }
 
-// Dequeues messages into the given slice.
-// Returns the number of messages filled in the given slice.
-func (Q *Queue) Dequeue(messages []Message) (int, error) {
-	Q.mu.Lock()
-	defer Q.mu.Unlock()
-	var props []*C.dpiMsgProps
-	if cap(Q.props) >= len(messages) {
-		props = Q.props[:len(messages)]
-	} else {
-		props = make([]*C.dpiMsgProps, len(messages))
-	}
-	Q.props = props
+// SetDeliveryTime sets the Delay so that the message becomes available for dequeue at t.
+//
+// Oracle counts the delay from the enqueue, so the delay is computed from the local clock (time.Now),
+// rounded up to seconds; enqueue the message right after this.
+// t is an instant, so a wall-clock time in a named location (time.Date with time.LoadLocation) accounts for DST.
+// For a t in the past the Delay is zero: the message is available at once.
+func (M *Message) SetDeliveryTime(t time.Time) { M.Delay = delayUntil(t, time.Now()) }
 
-	var ok C.int
-	num := C.uint(len(props))
-	if num == 1 {
-		ok = C.dpiQueue_deqOne(Q.dpiQueue, &props[0])
-	} else {
-		ok = C.dpiQueue_deqMany(Q.dpiQueue, &num, &props[0])
+// RemainingDelay returns the time left at now till the message becomes available for dequeue
+// (the Delay is the one set on enqueue, counted from Enqueued): zero if it is ready,
+// or its Enqueued time is unknown (see Queue.SetEnqueuedLookup).
+//
+// Enqueued has second precision, and is compared to the local clock, so the result is approximate.
+func (M Message) RemainingDelay(now time.Time) time.Duration {
+	if M.Delay <= 0 || M.Enqueued.IsZero() {
+		return 0
 	}
-	if ok == C.DPI_FAILURE {
-		return 0, errors.WithMessage(Q.conn.getError(), "dequeue")
+	if d := M.Enqueued.Add(time.Duration(M.Delay) * time.Second).Sub(now); d > 0 {
+		return d
 	}
-	var firstErr error
-	for i, p := range props[:int(num)] {
-		if err := messages[i].fromOra(Q.conn, p); err != nil {
-			if firstErr == nil {
-				firstErr = err
-			}
-		}
-		C.dpiMsgProps_release(p)
+	return 0
+}
+
+// delayUntil returns the seconds from now till t, rounded up, but at least zero.
+func delayUntil(t, now time.Time) int32 {
+	d := t.Sub(now)
+	if d <= 0 {
+		return 0
 	}
-	return int(num), firstErr
+	return int32((d + time.Second - 1) / time.Second)
 }
 
-// Enqueue all the messages given.
-//
-// WARNING: calling this function in parallel on different connections acquired from the same pool may fail due to Oracle bug 29928074. Ensure that this function is not run in parallel, use standalone connections or connections from different pools, or make multiple calls to Queue.enqOne() instead. The function Queue.Dequeue() call is not affected.
-func (Q *Queue) Enqueue(messages []Message) error {
-	Q.mu.Lock()
-	defer Q.mu.Unlock()
-	var props []*C.dpiMsgProps
-	if cap(Q.props) >= len(messages) {
-		props = Q.props[:len(messages)]
-	} else {
-		props = make([]*C.dpiMsgProps, len(messages))
+// Validate checks the message's fields for obvious errors, before enqueueing.
+func (M Message) Validate() error {
+	if M.Raw != nil && M.Object != nil {
+		return errors.New("both Raw and Object payload is set")
 	}
-	Q.props = props
-	defer func() {
-		for _, p := range props {
-			if p != nil {
-				C.dpiMsgProps_release(p)
-			}
-		}
-	}()
-	for i, m := range messages {
-		if C.dpiConn_newMsgProps(Q.conn.dpiConn, &props[i]) == C.DPI_FAILURE {
-			return errors.WithMessage(Q.conn.getError(), "newMsgProps")
-		}
-		if err := m.toOra(Q.drv, props[i]); err != nil {
-			return err
-		}
+	if M.Delay < 0 {
+		return errors.Errorf("negative Delay %d", M.Delay)
 	}
-
-	var ok C.int
-	if len(messages) == 1 {
-		ok = C.dpiQueue_enqOne(Q.dpiQueue, props[0])
-	} else {
-		ok = C.dpiQueue_enqMany(Q.dpiQueue, C.uint(len(props)), &props[0])
+	if M.Expiration < -1 {
+		return errors.Errorf("Expiration %d is less than -1 (never)", M.Expiration)
 	}
-	if ok == C.DPI_FAILURE {
-		return errors.Wrapf(Q.conn.getError(), "enqueue %#v", messages)
+	if len(M.Correlation) > maxCorrelationLength {
+		return errors.Errorf("Correlation is longer (%d) than %d", len(M.Correlation), maxCorrelationLength)
 	}
 	return nil
 }
 
-// Message is a message - either received or being sent.
-type Message struct {
-	DeliveryMode            DeliveryMode
-	Enqueued                time.Time
-	Delay, Expiration       int32
-	Priority, NumAttempts   int32
-	Correlation, ExceptionQ string
-	MsgID, OriginalMsgID    [16]byte
-	State                   MessageState
-	Raw                     []byte
-	Object                  *Object
+// MessageBuilder builds a Message, see NewMessage.
+type MessageBuilder struct {
+	msg Message
+}
+
+// NewMessage returns a MessageBuilder for building a Message:
+//
+//	msg, err := NewMessage().Raw(b).Correlation(c).Priority(p).Delay(d).Build()
+func NewMessage() *MessageBuilder { return &MessageBuilder{} }
+
+// Raw sets the RAW payload.
+func (B *MessageBuilder) Raw(p []byte) *MessageBuilder { B.msg.Raw = p; return B }
+
+// Object sets the Object payload.
+func (B *MessageBuilder) Object(obj *Object) *MessageBuilder { B.msg.Object = obj; return B }
+
+// Correlation sets the correlation identifier.
+func (B *MessageBuilder) Correlation(c string) *MessageBuilder { B.msg.Correlation = c; return B }
+
+// Priority sets the priority - lower number is higher priority.
+func (B *MessageBuilder) Priority(p int32) *MessageBuilder {
+	B.msg.Priority, B.msg.PriorityValid = p, true
+	return B
+}
+
+// Delay sets the delay, truncated to seconds.
+func (B *MessageBuilder) Delay(d time.Duration) *MessageBuilder {
+	B.msg.Delay = int32(d / time.Second)
+	return B
+}
+
+// Expiration sets the expiration, truncated to seconds.
+func (B *MessageBuilder) Expiration(d time.Duration) *MessageBuilder {
+	B.msg.Expiration = int32(d / time.Second)
+	return B
+}
+
+// ExceptionQ sets the name of the exception queue.
+func (B *MessageBuilder) ExceptionQ(q string) *MessageBuilder { B.msg.ExceptionQ = q; return B }
+
+// Build validates and returns the Message.
+func (B *MessageBuilder) Build() (Message, error) {
+	return B.msg, B.msg.Validate()
 }
 
 func (M *Message) toOra(d *drv, props *C.dpiMsgProps) error {
@@ -231,10 +3839,21 @@ func (M *Message) toOra(d *drv, props *C.dpiMsgProps) error {
 		OK(C.dpiMsgProps_setOriginalMsgId(props, (*C.char)(unsafe.Pointer(&M.OriginalMsgID[0])), MsgIDLength), "setMsgOriginalId")
 	}
 
-	OK(C.dpiMsgProps_setPriority(props, C.int(M.Priority)), "setPriority")
+	if M.Priority != 0 || M.PriorityValid {
+		OK(C.dpiMsgProps_setPriority(props, C.int(M.Priority)), "setPriority")
+	}
 
 	if M.Object == nil {
-		OK(C.dpiMsgProps_setPayloadBytes(props, (*C.char)(unsafe.Pointer(&M.Raw[0])), C.uint(len(M.Raw))), "setPayloadBytes")
+		raw := M.Raw
+		if len(M.Headers) != 0 {
+			raw = append(appendHeaders(make([]byte, 0, headersSize(M.Headers)+len(raw)), M.Headers), raw...)
+		}
+		// ODPI-C does not accept a nil pointer, even for an empty payload.
+		value := (*C.char)(unsafe.Pointer(&zeroByte[0]))
+		if len(raw) != 0 {
+			value = (*C.char)(unsafe.Pointer(&raw[0]))
+		}
+		OK(C.dpiMsgProps_setPayloadBytes(props, value, C.uint(len(raw))), "setPayloadBytes")
 	} else {
 		OK(C.dpiMsgProps_setPayloadObject(props, M.Object.dpiObject), "setPayloadObject")
 	}
@@ -242,7 +3861,10 @@ func (M *Message) toOra(d *drv, props *C.dpiMsgProps) error {
 	return firstErr
 }
 
-func (M *Message) fromOra(c *conn, props *C.dpiMsgProps) error {
+// fromOra fills the Message from the props.
+// The RAW payload is copied into buf, which is allocated if nil.
+// The enqueue time is interpreted in tz, or the connection's time zone if tz is nil.
+func (M *Message) fromOra(c *conn, props *C.dpiMsgProps, buf []byte, tz *time.Location, typ *ObjectType, headers bool) error {
 	var firstErr error
 	OK := func(ok C.int, name string) bool {
 		if ok == C.DPI_SUCCESS {
@@ -284,15 +3906,7 @@ func (M *Message) fromOra(c *conn, props *C.dpiMsgProps) error {
 	var ts C.dpiTimestamp
 	M.Enqueued = time.Time{}
 	if OK(C.dpiMsgProps_getEnqTime(props, &ts), "getEnqTime") {
-		tz := c.timeZone
-		if ts.tzHourOffset != 0 || ts.tzMinuteOffset != 0 {
-			tz = timeZoneFor(ts.tzHourOffset, ts.tzMinuteOffset)
-		}
-		M.Enqueued = time.Date(
-			int(ts.year), time.Month(ts.month), int(ts.day),
-			int(ts.hour), int(ts.minute), int(ts.second), int(ts.fsecond),
-			tz,
-		)
+		M.Enqueued = enqTimeFromOra(ts, c, tz)
 	}
 
 	M.Expiration = 0
@@ -302,54 +3916,143 @@ func (M *Message) fromOra(c *conn, props *C.dpiMsgProps) error {
 
 	M.MsgID = zeroMsgID
 	if OK(C.dpiMsgProps_getMsgId(props, &value, &length), "getMsgId") {
-		n := C.int(length)
-		if n > MsgIDLength {
-			n = MsgIDLength
-		}
-		copy(M.MsgID[:], (*((*[1 << 30]byte)(unsafe.Pointer(&value))))[:n:n])
+		M.MsgID = msgIDFromOra(value, length)
 	}
 
 	M.OriginalMsgID = zeroMsgID
 	if OK(C.dpiMsgProps_getOriginalMsgId(props, &value, &length), "getMsgOriginalId") {
-		n := C.int(length)
-		if n > MsgIDLength {
-			n = MsgIDLength
-		}
-		copy(M.OriginalMsgID[:], (*((*[1 << 30]byte)(unsafe.Pointer(&value))))[:n:n])
+		M.OriginalMsgID = msgIDFromOra(value, length)
 	}
 
-	M.Priority = 0
+	M.Priority, M.PriorityValid = 0, false
 	if OK(C.dpiMsgProps_getPriority(props, &cint), "getPriority") {
-		M.Priority = int32(cint)
+		M.Priority, M.PriorityValid = int32(cint), true
 	}
 
-	M.State = 0
+	M.State, M.Visibility = 0, 0
 	var state C.dpiMessageState
 	if OK(C.dpiMsgProps_getState(props, &state), "getState") {
 		M.State = MessageState(state)
 	}
 
 	M.Raw = nil
+	M.Headers = nil
 	M.Object = nil
 	var obj *C.dpiObject
 	if OK(C.dpiMsgProps_getPayload(props, &obj, &value, &length), "getPayload") {
 		if obj == nil {
-			M.Raw = append(make([]byte, 0, length), ((*[1 << 30]byte)(unsafe.Pointer(value)))[:int(length):int(length)]...)
-		} else {
-			M.Object = &Object{dpiObject: obj}
+			if buf == nil {
+				buf = make([]byte, 0, length)
+			}
+			M.Raw = append(buf[:0], ((*[1 << 30]byte)(unsafe.Pointer(value)))[:int(length):int(length)]...)
+			M.Raw = decompress(M.Raw)
+			if headers {
+				M.Headers, M.Raw = parseHeaders(M.Raw)
+			}
+		} else if OK(C.dpiObject_addRef(obj), "addRef") {
+			// The props hold the only reference, and are released after this.
+			M.Object = &Object{dpiObject: obj, ObjectType: ObjectType{conn: c}}
+			if typ != nil {
+				M.Object.ObjectType = *typ
+			}
 		}
 	}
 	return nil
 }
 
+// setEnqueued sets the fields set by the enqueue (MsgID, Enqueued, DeliveryMode) from the enqueued copy src.
+func (M *Message) setEnqueued(src Message) {
+	M.MsgID, M.Enqueued, M.DeliveryMode = src.MsgID, src.Enqueued, src.DeliveryMode
+}
+
+func msgIDFromOra(value *C.char, length C.uint) [MsgIDLength]byte {
+	var id [MsgIDLength]byte
+	n := C.int(length)
+	if n > MsgIDLength {
+		n = MsgIDLength
+	}
+	if value != nil {
+		copy(id[:], ((*[1 << 30]byte)(unsafe.Pointer(value)))[:n:n])
+	}
+	return id
+}
+
+// enqTimeFromOra converts the enqueue time to time.Time in tz (the connection's time zone if nil).
+// The enqueue time is an OCIDate: ODPI always returns zero fsecond (nanoseconds) and time zone offsets.
+// An unset OCIDate (month zero) is the zero time.Time.
+func enqTimeFromOra(ts C.dpiTimestamp, c *conn, tz *time.Location) time.Time {
+	if ts.month == 0 {
+		return time.Time{}
+	}
+	if tz == nil {
+		if tz = c.timeZone; tz == nil {
+			tz = time.Local
+		}
+	}
+	return time.Date(
+		int(ts.year), time.Month(ts.month), int(ts.day),
+		int(ts.hour), int(ts.minute), int(ts.second), int(ts.fsecond),
+		tz,
+	)
+}
+
 // EnqOptions are the options used to enqueue a message.
+//
+// Transformation is the name of a transformation created with DBMS_TRANSFORM.CREATE_TRANSFORMATION,
+// applied to the message before enqueueing it. Qualify it as "schema.name", as it is resolved
+// by Oracle at enqueue time, and an unqualified name is looked up in the session user's schema.
+// The message payload must be of the transformation's source type, so create the Queue
+// with that type as payload type (and not the queue table's type, which is the target).
+//
+// DeliverBuffered enqueues buffered messages, which are kept in memory and are not part of the transaction,
+// so it needs VisibleImmediate (see Validate).
+//
+// SequenceDeviation enqueues the messages ahead of the others: at the top of the queue (SeqTop),
+// or right before the message with RelativeMsgID (SeqBefore). It remains in effect until changed,
+// so reset it with a zero SequenceDeviation after the urgent message.
+// It applies to queues sorted by enqueue time only, and is deprecated by Oracle (since 10.2) -
+// for a lasting ordering, use a priority sorted queue table.
 type EnqOptions struct {
-	Transformation string
-	Visibility     Visibility
-	DeliveryMode   DeliveryMode
+	Transformation    string
+	Visibility        Visibility
+	DeliveryMode      DeliveryMode
+	SequenceDeviation SequenceDeviation
+	RelativeMsgID     [MsgIDLength]byte
+}
+
+// ErrInvalidOptions is returned by SetEnqOptions and SetDeqOptions for an invalid combination of options.
+var ErrInvalidOptions = errors.New("invalid options")
+
+// Validate the combination of the options:
+// buffered messages are not part of transactions, so DeliverBuffered needs an explicit VisibleImmediate,
+// and DeliverPersistentOrBuffered is valid for dequeue only.
+func (E EnqOptions) Validate() error {
+	switch E.DeliveryMode {
+	case 0, DeliverPersistent:
+		return nil
+	case DeliverBuffered:
+		if E.Visibility != VisibleImmediate {
+			return errors.Wrapf(ErrInvalidOptions, "buffered enqueue needs VisibleImmediate, not %v", E.Visibility)
+		}
+		return nil
+	default:
+		return errors.Wrapf(ErrInvalidOptions, "delivery mode %d is not valid for enqueue", E.DeliveryMode)
+	}
 }
 
-func (E EnqOptions) fromOra(d *drv, opts *C.dpiEnqOptions) error {
+// SequenceDeviation is the sequence deviation of the enqueue, see EnqOptions.
+type SequenceDeviation uint32
+
+const (
+	// SeqNone enqueues in the normal order.
+	SeqNone = SequenceDeviation(0)
+	// SeqBefore enqueues before the message with EnqOptions.RelativeMsgID.
+	SeqBefore = SequenceDeviation(2)
+	// SeqTop enqueues at the top of the queue.
+	SeqTop = SequenceDeviation(3)
+)
+
+func (E *EnqOptions) fromOra(d *drv, opts *C.dpiEnqOptions) error {
 	var firstErr error
 	OK := func(ok C.int, msg string) bool {
 		if ok == C.DPI_SUCCESS {
@@ -371,20 +4074,103 @@ func (E EnqOptions) fromOra(d *drv, opts *C.dpiEnqOptions) error {
 	if OK(C.dpiEnqOptions_getVisibility(opts, &vis), "getVisibility") {
 		E.Visibility = Visibility(vis)
 	}
+
+	var dev C.uint32_t
+	E.SequenceDeviation, E.RelativeMsgID = SeqNone, zeroMsgID
+	if OK(C.EnqOptionsGetSequenceDeviation(opts, &dev, &value, &length), "getSequenceDeviation") {
+		E.SequenceDeviation = SequenceDeviation(dev)
+		if length != 0 {
+			E.RelativeMsgID = msgIDFromOra(value, length)
+		}
+	}
+	return firstErr
+}
+
+func (E EnqOptions) toOra(d *drv, opts *C.dpiEnqOptions) error {
+	var firstErr error
+	OK := func(ok C.int, msg string) bool {
+		if ok == C.DPI_SUCCESS {
+			return true
+		}
+		if firstErr == nil {
+			firstErr = errors.WithMessage(d.getError(), msg)
+		}
+		return false
+	}
+
+	if E.DeliveryMode != 0 {
+		OK(C.dpiEnqOptions_setDeliveryMode(opts, C.dpiMessageDeliveryMode(E.DeliveryMode)), "setDeliveryMode")
+	}
+	value := C.CString(E.Transformation)
+	OK(C.dpiEnqOptions_setTransformation(opts, value, C.uint(len(E.Transformation))), "setTransformation")
+	C.free(unsafe.Pointer(value))
+	if E.Visibility != 0 {
+		OK(C.dpiEnqOptions_setVisibility(opts, C.dpiVisibility(E.Visibility)), "setVisibility")
+	}
+	if E.SequenceDeviation == SeqBefore {
+		OK(C.EnqOptionsSetSequenceDeviation(opts, C.uint32_t(E.SequenceDeviation),
+			(*C.char)(unsafe.Pointer(&E.RelativeMsgID[0])), MsgIDLength), "setSequenceDeviation")
+	} else {
+		OK(C.EnqOptionsSetSequenceDeviation(opts, C.uint32_t(E.SequenceDeviation), nil, 0), "setSequenceDeviation")
+	}
 	return firstErr
 }
 
 // DeqOptions are the options used to dequeue a message.
+//
+// On a multi-consumer queue, Consumer names the subscriber the dequeue is made for:
+// DeqRemove removes only that subscriber's copy of the message,
+// the other subscribers still receive it.
+//
+// Transformation is the name of a transformation ("schema.name", see EnqOptions) applied
+// to the message on dequeue: the Queue's payload type must be the transformation's target type.
+//
+// DeliveryMode filters the dequeued messages: persistent, buffered or both.
+// Unlike the other options, a zero DeliveryMode is not left as it was, but set to DeliverPersistent (the Oracle default),
+// so a buffered dequeue must be asked for in each DeqOptions.
+// ODPI-C cannot read it back, so Queue.DeqOptions reports the last one set on the Queue.
+// Dequeueing buffered messages needs VisibleImmediate (see Validate).
+//
+// Correlation and Condition are combined: only the messages matching both are dequeued.
+// Correlation may contain the LIKE wildcards % and _ (e.g. "ORDER.%" matches a prefix),
+// Condition is an SQL boolean expression on the message properties (tab.priority, tab.corrid, ...)
+// and the payload attributes (tab.user_data.attr).
+// MsgID, when set, is ANDed with them, too.
 type DeqOptions struct {
 	Condition, Consumer, Correlation string
 	MsgID, Transformation            string
 	Mode                             DeqMode
+	DeliveryMode                     DeliveryMode
 	Navigation                       DeqNavigation
 	Visibility                       Visibility
 	Wait                             uint32
 }
 
-func (D DeqOptions) fromOra(d *drv, opts *C.dpiDeqOptions) error {
+// Validate the combination of the options:
+// dequeueing buffered messages (DeliverBuffered or DeliverPersistentOrBuffered) needs an explicit VisibleImmediate.
+func (D DeqOptions) Validate() error {
+	switch D.DeliveryMode {
+	case 0, DeliverPersistent:
+		return nil
+	case DeliverBuffered, DeliverPersistentOrBuffered:
+		if D.Visibility != VisibleImmediate {
+			return errors.Wrapf(ErrInvalidOptions, "buffered dequeue needs VisibleImmediate, not %v", D.Visibility)
+		}
+		return nil
+	default:
+		return errors.Wrapf(ErrInvalidOptions, "unknown delivery mode %d", D.DeliveryMode)
+	}
+}
+
+// DeqByPriority returns a DeqOptions.Condition matching the messages of priority maxPriority or higher
+// (a lower number is a higher priority), so the lower priority messages are left in the queue:
+//
+//	D.Condition = DeqByPriority(2) // "tab.priority <= 2"
+func DeqByPriority(maxPriority int32) string {
+	return "tab.priority <= " + strconv.FormatInt(int64(maxPriority), 10)
+}
+
+func (D *DeqOptions) fromOra(d *drv, opts *C.dpiDeqOptions) error {
 	var firstErr error
 	OK := func(ok C.int, msg string) bool {
 		if ok == C.DPI_SUCCESS {
@@ -438,6 +4224,78 @@ func (D DeqOptions) fromOra(d *drv, opts *C.dpiDeqOptions) error {
 	return firstErr
 }
 
+// BrowseAllOptions returns the DeqOptions for browsing the queue non-destructively from its start,
+// without waiting.
+//
+// As NavFirst restarts the browse, dequeue all the messages in one call
+// or switch to NavNext after the first dequeue.
+func BrowseAllOptions() DeqOptions {
+	return DeqOptions{Mode: DeqBrowse, Navigation: NavFirst, Wait: NoWait}
+}
+
+// ConsumeBlocking returns the DeqOptions for removing the next message, waiting forever for one.
+func ConsumeBlocking() DeqOptions {
+	return DeqOptions{Mode: DeqRemove, Navigation: NavNext, Wait: WaitForever}
+}
+
+// toOra sets the options in opts.
+// If prev is not nil, it holds the options last set in opts, and only the differing ones are set again.
+func (D DeqOptions) toOra(d *drv, opts *C.dpiDeqOptions, prev *DeqOptions) error {
+	var firstErr error
+	OK := func(ok C.int, msg string) bool {
+		if ok == C.DPI_SUCCESS {
+			return true
+		}
+		if firstErr == nil {
+			firstErr = errors.WithMessage(d.getError(), msg)
+		}
+		return false
+	}
+	var P DeqOptions
+	if prev != nil {
+		P = *prev
+	}
+	setString := func(s, old string, set func(*C.char, C.uint) C.int, msg string) {
+		if prev != nil && s == old {
+			return
+		}
+		value := C.CString(s)
+		OK(set(value, C.uint(len(s))), msg)
+		C.free(unsafe.Pointer(value))
+	}
+
+	setString(D.Condition, P.Condition, func(v *C.char, n C.uint) C.int { return C.dpiDeqOptions_setCondition(opts, v, n) }, "setCondition")
+	setString(D.Consumer, P.Consumer, func(v *C.char, n C.uint) C.int { return C.dpiDeqOptions_setConsumerName(opts, v, n) }, "setConsumerName")
+	setString(D.Correlation, P.Correlation, func(v *C.char, n C.uint) C.int { return C.dpiDeqOptions_setCorrelation(opts, v, n) }, "setCorrelation")
+	setString(D.MsgID, P.MsgID, func(v *C.char, n C.uint) C.int { return C.dpiDeqOptions_setMsgId(opts, v, n) }, "setMsgId")
+	setString(D.Transformation, P.Transformation, func(v *C.char, n C.uint) C.int { return C.dpiDeqOptions_setTransformation(opts, v, n) }, "setTransformation")
+
+	// The zero Mode, Navigation and Visibility leave the current setting, so a prev zero means unknown.
+	if D.Mode != 0 && (prev == nil || D.Mode != P.Mode) {
+		OK(C.dpiDeqOptions_setMode(opts, C.dpiDeqMode(D.Mode)), "setMode")
+	}
+	if D.Navigation != 0 && (prev == nil || D.Navigation != P.Navigation) {
+		OK(C.dpiDeqOptions_setNavigation(opts, C.dpiDeqNavigation(D.Navigation)), "setNavigation")
+	}
+	if D.Visibility != 0 && (prev == nil || D.Visibility != P.Visibility) {
+		OK(C.dpiDeqOptions_setVisibility(opts, C.dpiVisibility(D.Visibility)), "setVisibility")
+	}
+	mode, prevMode := D.DeliveryMode, P.DeliveryMode
+	if mode == 0 {
+		mode = DeliverPersistent
+	}
+	if prevMode == 0 {
+		prevMode = DeliverPersistent
+	}
+	if prev == nil || mode != prevMode {
+		OK(C.dpiDeqOptions_setDeliveryMode(opts, C.dpiMessageDeliveryMode(mode)), "setDeliveryMode")
+	}
+	if prev == nil || D.Wait != P.Wait {
+		OK(C.dpiDeqOptions_setWait(opts, C.uint(D.Wait)), "setWait")
+	}
+	return firstErr
+}
+
 const (
 	NoWait      = uint32(0)
 	WaitForever = uint32(1<<31 - 1)
@@ -488,9 +4346,16 @@ const (
 	// DeqBrows reads the message without acquiring a lock on the message (equivalent to a SELECT statement).
 	DeqBrowse = DeqMode(C.DPI_MODE_DEQ_BROWSE)
 	// DeqLocked reads the message and obtain a write lock on the message (equivalent to a SELECT FOR UPDATE statement).
+	// Other dequeuers skip the locked message till the end of the transaction.
 	DeqLocked = DeqMode(C.DPI_MODE_DEQ_LOCKED)
-	// DeqPeek confirms receipt of the message but does not deliver the actual message content.
-	DeqPeek = DeqMode(C.DPI_MODE_DEQ_REMOVE_NO_DATA)
+	// DeqConfirm confirms the receipt of the message (removes it, just as DeqRemove), but does not deliver its payload.
+	// It is used to remove a message already read with DeqBrowse or DeqLocked, by its MsgID.
+	// For a non-destructive look at a message, use DeqBrowse.
+	DeqConfirm = DeqMode(C.DPI_MODE_DEQ_REMOVE_NO_DATA)
+	// DeqPeek is DeqConfirm: despite its name, it REMOVES the message.
+	//
+	// Deprecated: use DeqConfirm to remove the message without its payload, or DeqBrowse to keep it.
+	DeqPeek = DeqConfirm
 )
 
 // DeqNavigation constants for navigation.
@@ -504,3 +4369,70 @@ const (
 	// NavNext  	Retrieves the next available message that matches the search criteria. This is the default method.
 	NavNext = DeqNavigation(C.DPI_DEQ_NAV_NEXT_MSG)
 )
+
+var (
+	deqModeNames = map[DeqMode]string{
+		DeqRemove: "remove", DeqBrowse: "browse", DeqLocked: "locked", DeqConfirm: "confirm",
+	}
+	deqNavigationNames = map[DeqNavigation]string{
+		NavFirst: "first", NavNextTran: "next_transaction", NavNext: "next",
+	}
+	visibilityNames = map[Visibility]string{
+		VisibleImmediate: "immediate", VisibleOnCommit: "on_commit",
+	}
+)
+
+func (m DeqMode) String() string {
+	if s, ok := deqModeNames[m]; ok {
+		return s
+	}
+	return fmt.Sprintf("DeqMode(%d)", uint32(m))
+}
+
+// ParseDeqMode parses the (case insensitive) name of a DeqMode: remove, browse, locked or confirm
+// (or peek, the old name of confirm).
+func ParseDeqMode(s string) (DeqMode, error) {
+	if strings.EqualFold(s, "peek") {
+		return DeqConfirm, nil
+	}
+	for m, nm := range deqModeNames {
+		if strings.EqualFold(s, nm) {
+			return m, nil
+		}
+	}
+	return 0, errors.Errorf("unknown DeqMode %q", s)
+}
+
+func (n DeqNavigation) String() string {
+	if s, ok := deqNavigationNames[n]; ok {
+		return s
+	}
+	return fmt.Sprintf("DeqNavigation(%d)", uint32(n))
+}
+
+// ParseDeqNavigation parses the (case insensitive) name of a DeqNavigation: first, next or next_transaction.
+func ParseDeqNavigation(s string) (DeqNavigation, error) {
+	for n, nm := range deqNavigationNames {
+		if strings.EqualFold(s, nm) {
+			return n, nil
+		}
+	}
+	return 0, errors.Errorf("unknown DeqNavigation %q", s)
+}
+
+func (v Visibility) String() string {
+	if s, ok := visibilityNames[v]; ok {
+		return s
+	}
+	return fmt.Sprintf("Visibility(%d)", uint32(v))
+}
+
+// ParseVisibility parses the (case insensitive) name of a Visibility: immediate or on_commit.
+func ParseVisibility(s string) (Visibility, error) {
+	for v, nm := range visibilityNames {
+		if strings.EqualFold(s, nm) {
+			return v, nil
+		}
+	}
+	return 0, errors.Errorf("unknown Visibility %q", s)
+}
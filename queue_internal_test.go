@@ -0,0 +1,270 @@
+// Copyright 2019 Tamás Gulácsi
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package goracle
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+func TestDelayUntil(t *testing.T) {
+	ny, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skip(err)
+	}
+	for name, tc := range map[string]struct {
+		Now, At time.Time
+		Want    int32
+	}{
+		// 2019-03-10 02:00 EST jumps to 03:00 EDT: one hour passes between 01:30 and 03:30.
+		"spring": {Now: time.Date(2019, 3, 10, 1, 30, 0, 0, ny), At: time.Date(2019, 3, 10, 3, 30, 0, 0, ny), Want: 3600},
+		// 2019-11-03 02:00 EDT falls back to 01:00 EST: three hours pass between 00:30 and 02:30.
+		"fall":    {Now: time.Date(2019, 11, 3, 0, 30, 0, 0, ny), At: time.Date(2019, 11, 3, 2, 30, 0, 0, ny), Want: 3 * 3600},
+		"roundUp": {Now: time.Date(2019, 1, 1, 0, 0, 0, 0, ny), At: time.Date(2019, 1, 1, 0, 0, 1, 1, ny), Want: 2},
+		"past":    {Now: time.Date(2019, 1, 1, 0, 0, 0, 0, ny), At: time.Date(2018, 12, 31, 0, 0, 0, 0, time.UTC), Want: 0},
+	} {
+		if got := delayUntil(tc.At, tc.Now); got != tc.Want {
+			t.Errorf("%s: got %d, wanted %d", name, got, tc.Want)
+		}
+	}
+}
+
+func TestHeadersEnvelope(t *testing.T) {
+	headers := map[string]string{"trace-id": "abc123", "": "empty key", "b": ""}
+	body := []byte("payload")
+	p := append(appendHeaders(nil, headers), body...)
+	if len(p) != headersSize(headers)+len(body) {
+		t.Errorf("size: got %d, wanted %d", len(p), headersSize(headers)+len(body))
+	}
+	gotHeaders, gotBody := parseHeaders(p)
+	if !reflect.DeepEqual(gotHeaders, headers) || !bytes.Equal(gotBody, body) {
+		t.Errorf("got %q, %q; wanted %q, %q", gotHeaders, gotBody, headers, body)
+	}
+
+	for _, p := range [][]byte{
+		[]byte("plain payload"),
+		[]byte("GOQH1"),
+		append([]byte("GOQH1\x02\x01k"), body...),
+	} {
+		if h, b := parseHeaders(p); h != nil || !bytes.Equal(b, p) {
+			t.Errorf("%q: got %q, %q; wanted no headers", p, h, b)
+		}
+	}
+}
+
+func TestVersionEnvelope(t *testing.T) {
+	for _, tc := range []struct {
+		Version byte
+		Body    []byte
+	}{
+		{0, nil},
+		{1, []byte("v1 payload")},
+		{2, []byte{0, 1, 2}},
+		{255, []byte("GOQH1 not headers")},
+	} {
+		p := WriteEnvelope(tc.Version, tc.Body)
+		if len(p) != 1+len(tc.Body) {
+			t.Errorf("%d: got %d bytes, wanted %d", tc.Version, len(p), 1+len(tc.Body))
+		}
+		version, body, err := ReadEnvelope(p)
+		if err != nil {
+			t.Fatalf("%d: %+v", tc.Version, err)
+		}
+		if version != tc.Version || !bytes.Equal(body, tc.Body) {
+			t.Errorf("got %d, %q; wanted %d, %q", version, body, tc.Version, tc.Body)
+		}
+	}
+
+	for _, p := range [][]byte{nil, {}} {
+		if _, _, err := ReadEnvelope(p); errors.Cause(err) != ErrShortEnvelope {
+			t.Errorf("%q: got %v, wanted ErrShortEnvelope", p, err)
+		}
+	}
+}
+
+func TestDecompressLimit(t *testing.T) {
+	frame := func(n int) []byte {
+		var b bytes.Buffer
+		b.Write(compressMagic)
+		zw := gzip.NewWriter(&b)
+		zw.Write(make([]byte, n))
+		zw.Close()
+		return b.Bytes()
+	}
+	if p := frame(MaxDecompressedSize); len(decompress(p)) != MaxDecompressedSize {
+		t.Errorf("%d bytes: not decompressed", MaxDecompressedSize)
+	}
+	if p := frame(MaxDecompressedSize + 1); !bytes.Equal(decompress(p), p) {
+		t.Errorf("%d bytes: decompressed, wanted the frame", MaxDecompressedSize+1)
+	}
+}
+
+func TestDescribeMessagesRedact(t *testing.T) {
+	const secret = "SECRET-PII"
+	msgs := []Message{{Raw: []byte(secret)}, {Raw: []byte("x" + secret)}}
+	copy(msgs[1].MsgID[:], "0123456789abcdef")
+	if got := describeMessages(msgs, false); !strings.Contains(got, secret) {
+		t.Errorf("not redacted: %q does not contain the payload", got)
+	}
+	got := describeMessages(msgs, true)
+	t.Log(got)
+	if strings.Contains(got, secret) {
+		t.Errorf("redacted: %q contains the payload", got)
+	}
+	for _, want := range []string{"2 messages", "[10 11]", fmt.Sprintf("%x", msgs[1].MsgID)} {
+		if !strings.Contains(got, want) {
+			t.Errorf("redacted: %q does not contain %q", got, want)
+		}
+	}
+}
+
+func TestQueueReset(t *testing.T) {
+	P := ConnectionParams{
+		Username: os.Getenv("GORACLE_DRV_TEST_USERNAME"),
+		Password: os.Getenv("GORACLE_DRV_TEST_PASSWORD"),
+		SID:      os.Getenv("GORACLE_DRV_TEST_DB"),
+	}
+	if P.Username == "" {
+		t.Skip("GORACLE_DRV_TEST_USERNAME is not set")
+	}
+	db, err := sql.Open("goracle", P.StringWithPassword())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		t.Skip(err)
+	}
+	defer conn.Close()
+
+	const qName = "TEST_QRESET"
+	const qry = `DECLARE
+		tbl CONSTANT VARCHAR2(61) := USER||'.' || :1 || '_TBL';
+		q CONSTANT VARCHAR2(61) := USER||'.' || :1;
+	BEGIN
+		BEGIN DBMS_AQADM.stop_queue(q); EXCEPTION WHEN OTHERS THEN NULL; END;
+		BEGIN DBMS_AQADM.drop_queue(q); EXCEPTION WHEN OTHERS THEN NULL; END;
+		BEGIN DBMS_AQADM.drop_queue_table(tbl, TRUE); EXCEPTION WHEN OTHERS THEN NULL; END;
+		IF :2 = 1 THEN
+			DBMS_AQADM.create_queue_table(queue_table=>tbl, queue_payload_type=>'RAW');
+			DBMS_AQADM.create_queue(queue_name=>q, queue_table=>tbl);
+			DBMS_AQADM.start_queue(q);
+		END IF;
+	END;`
+	if _, err = conn.ExecContext(ctx, qry, qName, 1); err != nil {
+		t.Fatal(err)
+	}
+	defer db.Exec(qry, qName, 0)
+
+	Q, err := NewQueue(ctx, conn, qName, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer Q.Close()
+	if err = Q.SetEnqOptions(EnqOptions{Visibility: VisibleImmediate}); err != nil {
+		t.Fatal(err)
+	}
+	deqOpts := DeqOptions{Mode: DeqRemove, Navigation: NavFirst, Visibility: VisibleImmediate, Wait: NoWait}
+	if err = Q.SetDeqOptions(deqOpts); err != nil {
+		t.Fatal(err)
+	}
+	if err = Q.Enqueue([]Message{{Raw: []byte("survivor")}}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Break the handle.
+	broken := Q.dpiQueue
+	Q.dpiQueue = nil
+	defer (&Queue{conn: Q.conn, dpiQueue: broken}).Close()
+	msgs := make([]Message, 1)
+	if _, err = Q.Dequeue(msgs); err == nil {
+		t.Fatal("dequeue with a broken handle succeeded")
+	}
+	t.Log("broken:", err)
+
+	if err = Q.Reset(); err != nil {
+		t.Fatal(err)
+	}
+	if err = Q.SetDeqOptions(deqOpts); err != nil {
+		t.Fatal(err)
+	}
+	n, err := Q.Dequeue(msgs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 || string(msgs[0].Raw) != "survivor" {
+		t.Errorf("got %d messages (%q), wanted survivor", n, msgs[0].Raw)
+	}
+}
+
+func TestDequeuedCount(t *testing.T) {
+	for _, tc := range []struct {
+		num  uint64
+		n    int
+		want int
+	}{
+		{0, 0, 0},
+		{0, 10, 0},
+		{3, 10, 3},
+		{10, 10, 10},
+		{11, 10, 10},
+		{1 << 40, 10, 10},
+		{1, 0, 0},
+	} {
+		if got := dequeuedCount(tc.num, tc.n); got != tc.want {
+			t.Errorf("dequeuedCount(%d, %d)=%d, wanted %d", tc.num, tc.n, got, tc.want)
+		}
+	}
+}
+
+func TestDedupWindow(t *testing.T) {
+	id := func(i byte) [MsgIDLength]byte { return [MsgIDLength]byte{i} }
+	var calls []byte
+	fail := map[byte]bool{4: true}
+	handler := DedupWindow(2)(func(m Message) error {
+		calls = append(calls, m.MsgID[0])
+		if fail[m.MsgID[0]] {
+			delete(fail, m.MsgID[0])
+			return errors.New("fail once")
+		}
+		return nil
+	})
+	for _, i := range []byte{
+		1, 1, // duplicate: handled once
+		2, 1, // 1 is still in the window
+		3, 1, // 1 is evicted by 3, so handled again
+		4, 4, // the failed 4 is handled again
+		4,
+	} {
+		handler(Message{MsgID: id(i)})
+	}
+	if got, want := fmt.Sprint(calls), fmt.Sprint([]byte{1, 2, 3, 1, 4, 4}); got != want {
+		t.Errorf("handler got %s, wanted %s", got, want)
+	}
+}
@@ -16,8 +16,17 @@
 package goracle_test
 
 import (
+	"bytes"
 	"context"
+	"crypto/sha256"
+	"database/sql"
+	"fmt"
+	"io"
+	"math/rand"
+	"reflect"
+	"sort"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -228,3 +237,4749 @@ func TestQueueObject(t *testing.T) {
 		t.Logf("got: %#v (%q)", m, string(m.Raw))
 	}
 }
+
+// createQueue (re)creates the qName queue, with the payloadType (RAW if empty),
+// the extra tblArgs for DBMS_AQADM.create_queue_table and qArgs for DBMS_AQADM.create_queue
+// (both must start with a comma if not empty).
+//
+// Returns the function which drops the queue.
+func createQueue(ctx context.Context, t testing.TB, conn *sql.Conn, qName, payloadType, tblArgs, qArgs string) func() {
+	t.Helper()
+	if payloadType == "" {
+		payloadType = "RAW"
+	} else {
+		payloadType = "'||USER||'." + payloadType
+	}
+	qry := `DECLARE
+		tbl CONSTANT VARCHAR2(61) := USER||'.` + qName + `_TBL';
+		q CONSTANT VARCHAR2(61) := USER||'.` + qName + `';
+		typ CONSTANT VARCHAR2(61) := '` + payloadType + `';
+	BEGIN
+		BEGIN DBMS_AQADM.stop_queue(q); EXCEPTION WHEN OTHERS THEN NULL; END;
+		BEGIN DBMS_AQADM.drop_queue(q); EXCEPTION WHEN OTHERS THEN NULL; END;
+		BEGIN DBMS_AQADM.drop_queue_table(tbl, TRUE); EXCEPTION WHEN OTHERS THEN NULL; END;
+
+		DBMS_AQADM.create_queue_table(queue_table=>tbl, queue_payload_type=>typ` + tblArgs + `);
+		DBMS_AQADM.create_queue(queue_name=>q, queue_table=>tbl` + qArgs + `);
+		DBMS_AQADM.grant_queue_privilege('ENQUEUE', q, USER);
+		DBMS_AQADM.grant_queue_privilege('DEQUEUE', q, USER);
+		DBMS_AQADM.start_queue(q);
+	END;`
+	if _, err := conn.ExecContext(ctx, qry); err != nil {
+		t.Fatal(errors.Wrap(err, qry))
+	}
+	return func() {
+		conn.ExecContext(
+			context.Background(),
+			`DECLARE
+			tbl CONSTANT VARCHAR2(61) := USER||'.'||:1;
+			q CONSTANT VARCHAR2(61) := USER||'.'||:2;
+		BEGIN
+			BEGIN DBMS_AQADM.stop_queue(q); EXCEPTION WHEN OTHERS THEN NULL; END;
+			BEGIN DBMS_AQADM.drop_queue(q); EXCEPTION WHEN OTHERS THEN NULL; END;
+			BEGIN DBMS_AQADM.drop_queue_table(tbl, TRUE); EXCEPTION WHEN OTHERS THEN NULL; END;
+		END;`,
+			qName+"_TBL", qName,
+		)
+	}
+}
+
+// addSubscribers adds the named subscribers to the multi-consumer queue.
+func addSubscribers(ctx context.Context, t testing.TB, conn *sql.Conn, qName string, names ...string) {
+	t.Helper()
+	const qry = `BEGIN DBMS_AQADM.add_subscriber(USER||'.'||:1, SYS.AQ$_AGENT(:2, NULL, NULL)); END;`
+	for _, nm := range names {
+		if _, err := conn.ExecContext(ctx, qry, qName, nm); err != nil {
+			t.Fatal(errors.Wrap(err, nm))
+		}
+	}
+}
+
+func TestQueueMultiConsumerRemove(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	conn, err := testDb.Conn(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	const qName = "TEST_QMC"
+	defer createQueue(ctx, t, conn, qName, "", ", multiple_consumers=>TRUE", "")()
+	addSubscribers(ctx, t, conn, qName, "SUB_A", "SUB_B")
+
+	q, err := goracle.NewQueue(ctx, conn, qName, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+
+	if err = q.SetEnqOptions(goracle.EnqOptions{Visibility: goracle.VisibleImmediate}); err != nil {
+		t.Fatal(err)
+	}
+	if err = q.Enqueue([]goracle.Message{{Raw: []byte("fan-out")}}); err != nil {
+		t.Fatal("enqueue:", err)
+	}
+
+	deq := func(consumer string) int {
+		t.Helper()
+		if err := q.SetDeqOptions(goracle.DeqOptions{
+			Consumer:   consumer,
+			Mode:       goracle.DeqRemove,
+			Navigation: goracle.NavFirst,
+			Visibility: goracle.VisibleImmediate,
+			Wait:       goracle.NoWait,
+		}); err != nil {
+			t.Fatal(err)
+		}
+		msgs := make([]goracle.Message, 1)
+		n, err := q.Dequeue(msgs)
+		if err != nil {
+			t.Fatal(consumer, err)
+		}
+		return n
+	}
+	if n := deq("SUB_A"); n != 1 {
+		t.Fatalf("SUB_A got %d messages, wanted 1", n)
+	}
+	if n := deq("SUB_A"); n != 0 {
+		t.Errorf("SUB_A got %d messages after remove, wanted 0", n)
+	}
+	if n := deq("SUB_B"); n != 1 {
+		t.Errorf("SUB_B got %d messages, wanted 1 (SUB_A's remove must not affect it)", n)
+	}
+}
+
+func TestQueueEnqueueBroadcast(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
+	conn, err := testDb.Conn(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	queues := make([]*goracle.Queue, 3)
+	for i := range queues {
+		qName := fmt.Sprintf("TEST_QBC_%d", i)
+		defer createQueue(ctx, t, conn, qName, "", "", "")()
+		if queues[i], err = goracle.NewQueue(ctx, conn, qName, ""); err != nil {
+			t.Fatal(err)
+		}
+		defer queues[i].Close()
+		if err = queues[i].SetEnqOptions(goracle.EnqOptions{Visibility: goracle.VisibleImmediate}); err != nil {
+			t.Fatal(err)
+		}
+		if err = queues[i].SetDeqOptions(goracle.DeqOptions{
+			Mode: goracle.DeqRemove, Navigation: goracle.NavFirst,
+			Visibility: goracle.VisibleImmediate, Wait: goracle.NoWait,
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	const payload = "broadcast"
+	if err = goracle.EnqueueBroadcast(goracle.Message{Raw: []byte(payload)}, queues...); err != nil {
+		t.Fatal(err)
+	}
+	for _, q := range queues {
+		msgs := make([]goracle.Message, 1)
+		n, err := q.Dequeue(msgs)
+		if err != nil {
+			t.Fatal(q.Name(), err)
+		}
+		if n != 1 {
+			t.Errorf("%s: got %d messages, wanted 1", q.Name(), n)
+		} else if got := string(msgs[0].Raw); got != payload {
+			t.Errorf("%s: got %q, wanted %q", q.Name(), got, payload)
+		}
+	}
+}
+
+func TestQueueStats(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	conn, err := testDb.Conn(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	const qName = "TEST_QSTATS"
+	defer createQueue(ctx, t, conn, qName, "", "", "")()
+	q, err := goracle.NewQueue(ctx, conn, qName, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+
+	if err = q.SetEnqOptions(goracle.EnqOptions{Visibility: goracle.VisibleImmediate}); err != nil {
+		t.Fatal(err)
+	}
+	if err = q.SetDeqOptions(goracle.DeqOptions{
+		Correlation: "stats", Mode: goracle.DeqBrowse, Navigation: goracle.NavFirst,
+		Visibility: goracle.VisibleImmediate, Wait: 1,
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err = q.Enqueue([]goracle.Message{{Raw: []byte("a")}, {Raw: []byte("b")}}); err != nil {
+		t.Fatal(err)
+	}
+
+	stats, err := q.Stats(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Logf("stats: %+v", stats)
+	if stats.Name != qName {
+		t.Errorf("name: got %q, wanted %q", stats.Name, qName)
+	}
+	if stats.PayloadType != "RAW" {
+		t.Errorf("payload type: got %q, wanted RAW", stats.PayloadType)
+	}
+	if stats.EnqOptions.Visibility != goracle.VisibleImmediate {
+		t.Errorf("enq visibility: got %v, wanted %v", stats.EnqOptions.Visibility, goracle.VisibleImmediate)
+	}
+	if D := stats.DeqOptions; D.Correlation != "stats" || D.Mode != goracle.DeqBrowse || D.Navigation != goracle.NavFirst || D.Wait != 1 {
+		t.Errorf("deq options: got %+v", D)
+	}
+	if stats.Counts.Ready != 2 {
+		t.Errorf("ready: got %d, wanted 2", stats.Counts.Ready)
+	}
+}
+
+func TestQueueDequeueLockedConcurrent(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
+	conn, err := testDb.Conn(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	const qName = "TEST_QLOCKED"
+	const workers = 4
+	defer createQueue(ctx, t, conn, qName, "", "", "")()
+	{
+		q, err := goracle.NewQueue(ctx, conn, qName, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = q.SetEnqOptions(goracle.EnqOptions{Visibility: goracle.VisibleImmediate}); err != nil {
+			t.Fatal(err)
+		}
+		msgs := make([]goracle.Message, workers)
+		for i := range msgs {
+			msgs[i].Raw = []byte(fmt.Sprintf("locked-%d", i))
+		}
+		if err = q.Enqueue(msgs); err != nil {
+			t.Fatal(err)
+		}
+		q.Close()
+	}
+
+	// Each worker locks one message and holds the lock till every worker dequeued.
+	var dequeued, release sync.WaitGroup
+	dequeued.Add(workers)
+	release.Add(1)
+	ids := make([][16]byte, workers)
+	errs := make([]error, workers)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			once := sync.Once{}
+			done := func() { once.Do(dequeued.Done) }
+			defer done()
+			conn, err := testDb.Conn(ctx)
+			if err != nil {
+				errs[i] = err
+				return
+			}
+			defer conn.Close()
+			defer conn.ExecContext(context.Background(), "ROLLBACK")
+			q, err := goracle.NewQueue(ctx, conn, qName, "")
+			if err != nil {
+				errs[i] = err
+				return
+			}
+			defer q.Close()
+			msgs := make([]goracle.Message, 1)
+			n, err := q.DequeueWith(msgs, goracle.DeqOptions{
+				Mode: goracle.DeqLocked, Navigation: goracle.NavFirst,
+				Visibility: goracle.VisibleOnCommit, Wait: 5,
+			})
+			if err != nil {
+				errs[i] = err
+				return
+			}
+			if n != 1 {
+				errs[i] = errors.Errorf("%d. got %d messages, wanted 1", i, n)
+				return
+			}
+			ids[i] = msgs[0].MsgID
+			done()
+			release.Wait()
+		}(i)
+	}
+	dequeued.Wait()
+	release.Done()
+	wg.Wait()
+
+	seen := make(map[[16]byte]int, workers)
+	for i, err := range errs {
+		if err != nil {
+			t.Fatalf("%d. %+v", i, err)
+		}
+		if j, ok := seen[ids[i]]; ok {
+			t.Errorf("worker %d and %d locked the same message %x", j, i, ids[i])
+		}
+		seen[ids[i]] = i
+	}
+}
+
+func TestQueueRollback(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	conn, err := testDb.Conn(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	const qName = "TEST_QTRAN"
+	defer createQueue(ctx, t, conn, qName, "", "", "")()
+	q, err := goracle.NewQueue(ctx, conn, qName, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+
+	if err = q.SetEnqOptions(goracle.EnqOptions{Visibility: goracle.VisibleOnCommit}); err != nil {
+		t.Fatal(err)
+	}
+	if err = q.Enqueue([]goracle.Message{{Raw: []byte("redeliver")}}); err != nil {
+		t.Fatal(err)
+	}
+	if err = q.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	deqOpts := goracle.DeqOptions{
+		Mode: goracle.DeqRemove, Navigation: goracle.NavFirst,
+		Visibility: goracle.VisibleOnCommit, Wait: goracle.NoWait,
+	}
+	msgs := make([]goracle.Message, 1)
+	n, err := q.DequeueWith(msgs, deqOpts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Fatalf("got %d messages, wanted 1", n)
+	}
+	first := msgs[0].MsgID
+	if err = q.Rollback(); err != nil {
+		t.Fatal(err)
+	}
+
+	if n, err = q.DequeueWith(msgs, deqOpts); err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Fatalf("got %d messages after rollback, wanted 1", n)
+	}
+	if msgs[0].MsgID != first {
+		t.Errorf("got %x after rollback, wanted %x", msgs[0].MsgID, first)
+	}
+	if err = q.Commit(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestQueueDequeuePoison(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	conn, err := testDb.Conn(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	const qName = "TEST_QPOISON"
+	defer createQueue(ctx, t, conn, qName, "", "", ", max_retries=>10")()
+	q, err := goracle.NewQueue(ctx, conn, qName, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+
+	if err = q.SetEnqOptions(goracle.EnqOptions{Visibility: goracle.VisibleImmediate}); err != nil {
+		t.Fatal(err)
+	}
+	if err = q.Enqueue([]goracle.Message{{Raw: []byte("poison")}}); err != nil {
+		t.Fatal(err)
+	}
+	if err = q.SetDeqOptions(goracle.DeqOptions{
+		Mode: goracle.DeqRemove, Navigation: goracle.NavFirst,
+		Visibility: goracle.VisibleOnCommit, Wait: goracle.NoWait,
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	const maxAttempts = 2
+	var msg goracle.Message
+	for i := 0; ; i++ {
+		poison, err := q.DequeuePoison(maxAttempts, &msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Logf("%d. attempts=%d poison=%t", i, msg.NumAttempts, poison)
+		if err = q.Rollback(); err != nil {
+			t.Fatal(err)
+		}
+		if poison {
+			if msg.NumAttempts <= maxAttempts {
+				t.Errorf("flagged with %d attempts", msg.NumAttempts)
+			}
+			break
+		}
+		if i > maxAttempts {
+			t.Fatalf("not flagged after %d attempts", msg.NumAttempts)
+		}
+	}
+}
+
+func TestQueueEnqueuedLocation(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	conn, err := testDb.Conn(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	const qName = "TEST_QENQTZ"
+	defer createQueue(ctx, t, conn, qName, "", "", "")()
+	q, err := goracle.NewQueue(ctx, conn, qName, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+
+	if err = q.SetEnqOptions(goracle.EnqOptions{Visibility: goracle.VisibleImmediate}); err != nil {
+		t.Fatal(err)
+	}
+	deq := goracle.DeqOptions{
+		Mode: goracle.DeqRemove, Navigation: goracle.NavFirst,
+		Visibility: goracle.VisibleImmediate, Wait: goracle.NoWait,
+	}
+	// The server's clock, as the enqueue time is.
+	sysTime := func() time.Time {
+		t.Helper()
+		var now time.Time
+		if err := conn.QueryRowContext(ctx, "SELECT SYSTIMESTAMP FROM DUAL").Scan(&now); err != nil {
+			t.Fatal(err)
+		}
+		return now
+	}
+	browse := func(loc *time.Location) time.Time {
+		t.Helper()
+		q.SetEnqueuedLocation(loc)
+		msgs := make([]goracle.Message, 1)
+		D := deq
+		D.Mode = goracle.DeqBrowse
+		n, err := q.DequeueWith(msgs, D)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != 1 {
+			t.Fatalf("got %d messages, wanted 1", n)
+		}
+		return msgs[0].Enqueued
+	}
+
+	plus5 := time.FixedZone("+05:00", 5*3600)
+	defer conn.ExecContext(context.Background(), "ALTER SESSION SET TIME_ZONE = LOCAL")
+	// The session time zone must not change the enqueue time, which is the server's clock.
+	for _, sessionTZ := range []string{"+05:00", "America/New_York"} {
+		if _, err = conn.ExecContext(ctx, "ALTER SESSION SET TIME_ZONE = '"+sessionTZ+"'"); err != nil {
+			t.Fatalf("%s: %+v", sessionTZ, err)
+		}
+		// The enqueue time has no fraction of seconds.
+		before := sysTime().Truncate(time.Second)
+		if err = q.Enqueue([]goracle.Message{{Raw: []byte(sessionTZ)}}); err != nil {
+			t.Fatal(err)
+		}
+		after := sysTime()
+
+		def := browse(nil)
+		if def.Before(before) || def.After(after) {
+			t.Errorf("%s: default Enqueued %v is not between %v and %v", sessionTZ, def, before, after)
+		}
+		for _, loc := range []*time.Location{time.UTC, plus5} {
+			got := browse(loc)
+			if got.Location() != loc {
+				t.Errorf("%s: got location %v, wanted %v", sessionTZ, got.Location(), loc)
+			}
+			if got.Format("2006-01-02 15:04:05") != def.Format("2006-01-02 15:04:05") {
+				t.Errorf("%s: %v: wall clock %v differs from the default %v", sessionTZ, loc, got, def)
+			}
+		}
+		if d := browse(time.UTC).Sub(browse(plus5)); d != 5*time.Hour {
+			t.Errorf("%s: UTC - +05:00 = %v, wanted 5h", sessionTZ, d)
+		}
+
+		// A named location follows DST, a fixed offset taken on the other side of a DST change does not.
+		if ny, err := time.LoadLocation("America/New_York"); err != nil {
+			t.Log(err)
+		} else {
+			got := browse(ny)
+			_, off := got.Zone()
+			_, otherOff := got.AddDate(0, 6, 0).Zone()
+			if off == otherOff {
+				t.Errorf("%s: %v and half a year later have the same offset %d", sessionTZ, got, off)
+			}
+			fixed := browse(time.FixedZone("other side", otherOff))
+			if d := fixed.Sub(got); d != time.Duration(off-otherOff)*time.Second {
+				t.Errorf("%s: fixed offset %d - %v = %v, wanted %ds", sessionTZ, otherOff, ny, d, off-otherOff)
+			}
+		}
+
+		msgs := make([]goracle.Message, 1)
+		if _, err = q.DequeueWith(msgs, deq); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestQueueBrowseAll(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	conn, err := testDb.Conn(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	const qName = "TEST_QBROWSE"
+	defer createQueue(ctx, t, conn, qName, "", "", "")()
+	q, err := goracle.NewQueue(ctx, conn, qName, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+
+	if err = q.SetEnqOptions(goracle.EnqOptions{Visibility: goracle.VisibleImmediate}); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"a", "b", "c"}
+	enq := make([]goracle.Message, len(want))
+	for i, s := range want {
+		enq[i].Raw = []byte(s)
+	}
+	if err = q.Enqueue(enq); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		msgs := make([]goracle.Message, len(want)+2)
+		n, err := q.DequeueWith(msgs, goracle.BrowseAllOptions())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != len(want) {
+			t.Fatalf("%d. got %d messages, wanted %d", i, n, len(want))
+		}
+		for j, m := range msgs[:n] {
+			if got := string(m.Raw); got != want[j] {
+				t.Errorf("%d. %d: got %q, wanted %q", i, j, got, want[j])
+			}
+		}
+	}
+}
+
+func TestQueuePooledEnqueue(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
+	conn, err := testDb.Conn(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	const qName = "TEST_QPOOLED"
+	defer createQueue(ctx, t, conn, qName, "", "", "")()
+
+	const goroutines, perG = 8, 5
+	pq := goracle.NewPooledQueue(testDb, qName)
+	var wg sync.WaitGroup
+	errs := make(chan error, goroutines)
+	for i := 0; i < goroutines; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			msgs := make([]goracle.Message, perG)
+			for j := range msgs {
+				msgs[j].Raw = []byte(fmt.Sprintf("%d-%d", i, j))
+			}
+			if err := pq.Enqueue(ctx, msgs); err != nil {
+				errs <- err
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+
+	q, err := goracle.NewQueue(ctx, conn, qName, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+	counts, err := q.Counts(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if counts.Ready != goroutines*perG {
+		t.Errorf("got %d ready messages, wanted %d", counts.Ready, goroutines*perG)
+	}
+}
+
+func TestQueueConsumeBackpressure(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
+	conn, err := testDb.Conn(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	const qName = "TEST_QCONSUME"
+	defer createQueue(ctx, t, conn, qName, "", "", "")()
+	q, err := goracle.NewQueue(ctx, conn, qName, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+
+	const all, bufSize = 10, 2
+	if err = q.SetEnqOptions(goracle.EnqOptions{Visibility: goracle.VisibleImmediate}); err != nil {
+		t.Fatal(err)
+	}
+	enq := make([]goracle.Message, all)
+	for i := range enq {
+		enq[i].Raw = []byte(fmt.Sprintf("%02d", i))
+	}
+	if err = q.Enqueue(enq); err != nil {
+		t.Fatal(err)
+	}
+	opts := goracle.ConsumeBlocking()
+	opts.Wait, opts.Visibility = 1, goracle.VisibleImmediate
+	if err = q.SetDeqOptions(opts); err != nil {
+		t.Fatal(err)
+	}
+
+	// A separate connection for counting the messages remaining in the queue.
+	cq, err := goracle.NewQueue(ctx, testDb, qName, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cq.Close()
+
+	c := q.Consume(ctx, bufSize)
+	defer c.Close()
+	// The slow reader does not read yet: the loop must pause with a full buffer,
+	// leaving the rest of the messages in the queue.
+	time.Sleep(2 * time.Second)
+	if n := len(c.Messages()); n != bufSize {
+		t.Errorf("buffered %d messages, wanted %d", n, bufSize)
+	}
+	counts, err := cq.Counts(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if counts.Ready != all-bufSize {
+		t.Errorf("%d messages remained in the queue, wanted %d", counts.Ready, all-bufSize)
+	}
+
+	got := make([]string, 0, all)
+	for m := range c.Messages() {
+		got = append(got, string(m.Raw))
+		if len(got) == all {
+			break
+		}
+	}
+	if err = c.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != all {
+		t.Errorf("got %d messages, wanted %d", len(got), all)
+	}
+}
+
+// createQueueType (re)creates the typName object type with the given attributes,
+// and returns the function which drops it.
+func createQueueType(ctx context.Context, t testing.TB, conn *sql.Conn, typName, attrs string) func() {
+	t.Helper()
+	conn.ExecContext(ctx, "DROP TYPE "+typName+" FORCE")
+	qry := "CREATE OR REPLACE TYPE " + typName + " IS OBJECT (" + attrs + ")"
+	if _, err := conn.ExecContext(ctx, qry); err != nil {
+		t.Fatal(errors.Wrap(err, qry))
+	}
+	return func() { testDb.Exec("DROP TYPE " + typName + " FORCE") }
+}
+
+func TestQueuePayloadMismatch(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	conn, err := testDb.Conn(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	const rawQName, objQName, typName, otherTypName = "TEST_QMIS_RAW", "TEST_QMIS_OBJ", "TEST_QMIS_TYP", "TEST_QMIS_TYP2"
+	defer createQueueType(ctx, t, conn, typName, "f_vc20 VARCHAR2(20)")()
+	defer createQueueType(ctx, t, conn, otherTypName, "f_vc20 VARCHAR2(20)")()
+	defer createQueue(ctx, t, conn, rawQName, "", "", "")()
+	defer createQueue(ctx, t, conn, objQName, typName, "", "")()
+
+	rawQ, err := goracle.NewQueue(ctx, conn, rawQName, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rawQ.Close()
+	objQ, err := goracle.NewQueue(ctx, conn, objQName, typName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer objQ.Close()
+
+	oTyp, err := goracle.GetObjectType(ctx, conn, typName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer oTyp.Close()
+	obj, err := oTyp.NewObject()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer obj.Close()
+	otherTyp, err := goracle.GetObjectType(ctx, conn, otherTypName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer otherTyp.Close()
+	otherObj, err := otherTyp.NewObject()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer otherObj.Close()
+
+	for name, tc := range map[string]struct {
+		Q   *goracle.Queue
+		Msg goracle.Message
+	}{
+		"objectToRaw":   {Q: rawQ, Msg: goracle.Message{Object: obj}},
+		"rawToObject":   {Q: objQ, Msg: goracle.Message{Raw: []byte("raw")}},
+		"otherToObject": {Q: objQ, Msg: goracle.Message{Object: otherObj}},
+	} {
+		err := tc.Q.Enqueue([]goracle.Message{tc.Msg})
+		t.Logf("%s: %v", name, err)
+		if errors.Cause(err) != goracle.ErrWrongPayloadType {
+			t.Errorf("%s: got %v, wanted ErrWrongPayloadType", name, err)
+		}
+	}
+	err = objQ.Enqueue([]goracle.Message{{Object: otherObj}})
+	if msg := err.Error(); !strings.Contains(msg, typName) || !strings.Contains(msg, otherTypName) {
+		t.Errorf("error %q should name both %s and %s", msg, typName, otherTypName)
+	}
+}
+
+func TestMessageBuilder(t *testing.T) {
+	got, err := goracle.NewMessage().
+		Raw([]byte("payload")).
+		Correlation("corr").
+		Priority(3).
+		Delay(5 * time.Second).
+		Expiration(time.Minute).
+		ExceptionQ("EXC_Q").
+		Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := goracle.Message{
+		Raw: []byte("payload"), Correlation: "corr", Priority: 3, PriorityValid: true,
+		Delay: 5, Expiration: 60, ExceptionQ: "EXC_Q",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %#v, wanted %#v", got, want)
+	}
+
+	if _, err = goracle.NewMessage().Raw([]byte("x")).Delay(-time.Second).Build(); err == nil {
+		t.Error("negative delay: wanted error")
+	}
+	if _, err = goracle.NewMessage().Correlation(strings.Repeat("x", 129)).Build(); err == nil {
+		t.Error("long correlation: wanted error")
+	}
+}
+
+func TestMessageRemainingDelay(t *testing.T) {
+	enq := time.Date(2019, 7, 1, 12, 0, 0, 0, time.UTC)
+	M := goracle.Message{Enqueued: enq, Delay: 60}
+	var prev time.Duration = 61 * time.Second
+	for _, elapsed := range []time.Duration{0, time.Second, 30 * time.Second, 59 * time.Second} {
+		got := M.RemainingDelay(enq.Add(elapsed))
+		if want := 60*time.Second - elapsed; got != want {
+			t.Errorf("after %s: got %s, wanted %s", elapsed, got, want)
+		}
+		if got >= prev {
+			t.Errorf("after %s: %s did not decrease from %s", elapsed, got, prev)
+		}
+		prev = got
+	}
+	for name, tc := range map[string]struct {
+		M   goracle.Message
+		Now time.Time
+	}{
+		"ready":       {M: M, Now: enq.Add(2 * time.Minute)},
+		"no delay":    {M: goracle.Message{Enqueued: enq}, Now: enq},
+		"no enqueued": {M: goracle.Message{Delay: 60}, Now: enq},
+	} {
+		if got := tc.M.RemainingDelay(tc.Now); got != 0 {
+			t.Errorf("%s: got %s, wanted 0", name, got)
+		}
+	}
+}
+
+func TestQueueEnumStrings(t *testing.T) {
+	for _, tc := range []struct {
+		Name  string
+		Value fmt.Stringer
+		Parse func(string) (fmt.Stringer, error)
+	}{
+		{"remove", goracle.DeqRemove, parseDeqMode},
+		{"browse", goracle.DeqBrowse, parseDeqMode},
+		{"locked", goracle.DeqLocked, parseDeqMode},
+		{"confirm", goracle.DeqConfirm, parseDeqMode},
+		{"first", goracle.NavFirst, parseDeqNavigation},
+		{"next", goracle.NavNext, parseDeqNavigation},
+		{"next_transaction", goracle.NavNextTran, parseDeqNavigation},
+		{"immediate", goracle.VisibleImmediate, parseVisibility},
+		{"on_commit", goracle.VisibleOnCommit, parseVisibility},
+	} {
+		if got := tc.Value.String(); got != tc.Name {
+			t.Errorf("%#v.String()=%q, wanted %q", tc.Value, got, tc.Name)
+		}
+		for _, s := range []string{tc.Name, strings.ToUpper(tc.Name)} {
+			got, err := tc.Parse(s)
+			if err != nil {
+				t.Errorf("parse %q: %+v", s, err)
+			} else if got != tc.Value {
+				t.Errorf("parse %q: got %v, wanted %v", s, got, tc.Value)
+			}
+		}
+	}
+
+	for _, s := range []string{"", "unknown", "remove ", "first_msg"} {
+		for name, parse := range map[string]func(string) (fmt.Stringer, error){
+			"DeqMode": parseDeqMode, "DeqNavigation": parseDeqNavigation, "Visibility": parseVisibility,
+		} {
+			if got, err := parse(s); err == nil {
+				t.Errorf("%s %q: got %v, wanted error", name, s, got)
+			}
+		}
+	}
+	if got, want := goracle.DeqMode(99).String(), "DeqMode(99)"; got != want {
+		t.Errorf("got %q, wanted %q", got, want)
+	}
+	// peek is the old name of confirm.
+	if got, err := goracle.ParseDeqMode("peek"); err != nil || got != goracle.DeqConfirm {
+		t.Errorf("parse peek: got %v, %+v; wanted DeqConfirm", got, err)
+	}
+}
+
+func parseDeqMode(s string) (fmt.Stringer, error)       { return goracle.ParseDeqMode(s) }
+func parseDeqNavigation(s string) (fmt.Stringer, error) { return goracle.ParseDeqNavigation(s) }
+func parseVisibility(s string) (fmt.Stringer, error)    { return goracle.ParseVisibility(s) }
+
+func TestQueueOptionsValidate(t *testing.T) {
+	for _, tc := range []struct {
+		Mode       goracle.DeliveryMode
+		Visibility goracle.Visibility
+		Enq, Deq   bool
+	}{
+		{0, 0, true, true},
+		{0, goracle.VisibleOnCommit, true, true},
+		{goracle.DeliverPersistent, 0, true, true},
+		{goracle.DeliverPersistent, goracle.VisibleOnCommit, true, true},
+		{goracle.DeliverPersistent, goracle.VisibleImmediate, true, true},
+		{goracle.DeliverBuffered, 0, false, false},
+		{goracle.DeliverBuffered, goracle.VisibleOnCommit, false, false},
+		{goracle.DeliverBuffered, goracle.VisibleImmediate, true, true},
+		{goracle.DeliverPersistentOrBuffered, 0, false, false},
+		{goracle.DeliverPersistentOrBuffered, goracle.VisibleOnCommit, false, false},
+		{goracle.DeliverPersistentOrBuffered, goracle.VisibleImmediate, false, true},
+		{99, goracle.VisibleImmediate, false, false},
+	} {
+		enqErr := goracle.EnqOptions{DeliveryMode: tc.Mode, Visibility: tc.Visibility}.Validate()
+		if (enqErr == nil) != tc.Enq {
+			t.Errorf("enqueue %d/%v: got %v, wanted valid=%t", tc.Mode, tc.Visibility, enqErr, tc.Enq)
+		} else if enqErr != nil && errors.Cause(enqErr) != goracle.ErrInvalidOptions {
+			t.Errorf("enqueue %d/%v: got %v, wanted ErrInvalidOptions", tc.Mode, tc.Visibility, enqErr)
+		}
+		deqErr := goracle.DeqOptions{DeliveryMode: tc.Mode, Visibility: tc.Visibility}.Validate()
+		if (deqErr == nil) != tc.Deq {
+			t.Errorf("dequeue %d/%v: got %v, wanted valid=%t", tc.Mode, tc.Visibility, deqErr, tc.Deq)
+		} else if deqErr != nil && errors.Cause(deqErr) != goracle.ErrInvalidOptions {
+			t.Errorf("dequeue %d/%v: got %v, wanted ErrInvalidOptions", tc.Mode, tc.Visibility, deqErr)
+		}
+	}
+}
+
+func TestMessagePayloadReaderWriter(t *testing.T) {
+	want := strings.Repeat("payload ", 1000)
+	var msg goracle.Message
+	if _, err := io.Copy(msg.PayloadWriter(), strings.NewReader(want)); err != nil {
+		t.Fatal(err)
+	}
+	if string(msg.Raw) != want {
+		t.Fatalf("written %d bytes, wanted %d", len(msg.Raw), len(want))
+	}
+
+	var buf bytes.Buffer
+	if _, err := io.Copy(&buf, msg.PayloadReader()); err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != want {
+		t.Errorf("read %d bytes, wanted %d", buf.Len(), len(want))
+	}
+}
+
+func TestQueueEnqueueRaw(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	conn, err := testDb.Conn(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	const qName = "TEST_QRAW"
+	defer createQueue(ctx, t, conn, qName, "", "", "")()
+	q, err := goracle.NewQueue(ctx, conn, qName, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+	if err = q.SetEnqOptions(goracle.EnqOptions{Visibility: goracle.VisibleImmediate}); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{"one", "two", "three"}
+	ids, err := q.EnqueueRaw([]byte(want[0]), []byte(want[1]), []byte(want[2]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ids) != len(want) {
+		t.Fatalf("got %d MsgIDs, wanted %d", len(ids), len(want))
+	}
+
+	msgs := make([]goracle.Message, len(want))
+	n, err := q.DequeueWith(msgs, goracle.DeqOptions{
+		Mode: goracle.DeqRemove, Navigation: goracle.NavFirst,
+		Visibility: goracle.VisibleImmediate, Wait: goracle.NoWait,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != len(want) {
+		t.Fatalf("got %d messages, wanted %d", n, len(want))
+	}
+	for i, m := range msgs[:n] {
+		if got := string(m.Raw); got != want[i] {
+			t.Errorf("%d. got %q, wanted %q", i, got, want[i])
+		}
+		if m.MsgID != ids[i] {
+			t.Errorf("%d. got MsgID %x, wanted %x", i, m.MsgID, ids[i])
+		}
+	}
+}
+
+func TestQueueDequeueRaw(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	conn, err := testDb.Conn(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	const qName = "TEST_QDEQRAW"
+	defer createQueue(ctx, t, conn, qName, "", "", "")()
+	q, err := goracle.NewQueue(ctx, conn, qName, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+	if err = q.SetEnqOptions(goracle.EnqOptions{Visibility: goracle.VisibleImmediate}); err != nil {
+		t.Fatal(err)
+	}
+	if err = q.SetDeqOptions(goracle.DeqOptions{
+		Mode: goracle.DeqRemove, Navigation: goracle.NavFirst,
+		Visibility: goracle.VisibleImmediate, Wait: goracle.NoWait,
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	want := [][]byte{[]byte("a"), []byte("bb"), []byte("ccc")}
+	if _, err = q.EnqueueRaw(want...); err != nil {
+		t.Fatal(err)
+	}
+	got, err := q.DequeueRaw(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, wanted %q", got, want)
+	}
+
+	if got, err = q.DequeueRaw(10); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 0 {
+		t.Errorf("got %q from an empty queue", got)
+	}
+}
+
+func TestQueueDequeueContextWaitCap(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	conn, err := testDb.Conn(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	const qName = "TEST_QWAITCAP"
+	defer createQueue(ctx, t, conn, qName, "", "", "")()
+	q, err := goracle.NewQueue(ctx, conn, qName, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+	if err = q.SetDeqOptions(goracle.DeqOptions{
+		Mode: goracle.DeqRemove, Navigation: goracle.NavFirst,
+		Visibility: goracle.VisibleImmediate, Wait: goracle.WaitForever,
+	}); err != nil {
+		t.Fatal(err)
+	}
+	const waitCap = time.Second
+	q.SetWaitCap(waitCap)
+
+	dCtx, dCancel := context.WithTimeout(ctx, 500*time.Millisecond)
+	defer dCancel()
+	start := time.Now()
+	n, err := q.DequeueContext(dCtx, make([]goracle.Message, 1))
+	dur := time.Since(start)
+	t.Logf("n=%d err=%v dur=%s", n, err, dur)
+	if err != context.DeadlineExceeded {
+		t.Errorf("got %v, wanted %v", err, context.DeadlineExceeded)
+	}
+	if dur > 2*waitCap+500*time.Millisecond {
+		t.Errorf("cancelled WaitForever dequeue returned after %s, wanted within the cap %s", dur, waitCap)
+	}
+
+	// The original wait is restored.
+	opts, err := q.DeqOptions()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opts.Wait != goracle.WaitForever {
+		t.Errorf("wait is %d after DequeueContext, wanted WaitForever", opts.Wait)
+	}
+}
+
+func TestQueueClone(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	conn, err := testDb.Conn(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	const qName = "TEST_QCLONE"
+	const perCorr = 5
+	defer createQueue(ctx, t, conn, qName, "", "", "")()
+	q, err := goracle.NewQueue(ctx, conn, qName, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+	if err = q.SetEnqOptions(goracle.EnqOptions{Visibility: goracle.VisibleImmediate}); err != nil {
+		t.Fatal(err)
+	}
+	corrs := []string{"A", "B"}
+	var msgs []goracle.Message
+	for i := 0; i < perCorr; i++ {
+		for _, corr := range corrs {
+			msgs = append(msgs, goracle.Message{Correlation: corr, Raw: []byte(corr)})
+		}
+	}
+	if err = q.Enqueue(msgs); err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	errs := make([]error, len(corrs))
+	for i, corr := range corrs {
+		clone, err := q.Clone()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer clone.Close()
+		if err = clone.SetDeqOptions(goracle.DeqOptions{
+			Mode: goracle.DeqRemove, Navigation: goracle.NavFirst,
+			Visibility: goracle.VisibleImmediate, Wait: goracle.NoWait,
+			Correlation: corr,
+		}); err != nil {
+			t.Fatal(err)
+		}
+		wg.Add(1)
+		go func(i int, corr string, clone *goracle.Queue) {
+			defer wg.Done()
+			var got int
+			msgs := make([]goracle.Message, 1)
+			for {
+				n, err := clone.Dequeue(msgs)
+				if err != nil {
+					errs[i] = err
+					return
+				}
+				if n == 0 {
+					break
+				}
+				if msgs[0].Correlation != corr || string(msgs[0].Raw) != corr {
+					errs[i] = errors.Errorf("%s: got %q/%q", corr, msgs[0].Correlation, msgs[0].Raw)
+					return
+				}
+				got++
+			}
+			if got != perCorr {
+				errs[i] = errors.Errorf("%s: got %d messages, wanted %d", corr, got, perCorr)
+			}
+		}(i, corr, clone)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			t.Error(err)
+		}
+	}
+}
+
+func TestQueueTable(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	conn, err := testDb.Conn(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	const qName = "TEST_QTABLE"
+	defer createQueue(ctx, t, conn, qName, "", "", "")()
+	q, err := goracle.NewQueue(ctx, conn, qName, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+	var user string
+	if err = conn.QueryRowContext(ctx, "SELECT USER FROM DUAL").Scan(&user); err != nil {
+		t.Fatal(err)
+	}
+	owner, table, err := q.QueueTable(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if owner != user || table != qName+"_TBL" {
+		t.Errorf("got %s.%s, wanted %s.%s", owner, table, user, qName+"_TBL")
+	}
+
+	noQ, err := goracle.NewQueue(ctx, conn, "TEST_QTABLE_NONEXISTENT", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer noQ.Close()
+	if _, _, err = noQ.QueueTable(ctx); errors.Cause(err) != goracle.ErrQueueNotFound {
+		t.Errorf("got %v, wanted ErrQueueNotFound", err)
+	}
+}
+
+func TestQueueErrorNamesQueue(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	conn, err := testDb.Conn(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	const qName = "TEST_QERR_NONEXISTENT"
+	q, err := goracle.NewQueue(ctx, conn, qName, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+	_, err = q.DequeueWith(make([]goracle.Message, 1), goracle.DeqOptions{
+		Mode: goracle.DeqRemove, Navigation: goracle.NavFirst,
+		Visibility: goracle.VisibleImmediate, Wait: goracle.NoWait,
+	})
+	if err == nil {
+		t.Fatal("dequeue from a nonexistent queue succeeded")
+	}
+	t.Log(err)
+	if !strings.Contains(err.Error(), qName) {
+		t.Errorf("error %q does not contain the queue name", err)
+	}
+	qe, ok := err.(*goracle.QueueError)
+	if !ok {
+		t.Fatalf("got %T, wanted *QueueError", err)
+	}
+	fields := qe.Fields()
+	t.Logf("fields: %v", fields)
+	if fields["queue"] != qName || fields["op"] != goracle.OpDequeue {
+		t.Errorf("got %v, wanted queue=%s op=%s", fields, qName, goracle.OpDequeue)
+	}
+	if _, ok := fields["code"]; !ok {
+		t.Errorf("no ORA code in %v", fields)
+	}
+}
+
+func TestQueueRemoveNoData(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	conn, err := testDb.Conn(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	const qName = "TEST_QPEEK"
+	defer createQueue(ctx, t, conn, qName, "", "", "")()
+	q, err := goracle.NewQueue(ctx, conn, qName, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+	if err = q.SetEnqOptions(goracle.EnqOptions{Visibility: goracle.VisibleImmediate}); err != nil {
+		t.Fatal(err)
+	}
+	if err = q.SetDeqOptions(goracle.DeqOptions{
+		Mode: goracle.DeqRemove, Navigation: goracle.NavFirst,
+		Visibility: goracle.VisibleImmediate, Wait: goracle.NoWait,
+	}); err != nil {
+		t.Fatal(err)
+	}
+	enq := []goracle.Message{{Correlation: "peek", Priority: 3, Raw: []byte("large payload")}}
+	if err = q.Enqueue(enq); err != nil {
+		t.Fatal(err)
+	}
+	want := enq[0]
+
+	var got goracle.Message
+	ok, err := q.RemoveNoData(&got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ok {
+		t.Fatal("no message")
+	}
+	t.Logf("removed: %+v", got)
+	if len(got.Raw) != 0 || got.Object != nil {
+		t.Errorf("got payload %q/%v, wanted none", got.Raw, got.Object)
+	}
+	if got.Correlation != want.Correlation || got.Priority != want.Priority || got.MsgID != want.MsgID {
+		t.Errorf("got %q/%d/%x, wanted %q/%d/%x", got.Correlation, got.Priority, got.MsgID, want.Correlation, want.Priority, want.MsgID)
+	}
+
+	// The message is removed.
+	if ok, err = q.RemoveNoData(&got); err != nil {
+		t.Fatal(err)
+	} else if ok {
+		t.Errorf("got %+v, wanted the queue empty", got)
+	}
+
+	// The previous mode is restored.
+	if D, err := q.DeqOptions(); err != nil {
+		t.Fatal(err)
+	} else if D.Mode != goracle.DeqRemove {
+		t.Errorf("mode is %v after RemoveNoData, wanted DeqRemove", D.Mode)
+	}
+}
+
+func TestQueueRawPayloadTooLarge(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	conn, err := testDb.Conn(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	const qName = "TEST_QRAWSIZE"
+	defer createQueue(ctx, t, conn, qName, "", "", "")()
+	q, err := goracle.NewQueue(ctx, conn, qName, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+	if err = q.SetEnqOptions(goracle.EnqOptions{Visibility: goracle.VisibleImmediate}); err != nil {
+		t.Fatal(err)
+	}
+
+	err = q.Enqueue([]goracle.Message{{Raw: make([]byte, goracle.MaxRawPayloadSize+1)}})
+	t.Log(err)
+	if errors.Cause(err) != goracle.ErrPayloadTooLarge {
+		t.Errorf("got %v, wanted ErrPayloadTooLarge", err)
+	}
+
+	q.SetMaxRawSize(10)
+	if err = q.Enqueue([]goracle.Message{{Raw: make([]byte, 10)}}); err != nil {
+		t.Errorf("payload at the limit: %+v", err)
+	}
+	if err = q.Enqueue([]goracle.Message{{Raw: make([]byte, 11)}}); errors.Cause(err) != goracle.ErrPayloadTooLarge {
+		t.Errorf("got %v, wanted ErrPayloadTooLarge", err)
+	}
+}
+
+func TestQueueListen(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	conn, err := testDb.Conn(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	want := map[string]int{"TEST_QLISTEN_A": 5, "TEST_QLISTEN_B": 2}
+	queues := make(map[string]*goracle.Queue, len(want))
+	for qName, n := range want {
+		defer createQueue(ctx, t, conn, qName, "", "", "")()
+		q, err := goracle.NewQueue(ctx, conn, qName, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer q.Close()
+		if err = q.SetEnqOptions(goracle.EnqOptions{Visibility: goracle.VisibleImmediate}); err != nil {
+			t.Fatal(err)
+		}
+		if err = q.SetDeqOptions(goracle.DeqOptions{
+			Mode: goracle.DeqRemove, Navigation: goracle.NavFirst,
+			Visibility: goracle.VisibleImmediate, Wait: goracle.NoWait,
+		}); err != nil {
+			t.Fatal(err)
+		}
+		msgs := make([]goracle.Message, n)
+		for i := range msgs {
+			msgs[i].Raw = []byte(qName)
+		}
+		if err = q.Enqueue(msgs); err != nil {
+			t.Fatal(err)
+		}
+		queues[qName] = q
+	}
+
+	lCtx, lCancel := context.WithCancel(ctx)
+	defer lCancel()
+	msgCh, errCh := goracle.Listen(lCtx, queues)
+	got := make(map[string]int, len(want))
+	var order []string
+	for i := 0; i < 7; i++ {
+		select {
+		case m := <-msgCh:
+			if string(m.Raw) != m.Queue {
+				t.Errorf("message %q came from %s", m.Raw, m.Queue)
+			}
+			got[m.Queue]++
+			order = append(order, m.Queue)
+		case err := <-errCh:
+			t.Fatal(err)
+		case <-ctx.Done():
+			t.Fatalf("timeout, got %v", got)
+		}
+	}
+	lCancel()
+	for range msgCh {
+	}
+	if err := <-errCh; err != nil {
+		t.Error(err)
+	}
+	t.Logf("order: %v", order)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, wanted %v", got, want)
+	}
+	// Round-robin: B's messages come within the first rounds, not after A is drained.
+	if order[1] != "TEST_QLISTEN_B" || order[3] != "TEST_QLISTEN_B" {
+		t.Errorf("queues are not interleaved: %v", order)
+	}
+}
+
+func TestQueueTransformation(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	conn, err := testDb.Conn(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	const qName, srcTyp, dstTyp = "TEST_QTRANS", "TEST_QTRANS_SRC", "TEST_QTRANS_DST"
+	defer createQueueType(ctx, t, conn, srcTyp, "f_src VARCHAR2(20)")()
+	defer createQueueType(ctx, t, conn, dstTyp, "f_dst VARCHAR2(20)")()
+	defer createQueue(ctx, t, conn, qName, dstTyp, "", "")()
+	var user string
+	if err = conn.QueryRowContext(ctx, "SELECT USER FROM DUAL").Scan(&user); err != nil {
+		t.Fatal(err)
+	}
+	// src -> dst uppercases on enqueue, dst -> src lowercases on dequeue.
+	createTransformation := func(name, from, to, expr string) func() {
+		qry := `BEGIN
+  BEGIN DBMS_TRANSFORM.DROP_TRANSFORMATION(schema=>USER, name=>:1); EXCEPTION WHEN OTHERS THEN NULL; END;
+  DBMS_TRANSFORM.CREATE_TRANSFORMATION(schema=>USER, name=>:1,
+    from_schema=>USER, from_type=>:2, to_schema=>USER, to_type=>:3, transformation=>:4);
+END;`
+		if _, err := conn.ExecContext(ctx, qry, name, from, to, expr); err != nil {
+			t.Fatal(errors.Wrap(err, qry))
+		}
+		return func() {
+			testDb.Exec("BEGIN DBMS_TRANSFORM.DROP_TRANSFORMATION(schema=>USER, name=>:1); END;", name)
+		}
+	}
+	defer createTransformation("TEST_QTRANS_UP", srcTyp, dstTyp, user+"."+dstTyp+"(UPPER(source.user_data.f_src))")()
+	defer createTransformation("TEST_QTRANS_DOWN", dstTyp, srcTyp, user+"."+srcTyp+"(LOWER(source.user_data.f_dst))")()
+
+	// The Queue's payload type is the application side type: the source on enqueue, the target on dequeue.
+	q, err := goracle.NewQueue(ctx, conn, qName, srcTyp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+	if err = q.SetEnqOptions(goracle.EnqOptions{
+		Visibility: goracle.VisibleImmediate, Transformation: user + ".TEST_QTRANS_UP",
+	}); err != nil {
+		t.Fatal(err)
+	}
+	oTyp, err := goracle.GetObjectType(ctx, conn, srcTyp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer oTyp.Close()
+	obj, err := oTyp.NewObject()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer obj.Close()
+	if err = obj.Set("F_SRC", "Hello"); err != nil {
+		t.Fatal(err)
+	}
+	if err = q.Enqueue([]goracle.Message{{Object: obj}}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Stored as transformed on enqueue.
+	var stored string
+	if err = conn.QueryRowContext(ctx,
+		"SELECT t.user_data.f_dst FROM "+qName+"_TBL t WHERE q_name = :1", qName,
+	).Scan(&stored); err != nil {
+		t.Fatal(err)
+	}
+	if stored != "HELLO" {
+		t.Errorf("stored %q, wanted HELLO", stored)
+	}
+
+	msgs := make([]goracle.Message, 1)
+	n, err := q.DequeueWith(msgs, goracle.DeqOptions{
+		Mode: goracle.DeqRemove, Navigation: goracle.NavFirst,
+		Visibility: goracle.VisibleImmediate, Wait: goracle.NoWait,
+		Transformation: user + ".TEST_QTRANS_DOWN",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 || msgs[0].Object == nil {
+		t.Fatalf("got %d messages (%+v), wanted 1 object", n, msgs[:n])
+	}
+	defer msgs[0].Object.Close()
+	got, err := msgs[0].Object.Get("F_SRC")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := fmt.Sprintf("%s", got); s != "hello" {
+		t.Errorf("got %q, wanted hello", s)
+	}
+}
+
+func TestQueueConsumeDrain(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	conn, err := testDb.Conn(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	const qName = "TEST_QDRAIN"
+	defer createQueue(ctx, t, conn, qName, "", "", "")()
+	q, err := goracle.NewQueue(ctx, conn, qName, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+	if err = q.SetEnqOptions(goracle.EnqOptions{Visibility: goracle.VisibleImmediate}); err != nil {
+		t.Fatal(err)
+	}
+	const all = 3
+	enq := make([]goracle.Message, all)
+	for i := range enq {
+		enq[i].Raw = []byte(fmt.Sprintf("%02d", i))
+	}
+	if err = q.Enqueue(enq); err != nil {
+		t.Fatal(err)
+	}
+	if err = q.SetDeqOptions(goracle.DeqOptions{
+		Mode: goracle.DeqRemove, Navigation: goracle.NavFirst,
+		Visibility: goracle.VisibleImmediate, Wait: goracle.NoWait,
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Unbuffered: after the first message is read, the loop dequeues the second and waits to send it.
+	c := q.Consume(ctx, 0)
+	defer c.Close()
+	got := []string{string((<-c.Messages()).Raw)}
+	time.Sleep(time.Second)
+	drained := make(chan error, 1)
+	go func() { drained <- c.Drain() }()
+	for m := range c.Messages() {
+		got = append(got, string(m.Raw))
+	}
+	if err = <-drained; err != nil {
+		t.Fatal(err)
+	}
+	t.Logf("got %q", got)
+	if len(got) != 2 {
+		t.Errorf("got %d messages, wanted the in-flight one delivered, too", len(got))
+	}
+	counts, err := q.Counts(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got)+counts.Ready != all {
+		t.Errorf("got %d, %d remained in the queue: lost %d", len(got), counts.Ready, all-len(got)-counts.Ready)
+	}
+}
+
+func TestQueueEnqueueObject(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	conn, err := testDb.Conn(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	const qName, typName = "TEST_QENQOBJ", "TEST_QENQOBJ_TYP"
+	defer createQueueType(ctx, t, conn, typName, `f_vc20 VARCHAR2(20), f_num NUMBER, f_dt DATE, "fMixed" VARCHAR2(10)`)()
+	defer createQueue(ctx, t, conn, qName, typName, "", "")()
+	q, err := goracle.NewQueue(ctx, conn, qName, typName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+	if err = q.SetEnqOptions(goracle.EnqOptions{Visibility: goracle.VisibleImmediate}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = q.EnqueueObject(map[string]interface{}{"f_vc20": "x", "no_such": 1}); errors.Cause(err) != goracle.ErrNoSuchKey {
+		t.Errorf("unknown attribute: got %v, wanted ErrNoSuchKey", err)
+	}
+	dt := time.Date(2019, 7, 1, 12, 34, 56, 0, time.Local)
+	if err = q.EnqueueObject(map[string]interface{}{"fMixed": "unquoted"}); errors.Cause(err) != goracle.ErrNoSuchKey {
+		t.Errorf("unquoted mixed-case attribute: got %v, wanted ErrNoSuchKey", err)
+	}
+	if err = q.EnqueueObject(map[string]interface{}{"f_vc20": "árvíztűrő", "F_NUM": 42, "f_dt": dt, `"fMixed"`: "mixed"}); err != nil {
+		t.Fatal(err)
+	}
+
+	msgs := make([]goracle.Message, 1)
+	n, err := q.DequeueWith(msgs, goracle.DeqOptions{
+		Mode: goracle.DeqRemove, Navigation: goracle.NavFirst,
+		Visibility: goracle.VisibleImmediate, Wait: goracle.NoWait,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 || msgs[0].Object == nil {
+		t.Fatalf("got %d messages (%+v), wanted 1 object", n, msgs[:n])
+	}
+	obj := msgs[0].Object
+	defer obj.Close()
+	for name, want := range map[string]string{"F_VC20": "árvíztűrő", "F_NUM": "42", "fMixed": "mixed"} {
+		v, err := obj.Get(name)
+		if err != nil {
+			t.Fatal(name, err)
+		}
+		if got := fmt.Sprintf("%v", v); got != want && fmt.Sprintf("%s", v) != want {
+			t.Errorf("%s: got %v, wanted %s", name, v, want)
+		}
+	}
+	if v, err := obj.Get("F_DT"); err != nil {
+		t.Fatal(err)
+	} else if got, ok := v.(time.Time); !ok || !got.Equal(dt) {
+		t.Errorf("F_DT: got %v, wanted %v", v, dt)
+	}
+}
+
+func TestQueueEnqueuedPrecision(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	conn, err := testDb.Conn(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	const qName = "TEST_QENQPREC"
+	defer createQueue(ctx, t, conn, qName, "", "", "")()
+	q, err := goracle.NewQueue(ctx, conn, qName, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+	// The server's clock is compared with the local one.
+	q.SetEnqueuedLocation(time.Local)
+	if err = q.SetEnqOptions(goracle.EnqOptions{Visibility: goracle.VisibleImmediate}); err != nil {
+		t.Fatal(err)
+	}
+	const all = 10
+	start := time.Now().Truncate(time.Second)
+	for i := 0; i < all; i++ {
+		if err = q.Enqueue([]goracle.Message{{Raw: []byte{byte(i)}}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	end := time.Now()
+
+	msgs := make([]goracle.Message, all)
+	n, err := q.DequeueWith(msgs, goracle.DeqOptions{
+		Mode: goracle.DeqRemove, Navigation: goracle.NavFirst,
+		Visibility: goracle.VisibleImmediate, Wait: goracle.NoWait,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != all {
+		t.Fatalf("got %d messages, wanted %d", n, all)
+	}
+	var prev time.Time
+	for i, m := range msgs[:n] {
+		if ns := m.Enqueued.Nanosecond(); ns != 0 {
+			t.Errorf("%d. %v has sub-second part %dns", i, m.Enqueued, ns)
+		}
+		if m.Enqueued.Before(prev) {
+			t.Errorf("%d. %v is before the previous %v", i, m.Enqueued, prev)
+		}
+		prev = m.Enqueued
+		if m.Enqueued.Before(start.Add(-time.Minute)) || m.Enqueued.After(end.Add(time.Minute)) {
+			t.Errorf("%d. %v is out of [%v, %v] (clock skew?)", i, m.Enqueued, start, end)
+		}
+	}
+}
+
+func TestQueueJSONNotSupported(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	for _, typ := range []string{"JSON", "sys.json"} {
+		if _, err := goracle.NewQueue(ctx, testDb, "TEST_QJSON", typ); errors.Cause(err) != goracle.ErrNotSupported {
+			t.Errorf("%s: got %v, wanted ErrNotSupported", typ, err)
+		}
+	}
+}
+
+func TestQueueDequeueBuffered(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	conn, err := testDb.Conn(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	const qName = "TEST_QBUFFERED"
+	defer createQueue(ctx, t, conn, qName, "", "", "")()
+	q, err := goracle.NewQueue(ctx, conn, qName, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+	for _, mode := range []goracle.DeliveryMode{goracle.DeliverPersistent, goracle.DeliverBuffered} {
+		if err = q.SetEnqOptions(goracle.EnqOptions{Visibility: goracle.VisibleImmediate, DeliveryMode: mode}); err != nil {
+			t.Fatal(err)
+		}
+		if err = q.Enqueue([]goracle.Message{{Raw: []byte(fmt.Sprintf("%d-1", mode))}, {Raw: []byte(fmt.Sprintf("%d-2", mode))}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err = q.SetDeqOptions(goracle.DeqOptions{
+		Mode: goracle.DeqRemove, Navigation: goracle.NavFirst,
+		Visibility: goracle.VisibleImmediate, Wait: goracle.NoWait,
+		DeliveryMode: goracle.DeliverBuffered,
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if D, err := q.DeqOptions(); err != nil {
+		t.Fatal(err)
+	} else if D.DeliveryMode != goracle.DeliverBuffered {
+		t.Errorf("DeqOptions reports delivery mode %v, wanted DeliverBuffered", D.DeliveryMode)
+	}
+	msgs := make([]goracle.Message, 4)
+	n, err := q.Dequeue(msgs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Errorf("got %d messages, wanted the 2 buffered", n)
+	}
+	for _, m := range msgs[:n] {
+		if m.DeliveryMode != goracle.DeliverBuffered {
+			t.Errorf("got %q with delivery mode %v", m.Raw, m.DeliveryMode)
+		}
+	}
+
+	// The persistent ones remain.
+	counts, err := q.Counts(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if counts.Ready != 2 {
+		t.Errorf("%d persistent messages remained, wanted 2", counts.Ready)
+	}
+
+	// A zero DeliveryMode is DeliverPersistent, not the buffered one set before.
+	if err = q.SetDeqOptions(goracle.DeqOptions{
+		Mode: goracle.DeqRemove, Navigation: goracle.NavFirst,
+		Visibility: goracle.VisibleImmediate, Wait: goracle.NoWait,
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if D, err := q.DeqOptions(); err != nil {
+		t.Fatal(err)
+	} else if D.DeliveryMode != goracle.DeliverPersistent {
+		t.Errorf("DeqOptions reports delivery mode %v, wanted DeliverPersistent", D.DeliveryMode)
+	}
+	if n, err = q.Dequeue(msgs); err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Errorf("got %d messages, wanted the 2 persistent", n)
+	}
+	for _, m := range msgs[:n] {
+		if m.DeliveryMode != goracle.DeliverPersistent {
+			t.Errorf("got %q with delivery mode %v", m.Raw, m.DeliveryMode)
+		}
+	}
+}
+
+func TestQueueEnqueueDeliveryMode(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	conn, err := testDb.Conn(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	const qName = "TEST_QENQDELIVERY"
+	defer createQueue(ctx, t, conn, qName, "", "", "")()
+	q, err := goracle.NewQueue(ctx, conn, qName, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+	for _, tc := range []struct {
+		Set, Want goracle.DeliveryMode
+	}{
+		{0, goracle.DeliverPersistent},
+		{goracle.DeliverBuffered, goracle.DeliverBuffered},
+		{goracle.DeliverPersistent, goracle.DeliverPersistent},
+	} {
+		if err = q.SetEnqOptions(goracle.EnqOptions{Visibility: goracle.VisibleImmediate, DeliveryMode: tc.Set}); err != nil {
+			t.Fatal(err)
+		}
+		// The message's own DeliveryMode is not sent, but overwritten with the effective one.
+		other := goracle.DeliverBuffered
+		if tc.Want == goracle.DeliverBuffered {
+			other = goracle.DeliverPersistent
+		}
+		msgs := []goracle.Message{{Raw: []byte("a")}, {Raw: []byte("b"), DeliveryMode: other}}
+		if err = q.Enqueue(msgs); err != nil {
+			t.Fatal(err)
+		}
+		for i, m := range msgs {
+			if m.DeliveryMode != tc.Want {
+				t.Errorf("%v: %d. message enqueued with delivery mode %v, wanted %v", tc.Set, i, m.DeliveryMode, tc.Want)
+			}
+		}
+	}
+}
+
+func TestQueuePing(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	conn, err := testDb.Conn(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	const qName = "TEST_QPING"
+	defer createQueue(ctx, t, conn, qName, "", "", "")()
+	q, err := goracle.NewQueue(ctx, conn, qName, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+	if err = q.Ping(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// Close the connection under the Queue.
+	dc, err := goracle.DriverConn(ctx, conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = dc.Close(); err != nil {
+		t.Fatal(err)
+	}
+	err = q.Ping(ctx)
+	t.Log(err)
+	if err == nil {
+		t.Fatal("Ping succeeded on a closed connection")
+	}
+	if !goracle.IsConnectionLost(err) {
+		t.Errorf("got %v, wanted a connection lost error", err)
+	}
+
+	q.Close()
+	if err = q.Ping(ctx); !goracle.IsConnectionLost(err) {
+		t.Errorf("closed queue: got %v, wanted a connection lost error", err)
+	}
+}
+
+func TestQueueMultiConsumerPreset(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	conn, err := testDb.Conn(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	const qName = "TEST_QMCPRESET"
+	defer createQueue(ctx, t, conn, qName, "", ", multiple_consumers=>TRUE", "")()
+	addSubscribers(ctx, t, conn, qName, "SUB_A", "SUB_B")
+
+	q, err := goracle.NewMultiConsumerQueue(ctx, conn, qName, "", "SUB_A")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+	if D, err := q.DeqOptions(); err != nil {
+		t.Fatal(err)
+	} else if D.Consumer != "SUB_A" {
+		t.Errorf("consumer is %q, wanted SUB_A", D.Consumer)
+	}
+	if err = q.SetEnqOptions(goracle.EnqOptions{Visibility: goracle.VisibleImmediate}); err != nil {
+		t.Fatal(err)
+	}
+	if err = q.Enqueue([]goracle.Message{{Raw: []byte("1")}, {Raw: []byte("2")}}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Options without Consumer keep dequeueing as SUB_A.
+	msgs := make([]goracle.Message, 1)
+	for i := 0; i < 2; i++ {
+		n, err := q.DequeueWith(msgs, goracle.DeqOptions{
+			Mode: goracle.DeqRemove, Navigation: goracle.NavFirst,
+			Visibility: goracle.VisibleImmediate, Wait: goracle.NoWait,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != 1 {
+			t.Fatalf("%d. got %d messages, wanted 1", i, n)
+		}
+	}
+	if n, err := q.Dequeue(msgs); err != nil {
+		t.Fatal(err)
+	} else if n != 0 {
+		t.Errorf("SUB_A got a third message")
+	}
+	// SUB_B still has both.
+	n, err := q.DequeueWith(make([]goracle.Message, 2), goracle.DeqOptions{
+		Consumer: "SUB_B", Mode: goracle.DeqRemove, Navigation: goracle.NavFirst,
+		Visibility: goracle.VisibleImmediate, Wait: goracle.NoWait,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Errorf("SUB_B got %d messages, wanted 2", n)
+	}
+}
+
+func TestQueueEnqueueErrorBounded(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	conn, err := testDb.Conn(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	q, err := goracle.NewQueue(ctx, conn, "TEST_QERRDUMP_NONEXISTENT", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+	msgs := make([]goracle.Message, 100)
+	for i := range msgs {
+		msgs[i].Raw = []byte(strings.Repeat("x", 10000))
+	}
+	err = q.Enqueue(msgs)
+	if err == nil {
+		t.Fatal("enqueue to a nonexistent queue succeeded")
+	}
+	msg := err.Error()
+	t.Log(msg)
+	if len(msg) > 1024 {
+		t.Errorf("error message is %d bytes long", len(msg))
+	}
+	if !strings.Contains(msg, "100 messages") {
+		t.Errorf("error message %q does not contain the message count", msg)
+	}
+}
+
+func TestQueueEnqueueErrorRedacted(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	conn, err := testDb.Conn(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	q, err := goracle.NewQueue(ctx, conn, "TEST_QERRDUMP_NONEXISTENT", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+	q.SetRedactPayloads(true)
+	const secret = "SSN:123-45-6789"
+	err = q.Enqueue([]goracle.Message{{Raw: []byte(secret)}, {Raw: []byte(secret)}})
+	if err == nil {
+		t.Fatal("enqueue to a nonexistent queue succeeded")
+	}
+	msg := fmt.Sprintf("%+v", err)
+	t.Log(msg)
+	if strings.Contains(msg, "123-45") {
+		t.Errorf("error message %q contains the payload", msg)
+	}
+	if !strings.Contains(msg, "2 messages") {
+		t.Errorf("error message %q does not contain the message count", msg)
+	}
+}
+
+func TestQueueObjectCollection(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	conn, err := testDb.Conn(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	const qName, arrName, typName = "TEST_QCOLL", "TEST_QCOLL_ARR", "TEST_QCOLL_TYP"
+	conn.ExecContext(ctx, "DROP TYPE "+typName+" FORCE")
+	conn.ExecContext(ctx, "DROP TYPE "+arrName+" FORCE")
+	qry := "CREATE OR REPLACE TYPE " + arrName + " IS VARRAY(10) OF VARCHAR2(10)"
+	if _, err = conn.ExecContext(ctx, qry); err != nil {
+		t.Fatal(errors.Wrap(err, qry))
+	}
+	defer testDb.Exec("DROP TYPE " + arrName + " FORCE")
+	defer createQueueType(ctx, t, conn, typName, "f_id NUMBER(9), f_arr "+arrName)()
+	defer createQueue(ctx, t, conn, qName, typName, "", "")()
+
+	q, err := goracle.NewQueue(ctx, conn, qName, typName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+	if err = q.SetEnqOptions(goracle.EnqOptions{Visibility: goracle.VisibleImmediate}); err != nil {
+		t.Fatal(err)
+	}
+
+	oTyp, err := goracle.GetObjectType(ctx, conn, typName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer oTyp.Close()
+	obj, err := oTyp.NewObject()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer obj.Close()
+	arr, err := oTyp.Attributes["F_ARR"].NewCollection()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer arr.Close()
+	want := []string{"one", "two", "three"}
+	for _, s := range want {
+		if err = arr.Append(s); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = obj.Set("F_ID", int64(1)); err != nil {
+		t.Fatal(err)
+	}
+	if err = obj.Set("F_ARR", arr); err != nil {
+		t.Fatal(err)
+	}
+	if err = q.Enqueue([]goracle.Message{{Object: obj}}); err != nil {
+		t.Fatal(err)
+	}
+
+	msgs := make([]goracle.Message, 1)
+	n, err := q.DequeueWith(msgs, goracle.DeqOptions{
+		Mode: goracle.DeqRemove, Navigation: goracle.NavFirst,
+		Visibility: goracle.VisibleImmediate, Wait: goracle.NoWait,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 || msgs[0].Object == nil {
+		t.Fatalf("got %d messages (%+v), wanted 1 object", n, msgs[:n])
+	}
+	defer msgs[0].Object.Close()
+	v, err := msgs[0].Object.Get("F_ARR")
+	if err != nil {
+		t.Fatal(err)
+	}
+	coll, ok := v.(*goracle.ObjectCollection)
+	if !ok {
+		t.Fatalf("F_ARR is %T, wanted *ObjectCollection", v)
+	}
+	if length, err := coll.Len(); err != nil {
+		t.Fatal(err)
+	} else if length != len(want) {
+		t.Fatalf("got %d elements, wanted %d", length, len(want))
+	}
+	got := make([]string, 0, len(want))
+	for i, err := coll.First(); err == nil; i, err = coll.Next(i) {
+		e, err := coll.Get(i)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, fmt.Sprintf("%s", e))
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, wanted %q", got, want)
+	}
+}
+
+func TestQueueCloseBreaksDequeue(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	conn, err := testDb.Conn(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	const qName = "TEST_QCLOSEBREAK"
+	defer createQueue(ctx, t, conn, qName, "", "", "")()
+	q, err := goracle.NewQueue(ctx, conn, qName, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := q.DequeueWith(make([]goracle.Message, 1), goracle.DeqOptions{
+			Mode: goracle.DeqRemove, Navigation: goracle.NavFirst,
+			Visibility: goracle.VisibleImmediate, Wait: goracle.WaitForever,
+		})
+		done <- err
+	}()
+	time.Sleep(time.Second)
+
+	closed := make(chan error, 1)
+	go func() { closed <- q.Close() }()
+	select {
+	case err := <-closed:
+		if err != nil {
+			t.Error(err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("Close hangs")
+	}
+	select {
+	case err := <-done:
+		t.Logf("dequeue returned %v", err)
+	case <-time.After(10 * time.Second):
+		t.Fatal("dequeue is still blocked")
+	}
+}
+
+func TestQueueEnqueueOrdered(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	conn, err := testDb.Conn(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	const qName = "TEST_QORDERED"
+	defer createQueue(ctx, t, conn, qName, "", "", "")()
+	q, err := goracle.NewQueue(ctx, conn, qName, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+	if err = q.SetEnqOptions(goracle.EnqOptions{Visibility: goracle.VisibleImmediate}); err != nil {
+		t.Fatal(err)
+	}
+	const all = 50
+	enq := make([]goracle.Message, all)
+	for i := range enq {
+		enq[i] = goracle.Message{Priority: 1, Raw: []byte(fmt.Sprintf("%03d", i))}
+	}
+	if err = q.EnqueueOrdered(enq); err != nil {
+		t.Fatal(err)
+	}
+
+	msgs := make([]goracle.Message, all)
+	n, err := q.DequeueWith(msgs, goracle.DeqOptions{
+		Mode: goracle.DeqRemove, Navigation: goracle.NavFirst,
+		Visibility: goracle.VisibleImmediate, Wait: goracle.NoWait,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != all {
+		t.Fatalf("got %d messages, wanted %d", n, all)
+	}
+	for i, m := range msgs[:n] {
+		if want := fmt.Sprintf("%03d", i); string(m.Raw) != want {
+			t.Errorf("%d. got %q, wanted %q", i, m.Raw, want)
+		}
+	}
+}
+
+func TestQueueHeaders(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	conn, err := testDb.Conn(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	const qName = "TEST_QHEADERS"
+	defer createQueue(ctx, t, conn, qName, "", "", "")()
+	q, err := goracle.NewQueue(ctx, conn, qName, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+	if err = q.SetEnqOptions(goracle.EnqOptions{Visibility: goracle.VisibleImmediate}); err != nil {
+		t.Fatal(err)
+	}
+	want := []goracle.Message{
+		{Raw: []byte("with headers"), Headers: map[string]string{"trace-id": "0af7651916cd43dd8448eb211c80319c", "span": "b7ad6b7169203331"}},
+		{Raw: []byte("without headers")},
+	}
+	if err = q.Enqueue(want); err != nil {
+		t.Fatal(err)
+	}
+
+	// Without SetDequeueHeaders, the envelope is left in Raw.
+	msgs := make([]goracle.Message, len(want))
+	n, err := q.DequeueWith(msgs[:1], goracle.DeqOptions{
+		Mode: goracle.DeqBrowse, Navigation: goracle.NavFirst,
+		Visibility: goracle.VisibleImmediate, Wait: goracle.NoWait,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 || msgs[0].Headers != nil || !bytes.HasPrefix(msgs[0].Raw, []byte("GOQH1")) {
+		t.Errorf("without SetDequeueHeaders: got %d messages, %q %q; wanted the envelope in Raw", n, msgs[0].Raw, msgs[0].Headers)
+	}
+
+	q.SetDequeueHeaders(true)
+	n, err = q.DequeueWith(msgs, goracle.DeqOptions{
+		Mode: goracle.DeqRemove, Navigation: goracle.NavFirst,
+		Visibility: goracle.VisibleImmediate, Wait: goracle.NoWait,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != len(want) {
+		t.Fatalf("got %d messages, wanted %d", n, len(want))
+	}
+	for i, m := range msgs[:n] {
+		if string(m.Raw) != string(want[i].Raw) || !reflect.DeepEqual(m.Headers, want[i].Headers) {
+			t.Errorf("%d. got %q %q, wanted %q %q", i, m.Raw, m.Headers, want[i].Raw, want[i].Headers)
+		}
+	}
+}
+
+func TestQueueTraceID(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	conn, err := testDb.Conn(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	const qName = "TEST_QTRACEID"
+	defer createQueue(ctx, t, conn, qName, "", "", "")()
+	q, err := goracle.NewQueue(ctx, conn, qName, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+	if err = q.SetEnqOptions(goracle.EnqOptions{Visibility: goracle.VisibleImmediate}); err != nil {
+		t.Fatal(err)
+	}
+	if err = q.SetDeqOptions(goracle.DeqOptions{
+		Mode: goracle.DeqRemove, Navigation: goracle.NavFirst,
+		Visibility: goracle.VisibleImmediate, Wait: goracle.NoWait,
+	}); err != nil {
+		t.Fatal(err)
+	}
+	q.SetDequeueHeaders(true)
+
+	const traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+	headers := map[string]string{"span": "00f067aa0ba902b7"}
+	if err = q.EnqueueContext(goracle.ContextWithTraceID(ctx, traceID), []goracle.Message{
+		{Raw: []byte("traced"), Headers: headers},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := headers[goracle.TraceIDHeader]; ok {
+		t.Errorf("EnqueueContext modified the caller's headers: %q", headers)
+	}
+	if err = q.EnqueueContext(ctx, []goracle.Message{{Raw: []byte("untraced")}}); err != nil {
+		t.Fatal(err)
+	}
+
+	msgs := make([]goracle.Message, 2)
+	n, err := q.DequeueContext(ctx, msgs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != len(msgs) {
+		t.Fatalf("got %d messages, wanted %d", n, len(msgs))
+	}
+	if got := goracle.TraceIDFromContext(msgs[0].TraceContext(ctx)); got != traceID {
+		t.Errorf("got trace id %q, wanted %q", got, traceID)
+	}
+	if got := msgs[0].Headers["span"]; got != headers["span"] {
+		t.Errorf("got span %q, wanted %q", got, headers["span"])
+	}
+	if got := goracle.TraceIDFromContext(msgs[1].TraceContext(ctx)); got != "" {
+		t.Errorf("got trace id %q for the untraced message", got)
+	}
+}
+
+func TestQueueDequeueAtLeast(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
+	conn, err := testDb.Conn(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	const qName = "TEST_QATLEAST"
+	defer createQueue(ctx, t, conn, qName, "", "", "")()
+	q, err := goracle.NewQueue(ctx, conn, qName, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+	if err = q.SetDeqOptions(goracle.DeqOptions{
+		Mode: goracle.DeqRemove, Navigation: goracle.NavFirst,
+		Visibility: goracle.VisibleImmediate, Wait: goracle.NoWait,
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The producer trickles the messages on its own connection.
+	const all, batch = 25, 10
+	produced := make(chan error, 1)
+	go func() {
+		pq, err := goracle.NewQueue(ctx, testDb, qName, "")
+		if err != nil {
+			produced <- err
+			return
+		}
+		defer pq.Close()
+		if err = pq.SetEnqOptions(goracle.EnqOptions{Visibility: goracle.VisibleImmediate}); err != nil {
+			produced <- err
+			return
+		}
+		for i := 0; i < all; i++ {
+			if err = pq.Enqueue([]goracle.Message{{Raw: []byte(fmt.Sprintf("%02d", i))}}); err != nil {
+				produced <- err
+				return
+			}
+			time.Sleep(50 * time.Millisecond)
+		}
+		produced <- nil
+	}()
+
+	var got int
+	for got < all {
+		msgs, err := q.DequeueAtLeast(ctx, batch, 2*batch, 5*time.Second)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Logf("got a batch of %d", len(msgs))
+		if len(msgs) == 0 {
+			t.Fatalf("no messages, got %d of %d", got, all)
+		}
+		if len(msgs) < batch && got+len(msgs) < all {
+			t.Errorf("got a batch of %d before the end, wanted at least %d", len(msgs), batch)
+		}
+		for i, m := range msgs {
+			if want := fmt.Sprintf("%02d", got+i); string(m.Raw) != want {
+				t.Errorf("got %q, wanted %q", m.Raw, want)
+			}
+		}
+		got += len(msgs)
+	}
+	if err := <-produced; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestQueueObjectTypeCache(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	conn, err := testDb.Conn(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	const qName, typName = "TEST_QTYPECACHE", "TEST_QTYPECACHE_TYP"
+	defer createQueueType(ctx, t, conn, typName, "id NUMBER, txt VARCHAR2(100)")()
+	defer createQueue(ctx, t, conn, qName, typName, "", "")()
+
+	const qry = `SELECT S.value FROM v$mystat S, v$statname N
+		WHERE N.statistic# = S.statistic# AND N.name = 'SQL*Net roundtrips to/from client'`
+	roundTrips := func() int64 {
+		var n int64
+		if err := conn.QueryRowContext(ctx, qry).Scan(&n); err != nil {
+			t.Skip(err)
+		}
+		return n
+	}
+	newQueue := func() int64 {
+		start := roundTrips()
+		q, err := goracle.NewQueue(ctx, conn, qName, typName)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer q.Close()
+		return roundTrips() - start
+	}
+	first, second := newQueue(), newQueue()
+	t.Logf("round trips: first=%d second=%d", first, second)
+	if second >= first {
+		t.Errorf("second NewQueue took %d round trips, the first %d - the object type is not cached", second, first)
+	}
+}
+
+func TestQueueTransformedObjectType(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	conn, err := testDb.Conn(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	const qName, v1Typ, v2Typ = "TEST_QTRANSTYP", "TEST_QTRANSTYP_V1", "TEST_QTRANSTYP_V2"
+	defer createQueueType(ctx, t, conn, v1Typ, "name VARCHAR2(20)")()
+	defer createQueueType(ctx, t, conn, v2Typ, "first_name VARCHAR2(20), version NUMBER(3)")()
+	defer createQueue(ctx, t, conn, qName, v1Typ, "", "")()
+	var user string
+	if err = conn.QueryRowContext(ctx, "SELECT USER FROM DUAL").Scan(&user); err != nil {
+		t.Fatal(err)
+	}
+	const trName = "TEST_QTRANSTYP_UPGRADE"
+	qry := `BEGIN
+  BEGIN DBMS_TRANSFORM.DROP_TRANSFORMATION(schema=>USER, name=>:1); EXCEPTION WHEN OTHERS THEN NULL; END;
+  DBMS_TRANSFORM.CREATE_TRANSFORMATION(schema=>USER, name=>:1,
+    from_schema=>USER, from_type=>:2, to_schema=>USER, to_type=>:3, transformation=>:4);
+END;`
+	if _, err = conn.ExecContext(ctx, qry, trName, v1Typ, v2Typ,
+		user+"."+v2Typ+"(source.user_data.name, 2)",
+	); err != nil {
+		t.Fatal(errors.Wrap(err, qry))
+	}
+	defer testDb.Exec("BEGIN DBMS_TRANSFORM.DROP_TRANSFORMATION(schema=>USER, name=>:1); END;", trName)
+
+	// Enqueue a v1 message.
+	enqQ, err := goracle.NewQueue(ctx, conn, qName, v1Typ)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer enqQ.Close()
+	if err = enqQ.SetEnqOptions(goracle.EnqOptions{Visibility: goracle.VisibleImmediate}); err != nil {
+		t.Fatal(err)
+	}
+	if err = enqQ.EnqueueObject(map[string]interface{}{"NAME": "Alice"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Dequeue it upgraded to v2.
+	deqQ, err := goracle.NewQueue(ctx, conn, qName, v2Typ)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer deqQ.Close()
+	msgs := make([]goracle.Message, 1)
+	n, err := deqQ.DequeueWith(msgs, goracle.DeqOptions{
+		Mode: goracle.DeqRemove, Navigation: goracle.NavFirst,
+		Visibility: goracle.VisibleImmediate, Wait: goracle.NoWait,
+		Transformation: user + "." + trName,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 || msgs[0].Object == nil {
+		t.Fatalf("got %d messages (%+v), wanted 1 object", n, msgs[:n])
+	}
+	obj := msgs[0].Object
+	defer obj.Close()
+	if obj.ObjectType.Name != v2Typ {
+		t.Errorf("got object of type %s, wanted %s", obj.ObjectType.Name, v2Typ)
+	}
+	if _, ok := obj.ObjectType.Attributes["VERSION"]; !ok {
+		t.Errorf("no VERSION attribute in %+v", obj.ObjectType.Attributes)
+	}
+	got, err := obj.Get("FIRST_NAME")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := fmt.Sprintf("%s", got); s != "Alice" {
+		t.Errorf("got %q, wanted Alice", s)
+	}
+}
+
+func TestQueueEnqueueOne(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	conn, err := testDb.Conn(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	const qName = "TEST_QENQONE"
+	defer createQueue(ctx, t, conn, qName, "", "", "")()
+	q, err := goracle.NewQueue(ctx, conn, qName, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+	if err = q.SetEnqOptions(goracle.EnqOptions{Visibility: goracle.VisibleImmediate}); err != nil {
+		t.Fatal(err)
+	}
+	if err = q.SetDeqOptions(goracle.DeqOptions{
+		Mode: goracle.DeqRemove, Navigation: goracle.NavFirst,
+		Visibility: goracle.VisibleImmediate, Wait: goracle.NoWait,
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	m := goracle.Message{Raw: []byte("one"), Correlation: "corr", Priority: 3}
+	oneID, err := q.EnqueueOne(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msgs := []goracle.Message{m}
+	if err = q.Enqueue(msgs); err != nil {
+		t.Fatal(err)
+	}
+	sliceID := msgs[0].MsgID
+	var zero [goracle.MsgIDLength]byte
+	if oneID == zero || sliceID == zero {
+		t.Fatalf("got zero MsgID: EnqueueOne=%x Enqueue=%x", oneID, sliceID)
+	}
+	if oneID == sliceID {
+		t.Errorf("got the same MsgID %x for both", oneID)
+	}
+
+	got := make([]goracle.Message, 2)
+	n, err := q.Dequeue(got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Fatalf("got %d messages, wanted 2", n)
+	}
+	for i, want := range [][goracle.MsgIDLength]byte{oneID, sliceID} {
+		g := got[i]
+		if g.MsgID != want {
+			t.Errorf("%d. got MsgID %x, wanted %x", i, g.MsgID, want)
+		}
+		if string(g.Raw) != "one" || g.Correlation != "corr" || g.Priority != 3 {
+			t.Errorf("%d. got %q/%q/%d, wanted one/corr/3", i, g.Raw, g.Correlation, g.Priority)
+		}
+	}
+}
+
+func TestQueueConfig(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	conn, err := testDb.Conn(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	for _, tc := range []struct {
+		Name, Args string
+		Want       goracle.QueueConfig
+	}{
+		{"TEST_QCONFIG", ", max_retries=>3, retry_delay=>10, retention_time=>3600",
+			goracle.QueueConfig{Retention: time.Hour, MaxRetries: 3, RetryDelay: 10 * time.Second}},
+		{"TEST_QCONFIG_FOREVER", ", retention_time=>DBMS_AQADM.INFINITE",
+			goracle.QueueConfig{Retention: goracle.RetainForever, MaxRetries: 5}},
+	} {
+		tc := tc
+		t.Run(tc.Name, func(t *testing.T) {
+			defer createQueue(ctx, t, conn, tc.Name, "", "", tc.Args)()
+			q, err := goracle.NewQueue(ctx, conn, tc.Name, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer q.Close()
+			got, err := q.Config(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tc.Want {
+				t.Errorf("got %+v, wanted %+v", got, tc.Want)
+			}
+		})
+	}
+}
+
+func TestQueueDefaultPriority(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	conn, err := testDb.Conn(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	const qName = "TEST_QDEFPRIO"
+	defer createQueue(ctx, t, conn, qName, "", "", "")()
+	q, err := goracle.NewQueue(ctx, conn, qName, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+	if err = q.SetEnqOptions(goracle.EnqOptions{Visibility: goracle.VisibleImmediate}); err != nil {
+		t.Fatal(err)
+	}
+	if err = q.SetDeqOptions(goracle.DeqOptions{
+		Mode: goracle.DeqRemove, Navigation: goracle.NavFirst,
+		Visibility: goracle.VisibleImmediate, Wait: goracle.NoWait,
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		Name string
+		Msg  goracle.Message
+		Want int32
+	}{
+		{"unset", goracle.Message{Raw: []byte("unset")}, goracle.DefaultPriority},
+		{"zero", goracle.Message{Raw: []byte("zero"), PriorityValid: true}, 0},
+		{"set", goracle.Message{Raw: []byte("set"), Priority: 7}, 7},
+	} {
+		if err = q.Enqueue([]goracle.Message{tc.Msg}); err != nil {
+			t.Fatal(err)
+		}
+		msgs := make([]goracle.Message, 1)
+		n, err := q.Dequeue(msgs)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != 1 {
+			t.Fatalf("%s: got %d messages, wanted 1", tc.Name, n)
+		}
+		if got := msgs[0]; got.Priority != tc.Want || !got.PriorityValid {
+			t.Errorf("%s: got priority %d (valid=%t), wanted %d", tc.Name, got.Priority, got.PriorityValid, tc.Want)
+		}
+	}
+}
+
+func TestQueueDequeueOriginal(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 90*time.Second)
+	defer cancel()
+	conn, err := testDb.Conn(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	const qName, excName = "TEST_QORIG", "TEST_QORIG_EXC"
+	defer createQueue(ctx, t, conn, qName, "", "", "")()
+	const qry = `BEGIN
+  DBMS_AQADM.create_queue(queue_name=>USER||'.'||:1, queue_table=>USER||'.'||:2, queue_type=>DBMS_AQADM.EXCEPTION_QUEUE);
+  DBMS_AQADM.start_queue(USER||'.'||:1, enqueue=>FALSE, dequeue=>TRUE);
+END;`
+	if _, err = conn.ExecContext(ctx, qry, excName, qName+"_TBL"); err != nil {
+		t.Fatal(errors.Wrap(err, qry))
+	}
+	var user string
+	if err = conn.QueryRowContext(ctx, "SELECT USER FROM DUAL").Scan(&user); err != nil {
+		t.Fatal(err)
+	}
+
+	q, err := goracle.NewQueue(ctx, conn, qName, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+	if err = q.SetEnqOptions(goracle.EnqOptions{Visibility: goracle.VisibleImmediate}); err != nil {
+		t.Fatal(err)
+	}
+	var orig [goracle.MsgIDLength]byte
+	copy(orig[:], "original-msg-id!")
+	for i, m := range []goracle.Message{
+		{Raw: []byte("other"), Expiration: 1, ExceptionQ: user + "." + excName},
+		{Raw: []byte("failed"), Expiration: 1, ExceptionQ: user + "." + excName, OriginalMsgID: orig},
+	} {
+		if err = q.Enqueue([]goracle.Message{m}); err != nil {
+			t.Fatalf("%d. %+v", i, err)
+		}
+	}
+
+	exc, err := goracle.NewQueue(ctx, conn, excName, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer exc.Close()
+	if err = exc.SetDeqOptions(goracle.DeqOptions{
+		Mode: goracle.DeqRemove, Navigation: goracle.NavFirst,
+		Visibility: goracle.VisibleImmediate, Wait: goracle.NoWait,
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Wait for the time manager to move the expired messages to the exception queue.
+	var msg goracle.Message
+	for {
+		ok, err := exc.DequeueOriginal(ctx, orig, &msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ok {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			t.Skip("the message has not expired in time")
+		case <-time.After(time.Second):
+		}
+	}
+	if string(msg.Raw) != "failed" || msg.OriginalMsgID != orig {
+		t.Errorf("got %q (original %x), wanted failed (%x)", msg.Raw, msg.OriginalMsgID, orig)
+	}
+	if ok, err := exc.DequeueOriginal(ctx, orig, &msg); err != nil || ok {
+		t.Errorf("dequeued again: %t, %+v", ok, err)
+	}
+}
+
+func TestQueueConditionAndCorrelation(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	conn, err := testDb.Conn(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	const qName, typName = "TEST_QCONDCORR", "TEST_QCONDCORR_TYP"
+	defer createQueueType(ctx, t, conn, typName, "amount NUMBER")()
+	defer createQueue(ctx, t, conn, qName, typName, "", "")()
+	q, err := goracle.NewQueue(ctx, conn, qName, typName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+	if err = q.SetEnqOptions(goracle.EnqOptions{Visibility: goracle.VisibleImmediate}); err != nil {
+		t.Fatal(err)
+	}
+	oTyp, err := goracle.GetObjectType(ctx, conn, typName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer oTyp.Close()
+	for _, m := range []struct {
+		Correlation string
+		Amount      int
+	}{{"ORDER.1", 50}, {"ORDER.2", 150}, {"INVOICE.1", 200}, {"ORDER.3", 300}} {
+		obj, err := oTyp.NewObject()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = obj.Set("AMOUNT", m.Amount); err == nil {
+			err = q.Enqueue([]goracle.Message{{Object: obj, Correlation: m.Correlation}})
+		}
+		obj.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err = q.SetDeqOptions(goracle.DeqOptions{
+		Mode: goracle.DeqRemove, Navigation: goracle.NavFirst,
+		Visibility: goracle.VisibleImmediate, Wait: goracle.NoWait,
+		Correlation: "ORDER.%", Condition: "tab.user_data.amount > 100",
+	}); err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	msgs := make([]goracle.Message, 1)
+	for {
+		n, err := q.Dequeue(msgs)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n == 0 {
+			break
+		}
+		got = append(got, msgs[0].Correlation)
+		msgs[0].Object.Close()
+	}
+	if want := []string{"ORDER.2", "ORDER.3"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, wanted %q", got, want)
+	}
+}
+
+func TestQueueKeepAlive(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
+	conn, err := testDb.Conn(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	const qName = "TEST_QKEEPALIVE"
+	defer createQueue(ctx, t, conn, qName, "", "", "")()
+	var sid, serial int64
+	if err = conn.QueryRowContext(ctx,
+		"SELECT sid, serial# FROM v$session WHERE sid = SYS_CONTEXT('USERENV', 'SID')",
+	).Scan(&sid, &serial); err != nil {
+		t.Skip(err)
+	}
+	q, err := goracle.NewQueue(ctx, conn, qName, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+	if err = q.SetDeqOptions(goracle.DeqOptions{
+		Mode: goracle.DeqRemove, Navigation: goracle.NavFirst,
+		Visibility: goracle.VisibleImmediate, Wait: goracle.WaitForever,
+	}); err != nil {
+		t.Fatal(err)
+	}
+	const interval = 2 * time.Second
+	q.SetKeepAlive(interval)
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := q.Dequeue(make([]goracle.Message, 1))
+		done <- err
+	}()
+	time.Sleep(interval / 2)
+
+	// Drop the connection under the waiting consumer.
+	qry := fmt.Sprintf("ALTER SYSTEM KILL SESSION '%d,%d' IMMEDIATE", sid, serial)
+	if _, err = testDb.ExecContext(ctx, qry); err != nil {
+		t.Skip(errors.Wrap(err, qry))
+	}
+	start := time.Now()
+	select {
+	case err = <-done:
+		t.Logf("detected in %s: %+v", time.Since(start), err)
+		if !goracle.IsConnectionLost(err) {
+			t.Errorf("got %+v, wanted a lost connection error", err)
+		}
+		if q.IsValid() {
+			t.Error("IsValid reports true after the connection is lost")
+		}
+	case <-time.After(3 * interval):
+		t.Fatalf("the dropped connection is not detected in %s", 3*interval)
+	}
+}
+
+func TestQueueEnqueueDryRun(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	conn, err := testDb.Conn(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	const qName = "TEST_QDRYRUN"
+	defer createQueue(ctx, t, conn, qName, "", "", "")()
+	q, err := goracle.NewQueue(ctx, conn, qName, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+	if err = q.SetEnqOptions(goracle.EnqOptions{Visibility: goracle.VisibleImmediate}); err != nil {
+		t.Fatal(err)
+	}
+
+	valid := []goracle.Message{
+		{Raw: []byte("a"), Correlation: "x"},
+		{Raw: []byte("b"), Priority: 2, Delay: 10},
+	}
+	if err = q.EnqueueDryRun(valid); err != nil {
+		t.Errorf("valid batch: %+v", err)
+	}
+	invalid := []goracle.Message{
+		{Raw: []byte("a")},
+		{Raw: []byte("b"), Delay: -1},
+		{Raw: []byte("c")},
+	}
+	if err = q.EnqueueDryRun(invalid); err == nil {
+		t.Error("invalid batch: wanted error")
+	} else if !strings.Contains(err.Error(), "1. message") {
+		t.Errorf("invalid batch: error %q does not name the invalid message", err)
+	}
+
+	counts, err := q.Counts(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if counts != (goracle.MessageCounts{}) {
+		t.Errorf("dry run enqueued messages: %+v", counts)
+	}
+}
+
+func TestQueueSequenceDeviation(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	conn, err := testDb.Conn(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	const qName = "TEST_QSEQDEV"
+	defer createQueue(ctx, t, conn, qName, "", "", "")()
+	q, err := goracle.NewQueue(ctx, conn, qName, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+	enqueue := func(E goracle.EnqOptions, payload string) [goracle.MsgIDLength]byte {
+		t.Helper()
+		E.Visibility = goracle.VisibleImmediate
+		if err := q.SetEnqOptions(E); err != nil {
+			t.Fatal(err)
+		}
+		id, err := q.EnqueueOne(goracle.Message{Raw: []byte(payload)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return id
+	}
+
+	enqueue(goracle.EnqOptions{}, "first")
+	secondID := enqueue(goracle.EnqOptions{}, "second")
+	enqueue(goracle.EnqOptions{SequenceDeviation: goracle.SeqTop}, "urgent")
+	opts, err := q.EnqOptions()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opts.SequenceDeviation != goracle.SeqTop {
+		t.Errorf("got sequence deviation %d, wanted %d", opts.SequenceDeviation, goracle.SeqTop)
+	}
+	enqueue(goracle.EnqOptions{SequenceDeviation: goracle.SeqBefore, RelativeMsgID: secondID}, "before second")
+	enqueue(goracle.EnqOptions{}, "last")
+
+	if err = q.SetDeqOptions(goracle.DeqOptions{
+		Mode: goracle.DeqRemove, Navigation: goracle.NavFirst,
+		Visibility: goracle.VisibleImmediate, Wait: goracle.NoWait,
+	}); err != nil {
+		t.Fatal(err)
+	}
+	msgs := make([]goracle.Message, 10)
+	n, err := q.Dequeue(msgs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := make([]string, n)
+	for i, m := range msgs[:n] {
+		got[i] = string(m.Raw)
+	}
+	if want := []string{"urgent", "first", "before second", "second", "last"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, wanted %q", got, want)
+	}
+}
+
+func TestQueueDequeueTyped(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	conn, err := testDb.Conn(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	const qName, typName = "TEST_QTYPED", "TEST_QTYPED_TYP"
+	defer createQueueType(ctx, t, conn, typName, "id NUMBER(9), name VARCHAR2(20), amount NUMBER")()
+	defer createQueue(ctx, t, conn, qName, typName, "", "")()
+	q, err := goracle.NewQueue(ctx, conn, qName, typName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+	if err = q.SetEnqOptions(goracle.EnqOptions{Visibility: goracle.VisibleImmediate}); err != nil {
+		t.Fatal(err)
+	}
+	if err = q.SetDeqOptions(goracle.DeqOptions{
+		Mode: goracle.DeqRemove, Navigation: goracle.NavFirst,
+		Visibility: goracle.VisibleImmediate, Wait: goracle.NoWait,
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	type order struct {
+		ID     int
+		Title  string `goracle:"NAME"`
+		Amount float64
+		Ignore string `goracle:"-"`
+	}
+	var want []order
+	for i := 1; i <= 5; i++ {
+		o := order{ID: i, Title: fmt.Sprintf("order-%d", i), Amount: float64(i) * 1.5}
+		want = append(want, o)
+		if err = q.EnqueueObject(map[string]interface{}{"ID": o.ID, "NAME": o.Title, "AMOUNT": o.Amount}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var got []order
+	n, err := q.DequeueTyped(&got, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != len(want) {
+		t.Fatalf("got %d messages, wanted %d", n, len(want))
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, wanted %+v", got, want)
+	}
+
+	if _, err = q.DequeueTyped(got, 1); err == nil {
+		t.Error("non-pointer dest: wanted error")
+	}
+
+	// A message failing to decode does not lose the others.
+	for _, name := range []string{"12", "twelve", "34"} {
+		if err = q.EnqueueObject(map[string]interface{}{"NAME": name}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	type numbered struct {
+		Num int `goracle:"NAME"`
+	}
+	var nums []numbered
+	if n, err = q.DequeueTyped(&nums, 10); n != 3 {
+		t.Fatalf("got %d messages (%+v), wanted 3", n, err)
+	}
+	if de, ok := errors.Cause(err).(*goracle.DecodeError); !ok {
+		t.Errorf("got %+v, wanted a DecodeError", err)
+	} else if !reflect.DeepEqual(de.Indexes, []int{1}) {
+		t.Errorf("got failed %v, wanted [1]", de.Indexes)
+	}
+	if want := []numbered{{12}, {34}}; !reflect.DeepEqual(nums, want) {
+		t.Errorf("got %+v, wanted %+v", nums, want)
+	}
+}
+
+func TestQueueFairDequeuer(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	conn, err := testDb.Conn(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	const qName = "TEST_QFAIR"
+	defer createQueue(ctx, t, conn, qName, "", "", "")()
+	q, err := goracle.NewQueue(ctx, conn, qName, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+	if err = q.SetEnqOptions(goracle.EnqOptions{Visibility: goracle.VisibleImmediate}); err != nil {
+		t.Fatal(err)
+	}
+	// The noisy tenant floods the queue before the quiet one.
+	noisy := make([]goracle.Message, 50)
+	for i := range noisy {
+		noisy[i] = goracle.Message{Raw: []byte(fmt.Sprintf("noisy-%d", i)), Correlation: "noisy"}
+	}
+	if err = q.Enqueue(noisy); err != nil {
+		t.Fatal(err)
+	}
+	if err = q.Enqueue([]goracle.Message{
+		{Raw: []byte("quiet-0"), Correlation: "quiet"},
+		{Raw: []byte("quiet-1"), Correlation: "quiet"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err = q.SetDeqOptions(goracle.DeqOptions{
+		Mode: goracle.DeqRemove, Navigation: goracle.NavNext,
+		Visibility: goracle.VisibleImmediate, Wait: 10,
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	F := goracle.NewFairDequeuer(q, "noisy", "quiet")
+	var got []string
+	for i := 0; i < 4; i++ {
+		var msg goracle.Message
+		ok, err := F.Dequeue(&msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !ok {
+			t.Fatalf("%d. no message", i)
+		}
+		got = append(got, string(msg.Raw))
+	}
+	if want := []string{"noisy-0", "quiet-0", "noisy-1", "quiet-1"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, wanted %q", got, want)
+	}
+
+	// The options in effect are restored.
+	D, err := q.DeqOptions()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if D.Correlation != "" || D.Navigation != goracle.NavNext || D.Wait != 10 {
+		t.Errorf("options are not restored: %+v", D)
+	}
+}
+
+func TestQueueWaitEmpty(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	conn, err := testDb.Conn(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	const qName = "TEST_QWAITEMPTY"
+	defer createQueue(ctx, t, conn, qName, "", "", "")()
+	q, err := goracle.NewQueue(ctx, conn, qName, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+	if err = q.SetEnqOptions(goracle.EnqOptions{Visibility: goracle.VisibleImmediate}); err != nil {
+		t.Fatal(err)
+	}
+	const all = 10
+	for i := 0; i < all; i++ {
+		if err = q.Enqueue([]goracle.Message{{Raw: []byte{byte(i)}}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Consume on another connection, slowly.
+	consumed := make(chan int, 1)
+	go func() {
+		cq, err := goracle.NewQueue(ctx, testDb, qName, "")
+		if err != nil {
+			t.Error(err)
+			consumed <- 0
+			return
+		}
+		defer cq.Close()
+		if err = cq.SetDeqOptions(goracle.DeqOptions{
+			Mode: goracle.DeqRemove, Navigation: goracle.NavFirst,
+			Visibility: goracle.VisibleImmediate, Wait: goracle.NoWait,
+		}); err != nil {
+			t.Error(err)
+			consumed <- 0
+			return
+		}
+		var n int
+		msgs := make([]goracle.Message, 1)
+		for n < all {
+			k, err := cq.Dequeue(msgs)
+			if err != nil {
+				t.Error(err)
+				break
+			}
+			n += k
+			time.Sleep(100 * time.Millisecond)
+		}
+		consumed <- n
+	}()
+
+	start := time.Now()
+	if err = q.WaitEmpty(ctx, 50*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	t.Logf("empty after %s", time.Since(start))
+	if n := <-consumed; n != all {
+		t.Errorf("consumed %d, wanted %d", n, all)
+	}
+	counts, err := q.Counts(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if counts.Ready != 0 {
+		t.Errorf("WaitEmpty returned with %d ready messages", counts.Ready)
+	}
+
+	// A canceled context ends the wait.
+	if err = q.Enqueue([]goracle.Message{{Raw: []byte("left")}}); err != nil {
+		t.Fatal(err)
+	}
+	shortCtx, shortCancel := context.WithTimeout(ctx, 200*time.Millisecond)
+	defer shortCancel()
+	if err = q.WaitEmpty(shortCtx, 50*time.Millisecond); err != context.DeadlineExceeded {
+		t.Errorf("got %v, wanted %v", err, context.DeadlineExceeded)
+	}
+}
+
+func TestQueueEnqueueStreamCommitInterval(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
+	conn, err := testDb.Conn(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	const qName = "TEST_QSTREAM"
+	defer createQueue(ctx, t, conn, qName, "", "", "")()
+	q, err := goracle.NewQueue(ctx, conn, qName, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+	q.SetMaxRawSize(8)
+
+	// The 150th message is too large: the stream fails mid-batch.
+	const all, bad, interval = 250, 150, 100
+	ch := make(chan goracle.Message)
+	go func() {
+		defer close(ch)
+		for i := 0; i < all; i++ {
+			msg := goracle.Message{Raw: []byte(fmt.Sprintf("%d", i))}
+			if i == bad {
+				msg.Raw = bytes.Repeat([]byte{'x'}, 16)
+			}
+			select {
+			case ch <- msg:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	var progress []int
+	n, err := q.EnqueueStream(ctx, ch, goracle.StreamOptions{
+		BatchSize: 30, CommitInterval: interval,
+		Progress: func(enqueued, committed int) {
+			if committed%interval != 0 || enqueued-committed > interval {
+				t.Errorf("progress enqueued=%d committed=%d", enqueued, committed)
+			}
+			progress = append(progress, committed)
+		},
+	})
+	t.Log(n, err)
+	if err == nil {
+		t.Fatal("wanted error for the oversized message")
+	}
+	if n != interval {
+		t.Errorf("got %d committed, wanted %d", n, interval)
+	}
+	if len(progress) == 0 || progress[len(progress)-1] != interval {
+		t.Errorf("progress: %v", progress)
+	}
+
+	// Only the committed messages are there, seen from another session.
+	cq, err := goracle.NewQueue(ctx, testDb, qName, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cq.Close()
+	if err = cq.SetDeqOptions(goracle.DeqOptions{
+		Mode: goracle.DeqRemove, Navigation: goracle.NavFirst,
+		Visibility: goracle.VisibleImmediate, Wait: goracle.NoWait,
+	}); err != nil {
+		t.Fatal(err)
+	}
+	msgs := make([]goracle.Message, 64)
+	var got int
+	for {
+		k, err := cq.Dequeue(msgs)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if k == 0 {
+			break
+		}
+		got += k
+	}
+	if got != interval {
+		t.Errorf("dequeued %d, wanted %d", got, interval)
+	}
+}
+
+func TestQueueEnqOptionsDeliveryMode(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	conn, err := testDb.Conn(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	const qName = "TEST_QENQDELIVERY"
+	defer createQueue(ctx, t, conn, qName, "", "", "")()
+	q, err := goracle.NewQueue(ctx, conn, qName, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+
+	E, err := q.EnqOptions()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if E.DeliveryMode != goracle.DeliverPersistent {
+		t.Errorf("default delivery mode: got %v, wanted DeliverPersistent", E.DeliveryMode)
+	}
+	for _, want := range []goracle.EnqOptions{
+		{Visibility: goracle.VisibleImmediate, DeliveryMode: goracle.DeliverBuffered},
+		{Visibility: goracle.VisibleOnCommit, DeliveryMode: goracle.DeliverPersistent},
+	} {
+		if err = q.SetEnqOptions(want); err != nil {
+			t.Fatal(err)
+		}
+		got, err := q.EnqOptions()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.DeliveryMode != want.DeliveryMode || got.Visibility != want.Visibility {
+			t.Errorf("got %+v, wanted %+v", got, want)
+		}
+	}
+}
+
+func TestQueueNextTranGrouping(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
+	conn, err := testDb.Conn(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	D := goracle.DeqOptions{
+		Mode: goracle.DeqRemove, Navigation: goracle.NavNextTran,
+		Visibility: goracle.VisibleImmediate, Wait: goracle.NoWait,
+	}
+
+	const qName = "TEST_QNEXTTRAN"
+	defer createQueue(ctx, t, conn, qName, "", "", "")()
+	q, err := goracle.NewQueue(ctx, conn, qName, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+	err = q.SetDeqOptions(D)
+	t.Log(err)
+	if errors.Cause(err) != goracle.ErrInvalidOptions {
+		t.Fatalf("got %+v, wanted ErrInvalidOptions", err)
+	}
+	if !strings.Contains(err.Error(), "grouping") {
+		t.Errorf("error %q does not mention the grouping", err)
+	}
+
+	const gName = "TEST_QNEXTTRANGRP"
+	defer createQueue(ctx, t, conn, gName, "", ", message_grouping=>DBMS_AQADM.TRANSACTIONAL", "")()
+	g, err := goracle.NewQueue(ctx, conn, gName, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer g.Close()
+	if err = g.SetDeqOptions(D); err != nil {
+		t.Fatalf("grouped queue: %+v", err)
+	}
+}
+
+// TestQueueReuseMessage reuses one Message in a producer loop - run it with -race.
+func TestQueueReuseMessage(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	conn, err := testDb.Conn(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	const qName = "TEST_QREUSEMSG"
+	defer createQueue(ctx, t, conn, qName, "", "", "")()
+	q, err := goracle.NewQueue(ctx, conn, qName, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+	if err = q.SetEnqOptions(goracle.EnqOptions{Visibility: goracle.VisibleImmediate}); err != nil {
+		t.Fatal(err)
+	}
+	q.SetCopyPayloads(true)
+
+	const all = 20
+	produced := make(chan error, 1)
+	go func() {
+		var msg goracle.Message
+		msg.Raw = make([]byte, 0, 16)
+		for i := 0; i < all; i++ {
+			msg.Raw = append(msg.Raw[:0], fmt.Sprintf("msg-%02d", i)...)
+			if _, err := q.EnqueueOne(msg); err != nil {
+				produced <- err
+				return
+			}
+		}
+		produced <- nil
+	}()
+	if err = <-produced; err != nil {
+		t.Fatal(err)
+	}
+
+	if err = q.SetDeqOptions(goracle.DeqOptions{
+		Mode: goracle.DeqRemove, Navigation: goracle.NavFirst,
+		Visibility: goracle.VisibleImmediate, Wait: goracle.NoWait,
+	}); err != nil {
+		t.Fatal(err)
+	}
+	seen := make(map[string]bool, all)
+	msgs := make([]goracle.Message, all)
+	for len(seen) < all {
+		n, err := q.Dequeue(msgs)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n == 0 {
+			break
+		}
+		for _, m := range msgs[:n] {
+			seen[string(m.Raw)] = true
+		}
+	}
+	for i := 0; i < all; i++ {
+		if k := fmt.Sprintf("msg-%02d", i); !seen[k] {
+			t.Errorf("%q is missing (got %v)", k, seen)
+		}
+	}
+}
+
+func TestQueueEnqueuedAfterEnqueue(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	conn, err := testDb.Conn(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	const qName = "TEST_QENQTIME"
+	defer createQueue(ctx, t, conn, qName, "", "", "")()
+	q, err := goracle.NewQueue(ctx, conn, qName, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+	q.SetEnqueuedLocation(time.Local)
+	q.SetEnqueuedLookup(true)
+	if err = q.SetEnqOptions(goracle.EnqOptions{Visibility: goracle.VisibleImmediate}); err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	msgs := []goracle.Message{{Raw: []byte("a")}, {Raw: []byte("b")}, {Raw: []byte("c")}}
+	if err = q.Enqueue(msgs); err != nil {
+		t.Fatal(err)
+	}
+	end := time.Now()
+	enqueued := make(map[[goracle.MsgIDLength]byte]time.Time, len(msgs))
+	for i, m := range msgs {
+		if m.Enqueued.IsZero() {
+			t.Fatalf("%d. Enqueued is not set", i)
+		}
+		if m.Enqueued.Before(start.Add(-time.Minute)) || m.Enqueued.After(end.Add(time.Minute)) {
+			t.Errorf("%d. %v is out of [%v, %v] (clock skew?)", i, m.Enqueued, start, end)
+		}
+		enqueued[m.MsgID] = m.Enqueued
+	}
+
+	// The dequeued messages report the same enqueue time.
+	got := make([]goracle.Message, len(msgs))
+	n, err := q.DequeueWith(got, goracle.DeqOptions{
+		Mode: goracle.DeqRemove, Navigation: goracle.NavFirst,
+		Visibility: goracle.VisibleImmediate, Wait: goracle.NoWait,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, m := range got[:n] {
+		if want := enqueued[m.MsgID]; !m.Enqueued.Equal(want.Truncate(time.Second)) {
+			t.Errorf("%x: dequeued with %v, enqueue reported %v", m.MsgID, m.Enqueued, want)
+		}
+	}
+}
+
+func TestQueueDequeueAck(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	conn, err := testDb.Conn(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	const qName = "TEST_QDEQACK"
+	defer createQueue(ctx, t, conn, qName, "", "", "")()
+	q, err := goracle.NewQueue(ctx, conn, qName, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+	if err = q.SetEnqOptions(goracle.EnqOptions{Visibility: goracle.VisibleImmediate}); err != nil {
+		t.Fatal(err)
+	}
+	const all = 5
+	msgs := make([]goracle.Message, all)
+	for i := range msgs {
+		msgs[i].Raw = []byte(fmt.Sprintf("%d", i))
+	}
+	if err = q.EnqueueOrdered(msgs); err != nil {
+		t.Fatal(err)
+	}
+	D := goracle.DeqOptions{
+		Mode: goracle.DeqRemove, Navigation: goracle.NavFirst,
+		Visibility: goracle.VisibleImmediate, Wait: goracle.NoWait,
+	}
+	if err = q.SetDeqOptions(D); err != nil {
+		t.Fatal(err)
+	}
+
+	var calls int
+	handled := make(map[string]bool)
+	errBoom := errors.New("boom")
+	n, err := q.DequeueAck(ctx, all, func(m goracle.Message) error {
+		if calls++; calls == 3 {
+			return errBoom
+		}
+		handled[string(m.Raw)] = true
+		return nil
+	})
+	t.Log(n, err)
+	if errors.Cause(err) != errBoom {
+		t.Errorf("got %+v, wanted the handler's error", err)
+	}
+	if n != 2 || len(handled) != 2 {
+		t.Errorf("acknowledged %d (handled %v), wanted 2", n, handled)
+	}
+	if got, err := q.DeqOptions(); err != nil {
+		t.Fatal(err)
+	} else if got.Mode != D.Mode || got.Visibility != D.Visibility {
+		t.Errorf("the dequeue options are not restored: got %+v, wanted %+v", got, D)
+	}
+
+	// The rest is delivered again.
+	rest := make([]goracle.Message, all)
+	if n, err = q.Dequeue(rest); err != nil {
+		t.Fatal(err)
+	}
+	if n != all-2 {
+		t.Errorf("redelivered %d, wanted %d", n, all-2)
+	}
+	for _, m := range rest[:n] {
+		if handled[string(m.Raw)] {
+			t.Errorf("acknowledged message %q is delivered again", m.Raw)
+		}
+	}
+}
+
+func TestQueueWatchExpired(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 120*time.Second)
+	defer cancel()
+	conn, err := testDb.Conn(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	const qName = "TEST_QEXPIRED"
+	defer createQueue(ctx, t, conn, qName, "", "", "")()
+	q, err := goracle.NewQueue(ctx, conn, qName, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+	eqName, err := q.ExceptionQueue(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Log("exception queue:", eqName)
+	if err = goracle.StartQueue(ctx, conn, eqName, false, true); err != nil {
+		t.Fatal(err)
+	}
+
+	expired := make(chan goracle.Message, 1)
+	w, err := q.WatchExpired(ctx, func(m goracle.Message) {
+		select {
+		case expired <- m:
+		default:
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	if err = q.SetEnqOptions(goracle.EnqOptions{Visibility: goracle.VisibleImmediate}); err != nil {
+		t.Fatal(err)
+	}
+	msgID, err := q.EnqueueOne(goracle.Message{Raw: []byte("expiring"), Expiration: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The queue monitor moves the message to the exception queue some time after its expiration.
+	select {
+	case m := <-expired:
+		t.Logf("expired: %+v", m)
+		if m.OriginalMsgID != msgID {
+			t.Errorf("got OriginalMsgID %x, wanted %x", m.OriginalMsgID, msgID)
+		}
+		if m.State != goracle.MsgStateExpired {
+			t.Errorf("got state %v, wanted MsgStateExpired", m.State)
+		}
+		if string(m.Raw) != "expiring" {
+			t.Errorf("got payload %q", m.Raw)
+		}
+	case <-ctx.Done():
+		t.Fatal("the expired message is not reported")
+	}
+	if err = w.Close(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestQueueIsValid(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	conn, err := testDb.Conn(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	const qName = "TEST_QISVALID"
+	defer createQueue(ctx, t, conn, qName, "", "", "")()
+	q, err := goracle.NewQueue(ctx, conn, qName, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !q.IsValid() {
+		t.Fatal("new queue is not valid")
+	}
+	if err = q.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if q.IsValid() {
+		t.Error("closed queue is valid")
+	}
+}
+
+func TestQueuePayloadTypeBatch(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
+	conn, err := testDb.Conn(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	const qName, typName = "TEST_QPAYLOADTYPE", "TEST_QPAYLOADTYPE_TYP"
+	defer createQueueType(ctx, t, conn, typName, "id NUMBER(9)")()
+	defer createQueue(ctx, t, conn, qName, typName, "", "")()
+	q, err := goracle.NewQueue(ctx, conn, qName, typName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+	typ := q.PayloadType()
+	if typ == nil {
+		t.Fatal("PayloadType is nil for an object queue")
+	}
+	if q.PayloadType() != typ {
+		t.Error("PayloadType is not cached")
+	}
+
+	const all = 1000
+	msgs := make([]goracle.Message, all)
+	for i := range msgs {
+		obj, err := typ.NewObject()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer obj.Close()
+		if err = obj.Set("ID", int64(i)); err != nil {
+			t.Fatal(err)
+		}
+		msgs[i].Object = obj
+	}
+	if err = q.Enqueue(msgs); err != nil {
+		t.Fatal(err)
+	}
+	counts, err := q.Counts(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if counts.Ready != all {
+		t.Errorf("got %d ready messages, wanted %d", counts.Ready, all)
+	}
+	if err = q.Rollback(); err != nil {
+		t.Fatal(err)
+	}
+
+	raw, err := goracle.NewQueue(ctx, conn, qName, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer raw.Close()
+	if raw.PayloadType() != nil {
+		t.Error("PayloadType is not nil for a RAW queue")
+	}
+}
+
+func TestQueueBrowseVsConfirm(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	conn, err := testDb.Conn(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	const qName = "TEST_QCONFIRM"
+	defer createQueue(ctx, t, conn, qName, "", "", "")()
+	q, err := goracle.NewQueue(ctx, conn, qName, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+	if err = q.SetEnqOptions(goracle.EnqOptions{Visibility: goracle.VisibleImmediate}); err != nil {
+		t.Fatal(err)
+	}
+	msgID, err := q.EnqueueOne(goracle.Message{Raw: []byte("keep me")})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	browse := goracle.DeqOptions{
+		Mode: goracle.DeqBrowse, Navigation: goracle.NavFirst,
+		Visibility: goracle.VisibleImmediate, Wait: goracle.NoWait,
+	}
+	msgs := make([]goracle.Message, 1)
+	// Browsing is not destructive: the message is there for the second time, too.
+	for i := 0; i < 2; i++ {
+		n, err := q.DequeueWith(msgs, browse)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != 1 || msgs[0].MsgID != msgID || string(msgs[0].Raw) != "keep me" {
+			t.Fatalf("%d. browse: got %d %x %q", i, n, msgs[0].MsgID, msgs[0].Raw)
+		}
+	}
+
+	// Confirming removes the message, without delivering its payload.
+	n, err := q.DequeueWith(msgs, goracle.DeqOptions{
+		Mode: goracle.DeqConfirm, Navigation: goracle.NavFirst, MsgID: string(msgID[:]),
+		Visibility: goracle.VisibleImmediate, Wait: goracle.NoWait,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 || len(msgs[0].Raw) != 0 {
+		t.Fatalf("confirm: got %d %q, wanted the message without payload", n, msgs[0].Raw)
+	}
+	if n, err = q.DequeueWith(msgs, browse); err != nil {
+		t.Fatal(err)
+	} else if n != 0 {
+		t.Errorf("the confirmed message is still there: %x", msgs[0].MsgID)
+	}
+}
+
+func TestQueueRouter(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	conn, err := testDb.Conn(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	const rawName, objName, typName = "TEST_QROUTE_RAW", "TEST_QROUTE_OBJ", "TEST_QROUTE_TYP"
+	defer createQueueType(ctx, t, conn, typName, "id NUMBER(9)")()
+	defer createQueue(ctx, t, conn, rawName, "", "", "")()
+	defer createQueue(ctx, t, conn, objName, typName, "", "")()
+	rawQ, err := goracle.NewQueue(ctx, conn, rawName, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rawQ.Close()
+	objQ, err := goracle.NewQueue(ctx, conn, objName, typName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer objQ.Close()
+	D := goracle.DeqOptions{
+		Mode: goracle.DeqRemove, Navigation: goracle.NavFirst,
+		Visibility: goracle.VisibleImmediate, Wait: goracle.NoWait,
+	}
+	for _, q := range []*goracle.Queue{rawQ, objQ} {
+		if err = q.SetEnqOptions(goracle.EnqOptions{Visibility: goracle.VisibleImmediate}); err != nil {
+			t.Fatal(err)
+		}
+		if err = q.SetDeqOptions(D); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var msgs []goracle.Message
+	for i := 0; i < 6; i++ {
+		if i%2 == 0 {
+			msgs = append(msgs, goracle.Message{Raw: []byte(fmt.Sprintf("raw-%d", i))})
+			continue
+		}
+		obj, err := objQ.PayloadType().NewObject()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer obj.Close()
+		if err = obj.Set("ID", int64(i)); err != nil {
+			t.Fatal(err)
+		}
+		msgs = append(msgs, goracle.Message{Object: obj})
+	}
+	R := goracle.NewPayloadRouter(rawQ, objQ)
+	if err = R.Enqueue(msgs); err != nil {
+		t.Fatal(err)
+	}
+	for i, m := range msgs {
+		if m.MsgID == ([goracle.MsgIDLength]byte{}) {
+			t.Errorf("%d. MsgID is not set", i)
+		}
+		if m.DeliveryMode != goracle.DeliverPersistent {
+			t.Errorf("%d. DeliveryMode is %v, wanted DeliverPersistent", i, m.DeliveryMode)
+		}
+	}
+
+	got := make([]goracle.Message, 10)
+	n, err := rawQ.Dequeue(got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 3 {
+		t.Errorf("RAW queue got %d messages, wanted 3", n)
+	}
+	for _, m := range got[:n] {
+		if !strings.HasPrefix(string(m.Raw), "raw-") {
+			t.Errorf("RAW queue got %q", m.Raw)
+		}
+	}
+	if n, err = objQ.Dequeue(got); err != nil {
+		t.Fatal(err)
+	}
+	if n != 3 {
+		t.Errorf("object queue got %d messages, wanted 3", n)
+	}
+	for _, m := range got[:n] {
+		if m.Object == nil {
+			t.Error("object queue got a message without Object")
+		} else {
+			m.Object.Close()
+		}
+	}
+
+	// A message without a target queue fails the whole batch.
+	if err = goracle.NewPayloadRouter(objQ).Enqueue([]goracle.Message{{Raw: []byte("lost")}}); errors.Cause(err) != goracle.ErrNoRoute {
+		t.Errorf("got %+v, wanted ErrNoRoute", err)
+	}
+}
+
+func TestQueueLastMsgIDResume(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	conn, err := testDb.Conn(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	const qName = "TEST_QLASTMSGID"
+	defer createQueue(ctx, t, conn, qName, "", "", "")()
+	q, err := goracle.NewQueue(ctx, conn, qName, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+	if q.LastMsgID() != ([goracle.MsgIDLength]byte{}) {
+		t.Error("LastMsgID is set before any dequeue")
+	}
+	if err = q.SetEnqOptions(goracle.EnqOptions{Visibility: goracle.VisibleImmediate}); err != nil {
+		t.Fatal(err)
+	}
+	const all = 5
+	msgs := make([]goracle.Message, all)
+	for i := range msgs {
+		msgs[i].Raw = []byte{byte('0' + i)}
+	}
+	if err = q.EnqueueOrdered(msgs); err != nil {
+		t.Fatal(err)
+	}
+
+	browse := goracle.DeqOptions{
+		Mode: goracle.DeqBrowse, Navigation: goracle.NavFirst,
+		Visibility: goracle.VisibleImmediate, Wait: goracle.NoWait,
+	}
+	one := make([]goracle.Message, 1)
+	for i := 0; i < 3; i++ {
+		if n, err := q.DequeueWith(one, browse); err != nil {
+			t.Fatal(err)
+		} else if n != 1 {
+			t.Fatalf("%d. browse: no message", i)
+		}
+		if got := q.LastMsgID(); got != one[0].MsgID {
+			t.Errorf("%d. LastMsgID is %x, wanted %x", i, got, one[0].MsgID)
+		}
+		browse.Navigation = goracle.NavNext
+	}
+	checkpoint := q.LastMsgID()
+	if checkpoint != msgs[2].MsgID {
+		t.Fatalf("checkpoint is %x, wanted the 3rd message %x", checkpoint, msgs[2].MsgID)
+	}
+
+	// Resume on a new Queue, as after a restart.
+	r, err := goracle.NewQueue(ctx, conn, qName, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	resume := browse
+	resume.Navigation, resume.MsgID = goracle.NavFirst, string(checkpoint[:])
+	if n, err := r.DequeueWith(one, resume); err != nil {
+		t.Fatal(err)
+	} else if n != 1 || one[0].MsgID != checkpoint {
+		t.Fatalf("resume: got %d %x, wanted the checkpoint", n, one[0].MsgID)
+	}
+	resume.Navigation, resume.MsgID = goracle.NavNext, ""
+	var rest []string
+	for {
+		n, err := r.DequeueWith(one, resume)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n == 0 {
+			break
+		}
+		rest = append(rest, string(one[0].Raw))
+	}
+	if fmt.Sprint(rest) != "[3 4]" {
+		t.Errorf("resumed scan got %q, wanted [3 4]", rest)
+	}
+}
+
+func TestQueueCompression(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	conn, err := testDb.Conn(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	const qName = "TEST_QCOMPRESS"
+	defer createQueue(ctx, t, conn, qName, "", "", "")()
+	q, err := goracle.NewQueue(ctx, conn, qName, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+	if err = q.SetEnqOptions(goracle.EnqOptions{Visibility: goracle.VisibleImmediate}); err != nil {
+		t.Fatal(err)
+	}
+	q.SetDequeueHeaders(true)
+	// Larger than the RAW limit, but compresses well.
+	var buf bytes.Buffer
+	buf.WriteString("[")
+	for i := 0; buf.Len() < 2*goracle.MaxRawPayloadSize; i++ {
+		fmt.Fprintf(&buf, `{"id":%d,"name":"item","tags":["a","b","c"]},`, i)
+	}
+	buf.WriteString("{}]")
+	large := buf.Bytes()
+
+	deq := goracle.DeqOptions{
+		Mode: goracle.DeqRemove, Navigation: goracle.NavFirst,
+		Visibility: goracle.VisibleImmediate, Wait: goracle.NoWait,
+	}
+	msgs := make([]goracle.Message, 1)
+	for _, compress := range []bool{false, true} {
+		if compress {
+			q.SetCompression(1024)
+		}
+		want := []goracle.Message{
+			{Raw: []byte(strings.Repeat("small ", 100))},
+			{Raw: large[:goracle.MaxRawPayloadSize-100], Headers: map[string]string{"content-type": "application/json"}},
+		}
+		if compress {
+			want = append(want, goracle.Message{Raw: large})
+		}
+		for i, m := range want {
+			if _, err = q.EnqueueOne(m); err != nil {
+				t.Fatalf("compress=%t %d. %+v", compress, i, err)
+			}
+			if n, err := q.DequeueWith(msgs, deq); err != nil {
+				t.Fatal(err)
+			} else if n != 1 {
+				t.Fatalf("compress=%t %d. no message", compress, i)
+			}
+			if !bytes.Equal(msgs[0].Raw, m.Raw) || !reflect.DeepEqual(msgs[0].Headers, m.Headers) {
+				t.Errorf("compress=%t %d. got %d bytes %q, wanted %d bytes %q",
+					compress, i, len(msgs[0].Raw), msgs[0].Headers, len(m.Raw), m.Headers)
+			}
+		}
+		if !compress {
+			if _, err = q.EnqueueOne(goracle.Message{Raw: large}); errors.Cause(err) != goracle.ErrPayloadTooLarge {
+				t.Errorf("uncompressed large payload: got %+v, wanted ErrPayloadTooLarge", err)
+			}
+		}
+	}
+}
+
+func TestQueueInvalidName(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	for _, tc := range []struct{ queue, typ string }{
+		{queue: ""},
+		{queue: "A.B.C"},
+		{queue: "OWNER."},
+		{queue: ".QUEUE"},
+		{queue: "1QUEUE"},
+		{queue: "MY QUEUE"},
+		{queue: `"OWNER.QUEUE`},
+		{queue: `""."QUEUE"`},
+		{queue: "OWNER.QUEUE", typ: "OWNER..TYPE"},
+		{queue: "OWNER.QUEUE", typ: "OWNER.TYPE;DROP"},
+	} {
+		if _, err := goracle.NewQueue(ctx, testDb, tc.queue, tc.typ); errors.Cause(err) != goracle.ErrInvalidName {
+			t.Errorf("%q, %q: got %v, wanted ErrInvalidName", tc.queue, tc.typ, err)
+		}
+	}
+}
+
+func TestQueueSchemaQualified(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	conn, err := testDb.Conn(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	const qName, typName = "TEST_QSCHEMA", "TEST_QSCHEMA_TYP"
+	defer createQueueType(ctx, t, conn, typName, "f_vc20 VARCHAR2(20)")()
+	defer createQueue(ctx, t, conn, qName, typName, "", "")()
+	var owner string
+	if err = conn.QueryRowContext(ctx, "SELECT USER FROM DUAL").Scan(&owner); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, names := range [][2]string{
+		{owner + "." + qName, owner + "." + typName},
+		{strings.ToLower(owner + "." + qName), strings.ToLower(owner + "." + typName)},
+		{`"` + owner + `"."` + qName + `"`, `"` + owner + `"."` + typName + `"`},
+	} {
+		q, err := goracle.NewQueue(ctx, conn, names[0], names[1])
+		if err != nil {
+			t.Fatalf("%q: %+v", names, err)
+		}
+		if got, want := q.PayloadType().FullName(), owner+"."+typName; got != want {
+			t.Errorf("%q: payload type is %q, wanted %q", names, got, want)
+		}
+		if qOwner, _, err := q.QueueTable(ctx); err != nil {
+			t.Errorf("%q: %+v", names, err)
+		} else if qOwner != owner {
+			t.Errorf("%q: queue owner is %q, wanted %q", names, qOwner, owner)
+		}
+		obj, err := q.PayloadType().NewObject()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = obj.Set("F_VC20", "qualified"); err != nil {
+			t.Fatal(err)
+		}
+		if err = q.SetEnqOptions(goracle.EnqOptions{Visibility: goracle.VisibleImmediate}); err != nil {
+			t.Fatal(err)
+		}
+		_, err = q.EnqueueOne(goracle.Message{Object: obj})
+		obj.Close()
+		if err != nil {
+			t.Fatalf("%q: %+v", names, err)
+		}
+		msgs := make([]goracle.Message, 1)
+		if n, err := q.DequeueWith(msgs, goracle.DeqOptions{
+			Mode: goracle.DeqRemove, Navigation: goracle.NavFirst,
+			Visibility: goracle.VisibleImmediate, Wait: goracle.NoWait,
+		}); err != nil {
+			t.Fatal(err)
+		} else if n != 1 {
+			t.Errorf("%q: no message dequeued", names)
+		} else {
+			msgs[0].Object.Close()
+		}
+		q.Close()
+	}
+}
+
+func TestQueueDeqByPriority(t *testing.T) {
+	if got, want := goracle.DeqByPriority(2), "tab.priority <= 2"; got != want {
+		t.Errorf("got %q, wanted %q", got, want)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	conn, err := testDb.Conn(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	const qName = "TEST_QDEQPRIO"
+	defer createQueue(ctx, t, conn, qName, "", ", sort_list=>'PRIORITY,ENQ_TIME'", "")()
+	q, err := goracle.NewQueue(ctx, conn, qName, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+	if err = q.SetEnqOptions(goracle.EnqOptions{Visibility: goracle.VisibleImmediate}); err != nil {
+		t.Fatal(err)
+	}
+	for _, prio := range []int32{4, 1, 3, 2, 5, 1} {
+		if _, err = q.EnqueueOne(goracle.Message{Raw: []byte(fmt.Sprint(prio)), Priority: prio}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	deqAll := func(D goracle.DeqOptions) []string {
+		var got []string
+		msgs := make([]goracle.Message, 1)
+		for {
+			n, err := q.DequeueWith(msgs, D)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if n == 0 {
+				return got
+			}
+			got = append(got, string(msgs[0].Raw))
+		}
+	}
+	D := goracle.DeqOptions{
+		Mode: goracle.DeqRemove, Navigation: goracle.NavFirst,
+		Visibility: goracle.VisibleImmediate, Wait: goracle.NoWait,
+		Condition: goracle.DeqByPriority(2),
+	}
+	if got, want := deqAll(D), []string{"1", "1", "2"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("high priority: got %q, wanted %q", got, want)
+	}
+	D.Condition = ""
+	if got, want := deqAll(D), []string{"3", "4", "5"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("the rest: got %q, wanted %q", got, want)
+	}
+}
+
+func TestQueueHead(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	conn, err := testDb.Conn(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	const qName = "TEST_QHEAD"
+	defer createQueue(ctx, t, conn, qName, "", "", "")()
+	q, err := goracle.NewQueue(ctx, conn, qName, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+	if head, err := q.Head(ctx); err != nil {
+		t.Fatal(err)
+	} else if head != nil {
+		t.Fatalf("empty queue has head %+v", head)
+	}
+
+	if err = q.SetEnqOptions(goracle.EnqOptions{Visibility: goracle.VisibleImmediate}); err != nil {
+		t.Fatal(err)
+	}
+	msgs := []goracle.Message{
+		{Raw: []byte("first"), Correlation: "C1"},
+		{Raw: []byte("second"), Correlation: "C2"},
+	}
+	if err = q.EnqueueOrdered(msgs); err != nil {
+		t.Fatal(err)
+	}
+	D := goracle.DeqOptions{
+		Mode: goracle.DeqRemove, Navigation: goracle.NavFirst,
+		Visibility: goracle.VisibleImmediate, Wait: goracle.NoWait,
+	}
+	if err = q.SetDeqOptions(D); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		head, err := q.Head(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if head == nil {
+			t.Fatalf("%d. no head", i)
+		}
+		if head.MsgID != msgs[0].MsgID || head.Correlation != "C1" {
+			t.Errorf("%d. head is %q (%x), wanted the oldest C1 (%x)", i, head.Correlation, head.MsgID, msgs[0].MsgID)
+		}
+		if head.Enqueued.IsZero() {
+			t.Errorf("%d. head has no Enqueued time", i)
+		}
+	}
+	if got, err := q.DeqOptions(); err != nil {
+		t.Fatal(err)
+	} else if got.Mode != D.Mode || got.Wait != D.Wait {
+		t.Errorf("Head changed the dequeue options: got %+v, wanted %+v", got, D)
+	}
+
+	// Head is non-destructive: both messages are dequeued.
+	got := make([]goracle.Message, 2)
+	if n, err := q.Dequeue(got); err != nil {
+		t.Fatal(err)
+	} else if n != 2 {
+		t.Errorf("dequeued %d messages after Head, wanted 2", n)
+	}
+}
+
+func TestQueueDedupByCorrelation(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	conn, err := testDb.Conn(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	const qName = "TEST_QDEDUP"
+	defer createQueue(ctx, t, conn, qName, "", "", "")()
+	q, err := goracle.NewQueue(ctx, conn, qName, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+	q.SetDedupByCorrelation(true)
+	if err = q.SetEnqOptions(goracle.EnqOptions{Visibility: goracle.VisibleImmediate}); err != nil {
+		t.Fatal(err)
+	}
+
+	first, err := q.EnqueueOne(goracle.Message{Raw: []byte("request"), Correlation: "REQ-1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The retry is a no-op, returning the MsgID of the first enqueue.
+	retried, err := q.EnqueueOne(goracle.Message{Raw: []byte("request (retried)"), Correlation: "REQ-1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if retried != first {
+		t.Errorf("retry got MsgID %x, wanted the first %x", retried, first)
+	}
+	batch := []goracle.Message{
+		{Raw: []byte("other"), Correlation: "REQ-2"},
+		{Raw: []byte("request"), Correlation: "REQ-1"},
+		{Raw: []byte("other"), Correlation: "REQ-2"},
+		{Raw: []byte("no key")},
+	}
+	if err = q.Enqueue(batch); err != nil {
+		t.Fatal(err)
+	}
+	if batch[1].MsgID != first {
+		t.Errorf("batch duplicate got MsgID %x, wanted %x", batch[1].MsgID, first)
+	}
+	if batch[2].MsgID != batch[0].MsgID {
+		t.Errorf("in-batch duplicate got MsgID %x, wanted %x", batch[2].MsgID, batch[0].MsgID)
+	}
+	for _, i := range []int{0, 2, 3} {
+		if batch[i].DeliveryMode != goracle.DeliverPersistent {
+			t.Errorf("%d. DeliveryMode is %v, wanted DeliverPersistent", i, batch[i].DeliveryMode)
+		}
+	}
+
+	msgs := make([]goracle.Message, 10)
+	n, err := q.DequeueWith(msgs, goracle.DeqOptions{
+		Mode: goracle.DeqRemove, Navigation: goracle.NavFirst,
+		Visibility: goracle.VisibleImmediate, Wait: goracle.NoWait,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, m := range msgs[:n] {
+		got = append(got, string(m.Raw))
+	}
+	sort.Strings(got)
+	if want := []string{"no key", "other", "request"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, wanted %q", got, want)
+	}
+}
+
+func TestQueueDequeueNewest(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	conn, err := testDb.Conn(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	const qName = "TEST_QLIFO"
+	defer createQueue(ctx, t, conn, qName, "", "", "")()
+	q, err := goracle.NewQueue(ctx, conn, qName, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+	if err = q.SetEnqOptions(goracle.EnqOptions{Visibility: goracle.VisibleImmediate}); err != nil {
+		t.Fatal(err)
+	}
+	D := goracle.DeqOptions{
+		Mode: goracle.DeqRemove, Navigation: goracle.NavFirst,
+		Visibility: goracle.VisibleImmediate, Wait: goracle.NoWait,
+	}
+	if err = q.SetDeqOptions(D); err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{"1", "2", "3"} {
+		if _, err = q.EnqueueOne(goracle.Message{Raw: []byte(s)}); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(10 * time.Millisecond) // distinct enqueue times
+	}
+
+	var got []string
+	var msg goracle.Message
+	for {
+		ok, err := q.DequeueNewest(ctx, &msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !ok {
+			break
+		}
+		got = append(got, string(msg.Raw))
+	}
+	if want := []string{"3", "2", "1"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, wanted newest first %q", got, want)
+	}
+	if opts, err := q.DeqOptions(); err != nil {
+		t.Fatal(err)
+	} else if opts.MsgID != "" || opts.Wait != D.Wait {
+		t.Errorf("DequeueNewest changed the dequeue options: %+v", opts)
+	}
+}
+
+func TestQueueForward(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	conn, err := testDb.Conn(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	const srcName, dstName = "TEST_QFWD_SRC", "TEST_QFWD_DST"
+	defer createQueue(ctx, t, conn, srcName, "", "", "")()
+	defer createQueue(ctx, t, conn, dstName, "", "", "")()
+	src, err := goracle.NewQueue(ctx, conn, srcName, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer src.Close()
+	dst, err := goracle.NewQueue(ctx, conn, dstName, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dst.Close()
+	D := goracle.DeqOptions{
+		Mode: goracle.DeqRemove, Navigation: goracle.NavFirst,
+		Visibility: goracle.VisibleImmediate, Wait: goracle.NoWait,
+	}
+	for _, q := range []*goracle.Queue{src, dst} {
+		if err = q.SetEnqOptions(goracle.EnqOptions{Visibility: goracle.VisibleImmediate}); err != nil {
+			t.Fatal(err)
+		}
+		if err = q.SetDeqOptions(D); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err = src.EnqueueOne(goracle.Message{Raw: []byte("order")}); err != nil {
+		t.Fatal(err)
+	}
+	count := func(q *goracle.Queue) int {
+		t.Helper()
+		c, err := q.Counts(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return c.Ready
+	}
+
+	// A failing transform rolls back the dequeue, and enqueues nothing.
+	errTransform := errors.New("transform failed")
+	if ok, err := goracle.Forward(ctx, src, dst, func(m goracle.Message) (goracle.Message, error) {
+		return m, errTransform
+	}); ok || errors.Cause(err) != errTransform {
+		t.Fatalf("got %t, %+v, wanted the transform error", ok, err)
+	}
+	if n := count(dst); n != 0 {
+		t.Errorf("dst has %d messages after the failed forward", n)
+	}
+	if n := count(src); n != 1 {
+		t.Errorf("src has %d messages after the failed forward, wanted 1", n)
+	}
+
+	if ok, err := goracle.Forward(ctx, src, dst, func(m goracle.Message) (goracle.Message, error) {
+		return goracle.Message{Raw: append([]byte("processed "), m.Raw...)}, nil
+	}); err != nil || !ok {
+		t.Fatalf("got %t, %+v", ok, err)
+	}
+	msgs := make([]goracle.Message, 2)
+	if n, err := dst.Dequeue(msgs); err != nil {
+		t.Fatal(err)
+	} else if n != 1 || string(msgs[0].Raw) != "processed order" {
+		t.Errorf("dst got %d messages (%q), wanted 1 processed", n, msgs[0].Raw)
+	}
+	if n := count(src); n != 0 {
+		t.Errorf("src has %d messages after the forward", n)
+	}
+	if ok, err := goracle.Forward(ctx, src, dst, nil); err != nil || ok {
+		t.Errorf("forward from empty queue: got %t, %+v", ok, err)
+	}
+
+	conn2, err := testDb.Conn(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn2.Close()
+	other, err := goracle.NewQueue(ctx, conn2, dstName, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer other.Close()
+	if _, err = goracle.Forward(ctx, src, other, nil); errors.Cause(err) != goracle.ErrInvalidOptions {
+		t.Errorf("forward across connections: got %+v, wanted ErrInvalidOptions", err)
+	}
+}
+
+func TestQueueEnqueueMixedVisibility(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	conn, err := testDb.Conn(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn2, err := testDb.Conn(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn2.Close()
+
+	const qName = "TEST_QMIXEDVIS"
+	defer createQueue(ctx, t, conn, qName, "", "", "")()
+	q, err := goracle.NewQueue(ctx, conn, qName, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+	observer, err := goracle.NewQueue(ctx, conn2, qName, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer observer.Close()
+	ready := func() int {
+		t.Helper()
+		c, err := observer.Counts(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return c.Ready
+	}
+
+	msgs := []goracle.Message{
+		{Raw: []byte("audit 1"), Visibility: goracle.VisibleImmediate},
+		{Raw: []byte("domain 1")},
+		{Raw: []byte("domain 2")},
+		{Raw: []byte("audit 2"), Visibility: goracle.VisibleImmediate},
+	}
+	if err = q.EnqueueWith(msgs, goracle.EnqOptions{Visibility: goracle.VisibleOnCommit}); err != nil {
+		t.Fatal(err)
+	}
+	for i, m := range msgs {
+		if m.MsgID == ([goracle.MsgIDLength]byte{}) {
+			t.Errorf("%d. has no MsgID", i)
+		}
+	}
+	if n := ready(); n != 2 {
+		t.Errorf("before commit, another session sees %d messages, wanted the 2 immediate ones", n)
+	}
+	if E, err := q.EnqOptions(); err != nil {
+		t.Fatal(err)
+	} else if E.Visibility != goracle.VisibleOnCommit {
+		// EnqueueWith restores the options of the Queue (the default is VisibleOnCommit).
+		t.Errorf("visibility after EnqueueWith is %v", E.Visibility)
+	}
+	if err = q.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if n := ready(); n != len(msgs) {
+		t.Errorf("after commit, another session sees %d messages, wanted %d", n, len(msgs))
+	}
+}
+
+func TestQueueLastError(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	conn, err := testDb.Conn(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	const qName = "TEST_QLASTERR"
+	defer createQueue(ctx, t, conn, qName, "", "", "")()
+	q, err := goracle.NewQueue(ctx, conn, qName, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+	if err = q.LastError(); err != nil {
+		t.Errorf("new queue has LastError %v", err)
+	}
+	if err = q.SetEnqOptions(goracle.EnqOptions{Visibility: goracle.VisibleImmediate}); err != nil {
+		t.Fatal(err)
+	}
+
+	// ORA-25207: enqueue failed, queue is disabled from enqueueing
+	if err = goracle.StopQueue(ctx, conn, qName, true, false, true); err != nil {
+		t.Fatal(err)
+	}
+	_, enqErr := q.EnqueueOne(goracle.Message{Raw: []byte("fails")})
+	if enqErr == nil {
+		t.Fatal("enqueue to a stopped queue succeeded")
+	}
+	if err = q.LastError(); err == nil || err.Error() != enqErr.Error() {
+		t.Errorf("LastError is %v, wanted %v", err, enqErr)
+	}
+
+	if err = goracle.StartQueue(ctx, conn, qName, true, true); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = q.EnqueueOne(goracle.Message{Raw: []byte("succeeds")}); err != nil {
+		t.Fatalf("enqueue after the failure: %+v", err)
+	}
+	if err = q.LastError(); err != nil {
+		t.Errorf("after a successful enqueue, LastError is %v", err)
+	}
+	msgs := make([]goracle.Message, 1)
+	if _, err = q.DequeueWith(msgs, goracle.DeqOptions{
+		Mode: goracle.DeqRemove, Navigation: goracle.NavFirst,
+		Visibility: goracle.VisibleImmediate, Wait: goracle.NoWait,
+	}); err != nil {
+		t.Fatalf("dequeue after the failure: %+v", err)
+	}
+	if err = q.LastError(); err != nil {
+		t.Errorf("after a successful dequeue, LastError is %v", err)
+	}
+}
+
+func TestQueueEnqueueReader(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 120*time.Second)
+	defer cancel()
+	conn, err := testDb.Conn(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	const qName, typName = "TEST_QREADER", "TEST_QREADER_TYP"
+	defer createQueueType(ctx, t, conn, typName, "name VARCHAR2(100), content BLOB")()
+	defer createQueue(ctx, t, conn, qName, typName, "", "")()
+	q, err := goracle.NewQueue(ctx, conn, qName, typName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+	if err = q.SetEnqOptions(goracle.EnqOptions{Visibility: goracle.VisibleImmediate}); err != nil {
+		t.Fatal(err)
+	}
+
+	// A pseudo-random 10MB payload, generated on the fly.
+	const size = 10 << 20
+	payload := func() io.Reader {
+		return io.LimitReader(rand.New(rand.NewSource(42)), size)
+	}
+	sum := func(r io.Reader) ([sha256.Size]byte, int64) {
+		h := sha256.New()
+		n, err := io.Copy(h, r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var s [sha256.Size]byte
+		copy(s[:], h.Sum(nil))
+		return s, n
+	}
+	want, _ := sum(payload())
+
+	for _, enqSize := range []int64{size, -1} {
+		if err = q.EnqueueReader(ctx, payload(), enqSize); err != nil {
+			t.Fatalf("size=%d: %+v", enqSize, err)
+		}
+		msgs := make([]goracle.Message, 1)
+		n, err := q.DequeueWith(msgs, goracle.DeqOptions{
+			Mode: goracle.DeqRemove, Navigation: goracle.NavFirst,
+			Visibility: goracle.VisibleImmediate, Wait: goracle.NoWait,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != 1 {
+			t.Fatalf("size=%d: no message", enqSize)
+		}
+		got, length := sum(msgs[0].PayloadReader())
+		msgs[0].Object.Close()
+		if length != size || got != want {
+			t.Errorf("size=%d: read %d bytes (%x), wanted %d (%x)", enqSize, length, got, size, want)
+		}
+	}
+
+	if err = q.EnqueueReader(ctx, io.LimitReader(payload(), 100), 200); errors.Cause(err) != io.ErrUnexpectedEOF {
+		t.Errorf("short reader: got %+v, wanted io.ErrUnexpectedEOF", err)
+	}
+
+	const rawQName = "TEST_QREADER_RAW"
+	defer createQueue(ctx, t, conn, rawQName, "", "", "")()
+	rawQ, err := goracle.NewQueue(ctx, conn, rawQName, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rawQ.Close()
+	if err = rawQ.EnqueueReader(ctx, payload(), size); errors.Cause(err) != goracle.ErrWrongPayloadType {
+		t.Errorf("RAW queue: got %+v, wanted ErrWrongPayloadType", err)
+	}
+}
+
+func TestQueueCountMatching(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	conn, err := testDb.Conn(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	const qName, typName = "TEST_QCOUNTMATCH", "TEST_QCOUNTMATCH_TYP"
+	defer createQueueType(ctx, t, conn, typName, "amount NUMBER")()
+	defer createQueue(ctx, t, conn, qName, typName, "", "")()
+	q, err := goracle.NewQueue(ctx, conn, qName, typName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+	if err = q.SetEnqOptions(goracle.EnqOptions{Visibility: goracle.VisibleImmediate}); err != nil {
+		t.Fatal(err)
+	}
+	for _, m := range []struct {
+		Amount   int
+		Priority int32
+	}{{50, 1}, {150, 2}, {500, 3}, {20, 1}, {300, 2}} {
+		obj, err := q.PayloadType().NewObject()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = obj.Set("AMOUNT", m.Amount); err == nil {
+			_, err = q.EnqueueOne(goracle.Message{Object: obj, Priority: m.Priority})
+		}
+		obj.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, tc := range []struct {
+		Condition string
+		Want      int
+	}{
+		{"", 5},
+		{"tab.user_data.amount > 100", 3},
+		{goracle.DeqByPriority(2), 4},
+		{"tab.user_data.amount > 100 AND " + goracle.DeqByPriority(2), 2},
+		{"tab.user_data.amount > 1000", 0},
+	} {
+		n, err := q.CountMatching(ctx, tc.Condition)
+		if err != nil {
+			t.Fatalf("%q: %+v", tc.Condition, err)
+		}
+		if n != tc.Want {
+			t.Errorf("%q: got %d, wanted %d", tc.Condition, n, tc.Want)
+		}
+	}
+
+	// The count matches what a dequeue with the condition gets.
+	msgs := make([]goracle.Message, 10)
+	n, err := q.DequeueWith(msgs, goracle.DeqOptions{
+		Mode: goracle.DeqRemove, Navigation: goracle.NavFirst,
+		Visibility: goracle.VisibleImmediate, Wait: goracle.NoWait,
+		Condition: "tab.user_data.amount > 100",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, m := range msgs[:n] {
+		m.Object.Close()
+	}
+	if n != 3 {
+		t.Errorf("dequeued %d, wanted 3", n)
+	}
+	if n, err = q.CountMatching(ctx, "tab.user_data.amount > 100"); err != nil {
+		t.Fatal(err)
+	} else if n != 0 {
+		t.Errorf("after the dequeue, %d messages match", n)
+	}
+}
+
+func TestQueueRemove(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	conn, err := testDb.Conn(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	const qName = "TEST_QREMOVE"
+	defer createQueue(ctx, t, conn, qName, "", "", "")()
+	q, err := goracle.NewQueue(ctx, conn, qName, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+	if err = q.SetEnqOptions(goracle.EnqOptions{Visibility: goracle.VisibleImmediate}); err != nil {
+		t.Fatal(err)
+	}
+	if err = q.SetDeqOptions(goracle.DeqOptions{Visibility: goracle.VisibleImmediate}); err != nil {
+		t.Fatal(err)
+	}
+	enq := make([]goracle.Message, 5)
+	for i := range enq {
+		enq[i].Raw = []byte(fmt.Sprintf("%d", i))
+	}
+	if err = q.Enqueue(enq); err != nil {
+		t.Fatal(err)
+	}
+
+	browse := func() []string {
+		t.Helper()
+		msgs := make([]goracle.Message, len(enq)+1)
+		n, err := q.DequeueWith(msgs, goracle.BrowseAllOptions())
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := make([]string, n)
+		for i, m := range msgs[:n] {
+			got[i] = string(m.Raw)
+		}
+		return got
+	}
+	if got := browse(); len(got) != len(enq) {
+		t.Fatalf("browsed %q, wanted %d messages", got, len(enq))
+	}
+
+	n, err := q.Remove([][goracle.MsgIDLength]byte{enq[1].MsgID, enq[3].MsgID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Errorf("removed %d messages, wanted 2", n)
+	}
+	if got, want := browse(), []string{"0", "2", "4"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q remaining, wanted %q", got, want)
+	}
+}
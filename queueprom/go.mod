@@ -0,0 +1,11 @@
+module gopkg.in/goracle.v2/queueprom
+
+require (
+	github.com/pkg/errors v0.8.0
+	github.com/prometheus/client_golang v1.0.0
+	gopkg.in/goracle.v2 v2.0.0-00010101000000-000000000000
+)
+
+replace gopkg.in/goracle.v2 => ../
+
+go 1.13
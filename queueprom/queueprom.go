@@ -0,0 +1,149 @@
+// Copyright 2019 Tamás Gulácsi
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+// Package queueprom exports the metrics of a goracle.Queue for Prometheus.
+//
+// It is a separate module, so the driver itself does not depend on the Prometheus client.
+package queueprom
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	goracle "gopkg.in/goracle.v2"
+)
+
+// DefaultTimeout is the default timeout of the queue depth query of a scrape.
+const DefaultTimeout = 10 * time.Second
+
+var (
+	messagesDesc = prometheus.NewDesc("goracle_queue_messages_total",
+		"Number of messages enqueued or dequeued.", []string{"queue", "op"}, nil)
+	callsDesc = prometheus.NewDesc("goracle_queue_calls_total",
+		"Number of enqueue or dequeue calls.", []string{"queue", "op"}, nil)
+	errorsDesc = prometheus.NewDesc("goracle_queue_errors_total",
+		"Number of failed enqueue or dequeue calls.", []string{"queue", "op"}, nil)
+	secondsDesc = prometheus.NewDesc("goracle_queue_call_seconds_total",
+		"Time spent in enqueue or dequeue calls.", []string{"queue", "op"}, nil)
+	depthDesc = prometheus.NewDesc("goracle_queue_depth",
+		"Number of messages in the queue, by state.", []string{"queue", "state"}, nil)
+)
+
+// Queue is the part of *goracle.Queue the Collector uses.
+type Queue interface {
+	Name() string
+	Counts(context.Context) (goracle.MessageCounts, error)
+}
+
+// Collector is a prometheus.Collector reporting the enqueue/dequeue throughput
+// (from the events given to Observe) and the depth of a queue (from Counts, at each scrape).
+type Collector struct {
+	// Timeout of the depth query, DefaultTimeout if zero.
+	Timeout time.Duration
+
+	q     Queue
+	mu    sync.Mutex
+	stats map[string]*opStats
+}
+
+type opStats struct {
+	messages, calls, errors uint64
+	seconds                 float64
+}
+
+// New returns a Collector for the Queue, set as the Queue's observer.
+// An observer set on the Queue before is kept, and called after Observe.
+//
+// The depth is queried through the Queue's connection, so a scrape waits for a dequeue waiting on it.
+// To avoid that, cap the dequeue wait, or use NewCollector with a Queue on another connection,
+// and call Observe from the observer of the dequeueing Queue.
+func New(Q *goracle.Queue) *Collector {
+	c := NewCollector(Q)
+	if prev := Q.Observer(); prev != nil {
+		Q.SetObserver(func(ev goracle.QueueEvent) {
+			c.Observe(ev)
+			prev(ev)
+		})
+	} else {
+		Q.SetObserver(c.Observe)
+	}
+	return c
+}
+
+// NewCollector returns a Collector querying the depth of q.
+// The throughput is counted from the events given to Observe.
+func NewCollector(q Queue) *Collector {
+	return &Collector{q: q, stats: make(map[string]*opStats)}
+}
+
+// Observe counts the event; it can be used as (or called from) a Queue observer.
+func (c *Collector) Observe(ev goracle.QueueEvent) {
+	c.mu.Lock()
+	st := c.stats[ev.Op]
+	if st == nil {
+		st = new(opStats)
+		c.stats[ev.Op] = st
+	}
+	st.calls++
+	st.messages += uint64(ev.Count)
+	if ev.Err != nil {
+		st.errors++
+	}
+	st.seconds += ev.Duration.Seconds()
+	c.mu.Unlock()
+}
+
+// Describe implements prometheus.Collector.
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	for _, d := range []*prometheus.Desc{messagesDesc, callsDesc, errorsDesc, secondsDesc, depthDesc} {
+		ch <- d
+	}
+}
+
+// Collect implements prometheus.Collector.
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	name := c.q.Name()
+	c.mu.Lock()
+	for op, st := range c.stats {
+		ch <- prometheus.MustNewConstMetric(messagesDesc, prometheus.CounterValue, float64(st.messages), name, op)
+		ch <- prometheus.MustNewConstMetric(callsDesc, prometheus.CounterValue, float64(st.calls), name, op)
+		ch <- prometheus.MustNewConstMetric(errorsDesc, prometheus.CounterValue, float64(st.errors), name, op)
+		ch <- prometheus.MustNewConstMetric(secondsDesc, prometheus.CounterValue, st.seconds, name, op)
+	}
+	c.mu.Unlock()
+
+	timeout := c.Timeout
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	counts, err := c.q.Counts(ctx)
+	cancel()
+	if err != nil {
+		ch <- prometheus.NewInvalidMetric(depthDesc, err)
+		return
+	}
+	for _, s := range []struct {
+		State string
+		N     int
+	}{
+		{"ready", counts.Ready}, {"waiting", counts.Waiting},
+		{"processed", counts.Processed}, {"expired", counts.Expired},
+	} {
+		ch <- prometheus.MustNewConstMetric(depthDesc, prometheus.GaugeValue, float64(s.N), name, s.State)
+	}
+}
@@ -0,0 +1,93 @@
+// Copyright 2019 Tamás Gulácsi
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package queueprom_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	goracle "gopkg.in/goracle.v2"
+	"gopkg.in/goracle.v2/queueprom"
+)
+
+type fakeQueue struct {
+	counts goracle.MessageCounts
+	err    error
+}
+
+func (q fakeQueue) Name() string { return "TEST_Q" }
+func (q fakeQueue) Counts(context.Context) (goracle.MessageCounts, error) {
+	return q.counts, q.err
+}
+
+func TestCollector(t *testing.T) {
+	c := queueprom.NewCollector(fakeQueue{counts: goracle.MessageCounts{Ready: 3, Waiting: 1}})
+	reg := prometheus.NewPedanticRegistry()
+	if err := reg.Register(c); err != nil {
+		t.Fatal(err)
+	}
+	for _, ev := range []goracle.QueueEvent{
+		{Op: goracle.OpEnqueue, Batch: 10, Count: 10, Duration: time.Second},
+		{Op: goracle.OpEnqueue, Batch: 2, Count: 0, Duration: time.Second / 2, Err: errors.New("ORA-00001")},
+		{Op: goracle.OpDequeue, Batch: 8, Count: 8, Duration: time.Second / 4},
+	} {
+		c.Observe(ev)
+	}
+
+	const want = `
+# HELP goracle_queue_calls_total Number of enqueue or dequeue calls.
+# TYPE goracle_queue_calls_total counter
+goracle_queue_calls_total{op="dequeue",queue="TEST_Q"} 1
+goracle_queue_calls_total{op="enqueue",queue="TEST_Q"} 2
+# HELP goracle_queue_call_seconds_total Time spent in enqueue or dequeue calls.
+# TYPE goracle_queue_call_seconds_total counter
+goracle_queue_call_seconds_total{op="dequeue",queue="TEST_Q"} 0.25
+goracle_queue_call_seconds_total{op="enqueue",queue="TEST_Q"} 1.5
+# HELP goracle_queue_depth Number of messages in the queue, by state.
+# TYPE goracle_queue_depth gauge
+goracle_queue_depth{queue="TEST_Q",state="expired"} 0
+goracle_queue_depth{queue="TEST_Q",state="processed"} 0
+goracle_queue_depth{queue="TEST_Q",state="ready"} 3
+goracle_queue_depth{queue="TEST_Q",state="waiting"} 1
+# HELP goracle_queue_errors_total Number of failed enqueue or dequeue calls.
+# TYPE goracle_queue_errors_total counter
+goracle_queue_errors_total{op="dequeue",queue="TEST_Q"} 0
+goracle_queue_errors_total{op="enqueue",queue="TEST_Q"} 1
+# HELP goracle_queue_messages_total Number of messages enqueued or dequeued.
+# TYPE goracle_queue_messages_total counter
+goracle_queue_messages_total{op="dequeue",queue="TEST_Q"} 8
+goracle_queue_messages_total{op="enqueue",queue="TEST_Q"} 10
+`
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(want)); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestCollectorCountsError(t *testing.T) {
+	c := queueprom.NewCollector(fakeQueue{err: errors.New("ORA-24010: QUEUE does not exist")})
+	reg := prometheus.NewRegistry()
+	if err := reg.Register(c); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := reg.Gather(); err == nil {
+		t.Error("wanted the Counts error in the scrape")
+	}
+}
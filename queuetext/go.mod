@@ -0,0 +1,11 @@
+module gopkg.in/goracle.v2/queuetext
+
+require (
+	github.com/pkg/errors v0.8.0
+	golang.org/x/text v0.3.2
+	gopkg.in/goracle.v2 v2.0.0-00010101000000-000000000000
+)
+
+replace gopkg.in/goracle.v2 => ../
+
+go 1.13
@@ -0,0 +1,99 @@
+// Copyright 2019 Tamás Gulácsi
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+// Package queuetext converts the RAW payloads of goracle.Message holding text in a given charset.
+//
+// It is a separate module, so the driver itself does not depend on golang.org/x/text.
+package queuetext
+
+import (
+	"strings"
+
+	"github.com/pkg/errors"
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/htmlindex"
+	"golang.org/x/text/encoding/unicode"
+	goracle "gopkg.in/goracle.v2"
+)
+
+// ErrUnknownCharset is returned for a charset name which is neither an Oracle nor an IANA/WHATWG one known.
+var ErrUnknownCharset = errors.New("unknown charset")
+
+// oracleCharsets maps the common Oracle NLS character set names to their WHATWG encoding labels.
+var oracleCharsets = map[string]string{
+	"AL32UTF8":      "utf-8",
+	"UTF8":          "utf-8",
+	"US7ASCII":      "us-ascii",
+	"WE8ISO8859P1":  "iso-8859-1",
+	"WE8ISO8859P15": "iso-8859-15",
+	"EE8ISO8859P2":  "iso-8859-2",
+	"CL8ISO8859P5":  "iso-8859-5",
+	"WE8MSWIN1252":  "windows-1252",
+	"EE8MSWIN1250":  "windows-1250",
+	"CL8MSWIN1251":  "windows-1251",
+	"EL8MSWIN1253":  "windows-1253",
+	"TR8MSWIN1254":  "windows-1254",
+	"CL8KOI8R":      "koi8-r",
+	"JA16SJIS":      "shift_jis",
+	"JA16EUC":       "euc-jp",
+	"ZHS16GBK":      "gbk",
+	"ZHT16BIG5":     "big5",
+	"KO16MSWIN949":  "euc-kr",
+}
+
+// Encoding returns the encoding of the charset, given by its Oracle (WE8MSWIN1252)
+// or IANA/WHATWG (windows-1252) name.
+func Encoding(charset string) (encoding.Encoding, error) {
+	name := charset
+	if label, ok := oracleCharsets[strings.ToUpper(charset)]; ok {
+		name = label
+	}
+	if strings.EqualFold(name, "utf-8") {
+		return unicode.UTF8, nil
+	}
+	enc, err := htmlindex.Get(name)
+	if err != nil {
+		return nil, errors.Wrap(ErrUnknownCharset, charset)
+	}
+	return enc, nil
+}
+
+// Decode the RAW payload of the message, holding text in charset, to a string.
+func Decode(M goracle.Message, charset string) (string, error) {
+	enc, err := Encoding(charset)
+	if err != nil {
+		return "", err
+	}
+	b, err := enc.NewDecoder().Bytes(M.Raw)
+	if err != nil {
+		return "", errors.Wrapf(err, "decode from %s", charset)
+	}
+	return string(b), nil
+}
+
+// Encode the text in charset as the RAW payload of the message.
+// Returns an error if the text has characters which cannot be represented in charset.
+func Encode(M *goracle.Message, text, charset string) error {
+	enc, err := Encoding(charset)
+	if err != nil {
+		return err
+	}
+	b, err := enc.NewEncoder().Bytes([]byte(text))
+	if err != nil {
+		return errors.Wrapf(err, "encode to %s", charset)
+	}
+	M.Raw = b
+	return nil
+}
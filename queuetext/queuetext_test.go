@@ -0,0 +1,57 @@
+// Copyright 2019 Tamás Gulácsi
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package queuetext
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/pkg/errors"
+	goracle "gopkg.in/goracle.v2"
+)
+
+func TestDecode(t *testing.T) {
+	// "Café – 10€" in Windows-1252: é=0xE9, en dash=0x96, euro=0x80.
+	raw := []byte{'C', 'a', 'f', 0xE9, ' ', 0x96, ' ', '1', '0', 0x80}
+	const want = "Café – 10€"
+	for _, charset := range []string{"WE8MSWIN1252", "windows-1252", "cp1252"} {
+		got, err := Decode(goracle.Message{Raw: raw}, charset)
+		if err != nil {
+			t.Fatalf("%s: %+v", charset, err)
+		}
+		if got != want {
+			t.Errorf("%s: got %q, wanted %q", charset, got, want)
+		}
+	}
+
+	var M goracle.Message
+	if err := Encode(&M, want, "WE8MSWIN1252"); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(M.Raw, raw) {
+		t.Errorf("encoded to % x, wanted % x", M.Raw, raw)
+	}
+	if err := Encode(&M, "日本", "WE8ISO8859P1"); err == nil {
+		t.Error("encoding an unrepresentable text succeeded")
+	}
+
+	if got, err := Decode(goracle.Message{Raw: []byte(want)}, "AL32UTF8"); err != nil || got != want {
+		t.Errorf("UTF-8: got %q, %+v", got, err)
+	}
+	if _, err := Decode(goracle.Message{}, "NOSUCHCHARSET"); errors.Cause(err) != ErrUnknownCharset {
+		t.Errorf("got %+v, wanted ErrUnknownCharset", err)
+	}
+}
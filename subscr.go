@@ -26,8 +26,12 @@ void CallbackSubscrDebug(void *context, dpiSubscrMessage *message);
 import "C"
 
 import (
+	"context"
+	"database/sql/driver"
 	"log"
 	"strings"
+	"sync"
+	"time"
 	"unsafe"
 
 	"github.com/pkg/errors"
@@ -40,7 +44,12 @@ func CallbackSubscr(ctx unsafe.Pointer, message *C.dpiSubscrMessage) {
 	if ctx == nil {
 		return
 	}
-	subscr := (*Subscription)(ctx)
+	// The context holds the id of the Subscription, not a Go pointer,
+	// so a late callback for a closed Subscription finds nothing.
+	subscr := subscriptions.get(*(*C.uint64_t)(ctx))
+	if subscr == nil {
+		return
+	}
 
 	getRows := func(rws *C.dpiSubscrMessageRow, rwsNum C.uint32_t) []RowEvent {
 		if rwsNum == 0 {
@@ -91,22 +100,40 @@ func CallbackSubscr(ctx unsafe.Pointer, message *C.dpiSubscrMessage) {
 		err = fromErrorInfo(*message.errorInfo)
 	}
 
-	subscr.callback(Event{
-		Err:     err,
-		Type:    EventType(message.eventType),
-		DB:      C.GoStringN(message.dbName, C.int(message.dbNameLength)),
-		Tables:  getTables(message.tables, message.numTables),
-		Queries: getQueries(message.queries, message.numQueries),
-	})
+	evt := Event{
+		Err:      err,
+		Type:     EventType(message.eventType),
+		DB:       C.GoStringN(message.dbName, C.int(message.dbNameLength)),
+		Tables:   getTables(message.tables, message.numTables),
+		Queries:  getQueries(message.queries, message.numQueries),
+		Queue:    C.GoStringN(message.queueName, C.int(message.queueNameLength)),
+		Consumer: C.GoStringN(message.consumerName, C.int(message.consumerNameLength)),
+	}
+	if err != nil {
+		subscr.sendErr(err)
+	}
+	if callback := subscr.getCallback(); callback != nil {
+		callback(evt)
+	}
+	switch evt.Type {
+	case EvtDereg:
+		subscr.die(ErrSubscriptionDeregistered)
+	case EvtShutdown, EvtShutdownAny:
+		subscr.die(errors.Wrap(ErrSubscriptionDeregistered, "database shutdown"))
+	}
 }
 
 // Event for a subscription.
+//
+// Queue and Consumer are set for the EvtAQ events of a Queue.Subscribe subscription.
 type Event struct {
-	Tables  []TableEvent
-	Queries []QueryEvent
-	DB      string
-	Err     error
-	Type    EventType
+	Tables   []TableEvent
+	Queries  []QueryEvent
+	DB       string
+	Queue    string
+	Consumer string
+	Err      error
+	Type     EventType
 }
 
 // QueryEvent is an event of a Query.
@@ -130,13 +157,61 @@ type RowEvent struct {
 }
 
 // Subscription for events in the DB.
+//
+// A Subscription lives until it is closed, deregistered by the DB (EvtDereg, or a shutdown event),
+// or its connection is closed or lost: then Done is closed, and Err reports the reason.
+// The connection is checked only with SetPingInterval, as a lost connection does not send events.
 type Subscription struct {
-	conn      *conn
+	id       uint64
+	mu       sync.Mutex
+	conn     *conn
+	callback func(Event)
+	errs     chan error
+
 	dpiSubscr *C.dpiSubscr
-	callback  func(Event)
+	done      chan struct{}
+	doneOnce  sync.Once
+	err       error
+	pinger    chan time.Duration
 }
 
-func (s *Subscription) getError() error { return s.conn.getError() }
+var (
+	// ErrSubscriptionClosed is the Err of a Subscription closed with Close.
+	ErrSubscriptionClosed = errors.New("subscription closed")
+	// ErrSubscriptionDeregistered is the Err of a Subscription deregistered by the DB.
+	ErrSubscriptionDeregistered = errors.New("subscription deregistered")
+)
+
+// subscrErrsLen is the buffer length of Subscription.Errors: the errors not received in time are dropped.
+const subscrErrsLen = 16
+
+// subscriptions maps the callback context ids to the live Subscriptions.
+var subscriptions = subscrRegistry{m: make(map[uint64]*Subscription)}
+
+type subscrRegistry struct {
+	mu   sync.Mutex
+	m    map[uint64]*Subscription
+	next uint64
+}
+
+func (r *subscrRegistry) add(s *Subscription) {
+	r.mu.Lock()
+	r.next++
+	s.id = r.next
+	r.m[s.id] = s
+	r.mu.Unlock()
+}
+func (r *subscrRegistry) get(id C.uint64_t) *Subscription {
+	r.mu.Lock()
+	s := r.m[uint64(id)]
+	r.mu.Unlock()
+	return s
+}
+func (r *subscrRegistry) remove(id uint64) {
+	r.mu.Lock()
+	delete(r.m, id)
+	r.mu.Unlock()
+}
 
 // NewSubscription creates a new Subscription in the DB.
 //
@@ -144,16 +219,28 @@ func (s *Subscription) getError() error { return s.conn.getError() }
 //
 // This code is EXPERIMENTAL yet!
 func (c *conn) NewSubscription(name string, cb func(Event)) (*Subscription, error) {
+	return c.newSubscription(C.DPI_SUBSCR_NAMESPACE_DBCHANGE,
+		C.DPI_SUBSCR_QOS_BEST_EFFORT|C.DPI_SUBSCR_QOS_QUERY|C.DPI_SUBSCR_QOS_ROWIDS,
+		name, cb)
+}
+
+func (c *conn) newSubscription(namespace C.dpiSubscrNamespace, qos C.dpiSubscrQOS, name string, cb func(Event)) (*Subscription, error) {
 	if !c.connParams.EnableEvents {
 		return nil, errors.New("subscription must be allowed by specifying \"enableEvents=1\" in the connection parameters")
 	}
-	subscr := Subscription{conn: c, callback: cb}
+	subscr := &Subscription{conn: c, callback: cb,
+		errs: make(chan error, subscrErrsLen), done: make(chan struct{})}
+	subscriptions.add(subscr)
+	// The callback context may be used by a late callback even after unsubscribing, so it is never freed.
+	idPtr := (*C.uint64_t)(C.malloc(C.sizeof_uint64_t))
+	*idPtr = C.uint64_t(subscr.id)
+
 	params := (*C.dpiSubscrCreateParams)(C.malloc(C.sizeof_dpiSubscrCreateParams))
 	//defer func() { C.free(unsafe.Pointer(params)) }()
 	C.dpiContext_initSubscrCreateParams(c.dpiContext, params)
-	params.subscrNamespace = C.DPI_SUBSCR_NAMESPACE_DBCHANGE
+	params.subscrNamespace = namespace
 	params.protocol = C.DPI_SUBSCR_PROTO_CALLBACK
-	params.qos = C.DPI_SUBSCR_QOS_BEST_EFFORT | C.DPI_SUBSCR_QOS_QUERY | C.DPI_SUBSCR_QOS_ROWIDS
+	params.qos = qos
 	params.operations = C.DPI_OPCODE_ALL_OPS
 	if name != "" {
 		params.name = C.CString(name)
@@ -161,7 +248,7 @@ func (c *conn) NewSubscription(name string, cb func(Event)) (*Subscription, erro
 	}
 	// typedef void (*dpiSubscrCallback)(void* context, dpiSubscrMessage *message);
 	params.callback = C.dpiSubscrCallback(C.CallbackSubscrDebug)
-	params.callbackContext = unsafe.Pointer(&subscr)
+	params.callbackContext = unsafe.Pointer(idPtr)
 
 	dpiSubscr := (*C.dpiSubscr)(C.malloc(C.sizeof_void))
 
@@ -175,10 +262,13 @@ func (c *conn) NewSubscription(name string, cb func(Event)) (*Subscription, erro
 		if strings.Contains(errors.Cause(err).Error(), "DPI-1065:") {
 			err = errors.WithMessage(err, "specify \"enableEvents=1\" connection parameter on connection to be able to use subscriptions")
 		}
+		subscriptions.remove(subscr.id)
+		C.free(unsafe.Pointer(idPtr))
 		return nil, err
 	}
 	subscr.dpiSubscr = dpiSubscr
-	return &subscr, nil
+	c.addSubscription(subscr)
+	return subscr, nil
 }
 
 // Register a query for Change Notification.
@@ -188,20 +278,27 @@ func (s *Subscription) Register(qry string, params ...interface{}) error {
 	cQry := C.CString(qry)
 	defer func() { C.free(unsafe.Pointer(cQry)) }()
 
+	s.mu.Lock()
+	conn, dpiSubscr := s.conn, s.dpiSubscr
+	s.mu.Unlock()
+	if dpiSubscr == nil {
+		return errors.Wrap(s.Err(), "register")
+	}
+
 	var dpiStmt *C.dpiStmt
-	if C.dpiSubscr_prepareStmt(s.dpiSubscr, cQry, C.uint32_t(len(qry)), &dpiStmt) == C.DPI_FAILURE {
-		return errors.Wrapf(s.getError(), "prepareStmt[%p]", s.dpiSubscr)
+	if C.dpiSubscr_prepareStmt(dpiSubscr, cQry, C.uint32_t(len(qry)), &dpiStmt) == C.DPI_FAILURE {
+		return errors.Wrapf(conn.getError(), "prepareStmt[%p]", dpiSubscr)
 	}
 	defer func() { C.dpiStmt_release(dpiStmt) }()
 
 	mode := C.dpiExecMode(C.DPI_MODE_EXEC_DEFAULT)
 	var qCols C.uint32_t
 	if C.dpiStmt_execute(dpiStmt, mode, &qCols) == C.DPI_FAILURE {
-		return errors.Wrap(s.getError(), "executeStmt")
+		return errors.Wrap(conn.getError(), "executeStmt")
 	}
 	var queryID C.uint64_t
 	if C.dpiStmt_getSubscrQueryId(dpiStmt, &queryID) == C.DPI_FAILURE {
-		return errors.Wrap(s.getError(), "getSubscrQueryId")
+		return errors.Wrap(conn.getError(), "getSubscrQueryId")
 	}
 	if Log != nil {
 		Log("msg", "subscribed", "query", qry, "id", queryID)
@@ -210,24 +307,187 @@ func (s *Subscription) Register(qry string, params ...interface{}) error {
 	return nil
 }
 
-// Close the subscription.
+// Done returns a channel which is closed when the Subscription ends, see Err for the reason.
+func (s *Subscription) Done() <-chan struct{} { return s.done }
+
+// Err returns the reason of the end of the Subscription (ErrSubscriptionClosed after Close),
+// or nil while it is alive.
+func (s *Subscription) Err() error {
+	select {
+	case <-s.done:
+		return s.err
+	default:
+		return nil
+	}
+}
+
+// Errors returns the channel of the errors of the Subscription: those reported with the events,
+// and the reason of its end (except ErrSubscriptionClosed). It is closed when the Subscription ends.
+//
+// The channel is buffered, and the errors are dropped if it is full.
+func (s *Subscription) Errors() <-chan error { return s.errs }
+
+// SetPingInterval starts checking the connection of the Subscription every d, to detect its loss:
+// the Subscription ends with the connection error. Zero (the default) stops checking.
+//
+// The ping is a round trip on the connection, so it waits for the statement running on it.
+func (s *Subscription) SetPingInterval(d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.dpiSubscr == nil {
+		return
+	}
+	if s.pinger == nil {
+		if d <= 0 {
+			return
+		}
+		s.pinger = make(chan time.Duration, 1)
+		go s.ping(s.conn, s.pinger)
+	}
+	select {
+	case <-s.pinger:
+	default:
+	}
+	s.pinger <- d
+}
+
+func (s *Subscription) ping(c *conn, intervals <-chan time.Duration) {
+	var ticker *time.Ticker
+	var tick <-chan time.Time
+	stop := func() {
+		if ticker != nil {
+			ticker.Stop()
+			ticker, tick = nil, nil
+		}
+	}
+	defer stop()
+	for {
+		select {
+		case <-s.done:
+			return
+		case d := <-intervals:
+			stop()
+			if d > 0 {
+				ticker = time.NewTicker(d)
+				tick = ticker.C
+			}
+		case <-tick:
+			c.RLock()
+			closed := c.dpiConn == nil
+			c.RUnlock()
+			var err error
+			if closed {
+				err = errors.Wrap(driver.ErrBadConn, "connection closed")
+			} else {
+				ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+				err = c.Ping(ctx)
+				cancel()
+			}
+			if err != nil && IsConnectionLost(err) {
+				s.shutdown(nil, errors.WithMessage(err, "subscription ping"))
+				return
+			}
+		}
+	}
+}
+
+func (s *Subscription) getCallback() func(Event) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.callback
+}
+
+// sendErr sends err on the errors channel, if it is not full or closed.
+func (s *Subscription) sendErr(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	select {
+	case <-s.done:
+		return
+	default:
+	}
+	select {
+	case s.errs <- err:
+	default:
+	}
+}
+
+// die ends the Subscription with the given reason, without unsubscribing.
+func (s *Subscription) die(reason error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.doneOnce.Do(func() {
+		s.err = reason
+		if reason != ErrSubscriptionClosed {
+			select {
+			case s.errs <- reason:
+			default:
+			}
+		}
+		close(s.errs)
+		close(s.done)
+	})
+}
+
+// Close the subscription. It is safe to call it more than once.
 //
 // This code is EXPERIMENTAL yet!
 func (s *Subscription) Close() error {
-	dpiSubscr := s.dpiSubscr
-	conn := s.conn
-	s.conn = nil
-	s.dpiSubscr = nil
-	s.callback = nil
-	if dpiSubscr == nil || conn == nil || conn.dpiConn == nil {
+	return s.shutdown(nil, ErrSubscriptionClosed)
+}
+
+// shutdown ends the Subscription with the reason, and unsubscribes it, on dpiConn if not nil
+// (when called from conn.Close, holding the conn's lock), or on its conn.
+func (s *Subscription) shutdown(dpiConn *C.dpiConn, reason error) error {
+	s.die(reason)
+	s.mu.Lock()
+	dpiSubscr, conn := s.dpiSubscr, s.conn
+	s.conn, s.dpiSubscr, s.callback = nil, nil, nil
+	s.mu.Unlock()
+	if conn == nil {
+		return nil
+	}
+	subscriptions.remove(s.id)
+	if dpiConn == nil {
+		conn.removeSubscription(s)
+		conn.RLock()
+		defer conn.RUnlock()
+		dpiConn = conn.dpiConn
+	}
+	if dpiSubscr == nil || dpiConn == nil {
 		return nil
 	}
-	if C.dpiConn_unsubscribe(conn.dpiConn, dpiSubscr) == C.DPI_FAILURE {
-		return errors.Wrap(s.getError(), "close")
+	if C.dpiConn_unsubscribe(dpiConn, dpiSubscr) == C.DPI_FAILURE {
+		return errors.Wrap(conn.getError(), "close")
 	}
 	return nil
 }
 
+func (c *conn) addSubscription(s *Subscription) {
+	c.subscrsMu.Lock()
+	if c.subscrs == nil {
+		c.subscrs = make(map[*Subscription]struct{})
+	}
+	c.subscrs[s] = struct{}{}
+	c.subscrsMu.Unlock()
+}
+func (c *conn) removeSubscription(s *Subscription) {
+	c.subscrsMu.Lock()
+	delete(c.subscrs, s)
+	c.subscrsMu.Unlock()
+}
+
+// closeSubscriptions ends the Subscriptions of the connection, before the dpiConn is released.
+func (c *conn) closeSubscriptions(dpiConn *C.dpiConn) {
+	c.subscrsMu.Lock()
+	subscrs := c.subscrs
+	c.subscrs = nil
+	c.subscrsMu.Unlock()
+	for s := range subscrs {
+		_ = s.shutdown(dpiConn, errors.Wrap(driver.ErrBadConn, "connection closed"))
+	}
+}
+
 // EventType is the type of an event.
 type EventType C.dpiEventType
 
@@ -487,3 +487,270 @@ func benchSelect(b *testing.B, geoTableName string, prefetchLen int) {
 		rows.Close()
 	}
 }
+
+func BenchmarkQueueDequeue(b *testing.B) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+	conn, err := testDb.Conn(ctx)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer conn.Close()
+
+	const qName = "TEST_QBENCH"
+	defer createQueue(ctx, b, conn, qName, "", "", "")()
+	q, err := goracle.NewQueue(ctx, conn, qName, "")
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer q.Close()
+	if err = q.SetEnqOptions(goracle.EnqOptions{Visibility: goracle.VisibleImmediate}); err != nil {
+		b.Fatal(err)
+	}
+	if err = q.SetDeqOptions(goracle.DeqOptions{
+		Mode: goracle.DeqRemove, Navigation: goracle.NavFirst,
+		Visibility: goracle.VisibleImmediate, Wait: goracle.NoWait,
+	}); err != nil {
+		b.Fatal(err)
+	}
+
+	const batch = 16
+	enq := make([]goracle.Message, batch)
+	for i := range enq {
+		enq[i].Raw = []byte(strings.Repeat("x", 1024))
+	}
+	for _, into := range []bool{false, true} {
+		b.Run(fmt.Sprintf("into=%t", into), func(b *testing.B) {
+			msgs := make([]goracle.Message, batch)
+			bufs := make([][]byte, batch)
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				b.StopTimer()
+				if err := q.Enqueue(enq); err != nil {
+					b.Fatal(err)
+				}
+				b.StartTimer()
+				var n int
+				if into {
+					n, err = q.DequeueInto(bufs, msgs)
+				} else {
+					n, err = q.Dequeue(msgs)
+				}
+				if err != nil {
+					b.Fatal(err)
+				}
+				if n != batch {
+					b.Fatalf("got %d, wanted %d", n, batch)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkQueueEnqueue(b *testing.B) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+	conn, err := testDb.Conn(ctx)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer conn.Close()
+
+	const qName = "TEST_QBENCH_ENQ"
+	defer createQueue(ctx, b, conn, qName, "", "", "")()
+	q, err := goracle.NewQueue(ctx, conn, qName, "")
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer q.Close()
+	if err = q.SetEnqOptions(goracle.EnqOptions{Visibility: goracle.VisibleOnCommit}); err != nil {
+		b.Fatal(err)
+	}
+	defer q.Rollback()
+
+	enq := []goracle.Message{{Raw: []byte(strings.Repeat("x", 1024))}}
+	for _, poolSize := range []int{0, 1} {
+		for _, unsafe := range []bool{false, true} {
+			enqueue := q.Enqueue
+			if unsafe {
+				enqueue = q.EnqueueUnsafe
+			}
+			b.Run(fmt.Sprintf("pool=%d,unsafe=%t", poolSize, unsafe), func(b *testing.B) {
+				q.SetPropsPoolSize(poolSize)
+				b.ReportAllocs()
+				for i := 0; i < b.N; i++ {
+					if err := enqueue(enq); err != nil {
+						b.Fatal(err)
+					}
+				}
+				b.StopTimer()
+				if err := q.Rollback(); err != nil {
+					b.Fatal(err)
+				}
+			})
+		}
+	}
+}
+
+func BenchmarkQueueAdaptiveDequeue(b *testing.B) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+	conn, err := testDb.Conn(ctx)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer conn.Close()
+
+	const qName = "TEST_QBENCH_ADAPT"
+	defer createQueue(ctx, b, conn, qName, "", "", "")()
+	q, err := goracle.NewQueue(ctx, conn, qName, "")
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer q.Close()
+	if err = q.SetEnqOptions(goracle.EnqOptions{Visibility: goracle.VisibleImmediate}); err != nil {
+		b.Fatal(err)
+	}
+	if err = q.SetDeqOptions(goracle.DeqOptions{
+		Mode: goracle.DeqRemove, Navigation: goracle.NavFirst,
+		Visibility: goracle.VisibleImmediate, Wait: goracle.NoWait,
+	}); err != nil {
+		b.Fatal(err)
+	}
+
+	// Drain a busy queue of load messages.
+	const load = 256
+	enq := make([]goracle.Message, load)
+	for i := range enq {
+		enq[i].Raw = []byte(strconv.Itoa(i))
+	}
+	for _, tc := range []struct {
+		Name     string
+		Min, Max int
+	}{
+		{"fixed1", 1, 1},
+		{"fixed4", 4, 4},
+		{"adaptive", 1, 128},
+	} {
+		b.Run(tc.Name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				b.StopTimer()
+				if err := q.Enqueue(enq); err != nil {
+					b.Fatal(err)
+				}
+				ad := goracle.NewAdaptiveDequeuer(q, tc.Min, tc.Max)
+				b.StartTimer()
+				for got := 0; got < load; {
+					msgs, err := ad.Dequeue()
+					if err != nil {
+						b.Fatal(err)
+					}
+					if len(msgs) == 0 {
+						b.Fatalf("got only %d of %d", got, load)
+					}
+					got += len(msgs)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkQueueEnqueueObjects(b *testing.B) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+	conn, err := testDb.Conn(ctx)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer conn.Close()
+
+	const qName, typName = "TEST_QBENCH_ENQOBJ", "TEST_QBENCH_ENQOBJ_TYP"
+	defer createQueueType(ctx, b, conn, typName, "id NUMBER(9)")()
+	defer createQueue(ctx, b, conn, qName, typName, "", "")()
+	q, err := goracle.NewQueue(ctx, conn, qName, typName)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer q.Close()
+	defer q.Rollback()
+
+	// The payload objects of a batch are created from the cached payload type.
+	typ := q.PayloadType()
+	const batch = 1000
+	msgs := make([]goracle.Message, batch)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for j := range msgs {
+			obj, err := typ.NewObject()
+			if err != nil {
+				b.Fatal(err)
+			}
+			if err = obj.Set("ID", int64(j)); err != nil {
+				b.Fatal(err)
+			}
+			msgs[j].Object = obj
+		}
+		if err := q.Enqueue(msgs); err != nil {
+			b.Fatal(err)
+		}
+		for _, m := range msgs {
+			m.Object.Close()
+		}
+		if err := q.Rollback(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkQueueSetDeqOptions(b *testing.B) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+	conn, err := testDb.Conn(ctx)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer conn.Close()
+
+	const qName = "TEST_QBENCH_DEQOPTS"
+	defer createQueue(ctx, b, conn, qName, "", "", "")()
+	q, err := goracle.NewQueue(ctx, conn, qName, "")
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer q.Close()
+
+	// A consumer loop sets the same options before each dequeue:
+	// only the changed options call the ODPI-C setters.
+	D := goracle.DeqOptions{
+		Mode: goracle.DeqRemove, Navigation: goracle.NavNext,
+		Visibility: goracle.VisibleImmediate, Wait: goracle.NoWait,
+		Condition: "tab.priority >= 0", Correlation: "bench%",
+	}
+	other := D
+	other.Mode, other.Navigation, other.Visibility, other.Wait = goracle.DeqBrowse, goracle.NavFirst, goracle.VisibleOnCommit, 1
+	other.Condition, other.Correlation = "tab.priority < 0", "other%"
+	for _, tc := range []struct {
+		Name    string
+		Changed bool
+	}{
+		{"unchanged", false},
+		{"changed", true},
+	} {
+		b.Run(tc.Name, func(b *testing.B) {
+			if err := q.SetDeqOptions(D); err != nil {
+				b.Fatal(err)
+			}
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				opts := D
+				if tc.Changed && i%2 == 0 {
+					opts = other
+				}
+				if err := q.SetDeqOptions(opts); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -17,8 +17,10 @@ package goracle_test
 
 import (
 	"context"
+	"fmt"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/pkg/errors"
 	goracle "gopkg.in/goracle.v2"
@@ -82,3 +84,160 @@ func TestQRCN(t *testing.T) {
 	testDb.Exec("INSERT INTO test_subscr (i) VALUES (0)")
 	t.Log("events:", events)
 }
+
+// newTestSubscription subscribes on conn, skipping the test if subscriptions are not allowed.
+func newTestSubscription(t *testing.T, conn goracle.Conn, cb func(goracle.Event)) *goracle.Subscription {
+	t.Helper()
+	s, err := conn.NewSubscription("", cb)
+	if err != nil {
+		errS := errors.Cause(err).Error()
+		if strings.Contains(errS, "ORA-29970:") || strings.Contains(errS, "ORA-65131:") || strings.Contains(errS, "ORA-29972:") {
+			t.Skip(err.Error())
+		}
+		t.Fatalf("%+v", err)
+	}
+	return s
+}
+
+func TestSubscriptionCloseIdempotent(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	sqlConn, err := testDb.Conn(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sqlConn.Close()
+	conn, err := goracle.DriverConn(ctx, sqlConn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := newTestSubscription(t, conn, func(goracle.Event) {})
+	if err = s.Err(); err != nil {
+		t.Errorf("live subscription reports %v", err)
+	}
+	select {
+	case <-s.Done():
+		t.Fatal("Done is closed before Close")
+	default:
+	}
+	for i := 0; i < 3; i++ {
+		if err = s.Close(); err != nil {
+			t.Errorf("%d. Close: %+v", i, err)
+		}
+	}
+	select {
+	case <-s.Done():
+	default:
+		t.Fatal("Done is not closed after Close")
+	}
+	if err = s.Err(); err != goracle.ErrSubscriptionClosed {
+		t.Errorf("got %v, wanted ErrSubscriptionClosed", err)
+	}
+	if err, ok := <-s.Errors(); ok {
+		t.Errorf("Errors is not closed, got %v", err)
+	}
+	if err = s.Register("SELECT 1 FROM DUAL"); err == nil {
+		t.Error("Register succeeded on a closed subscription")
+	}
+}
+
+func TestSubscriptionDoneOnConnectionLoss(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	sqlConn, err := testDb.Conn(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sqlConn.Close()
+	var sid, serial int64
+	if err = sqlConn.QueryRowContext(ctx,
+		"SELECT sid, serial# FROM v$session WHERE sid = SYS_CONTEXT('USERENV', 'SID')",
+	).Scan(&sid, &serial); err != nil {
+		t.Skip(err)
+	}
+	conn, err := goracle.DriverConn(ctx, sqlConn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := newTestSubscription(t, conn, func(goracle.Event) {})
+	defer s.Close()
+	const interval = time.Second
+	s.SetPingInterval(interval)
+
+	qry := fmt.Sprintf("ALTER SYSTEM KILL SESSION '%d,%d' IMMEDIATE", sid, serial)
+	if _, err = testDb.ExecContext(ctx, qry); err != nil {
+		t.Skip(errors.Wrap(err, qry))
+	}
+	select {
+	case <-s.Done():
+		err = s.Err()
+		t.Logf("detected: %+v", err)
+		if !goracle.IsConnectionLost(err) {
+			t.Errorf("got %+v, wanted a lost connection error", err)
+		}
+	case <-time.After(5 * interval):
+		t.Fatalf("the lost connection is not detected in %s", 5*interval)
+	}
+	select {
+	case err = <-s.Errors():
+		if !goracle.IsConnectionLost(err) {
+			t.Errorf("Errors got %+v, wanted a lost connection error", err)
+		}
+	default:
+		t.Error("the end of the subscription is not reported on Errors")
+	}
+}
+
+func TestQueueSubscribe(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	conn, err := testDb.Conn(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	const qName = "TEST_QSUBSCR"
+	defer createQueue(ctx, t, conn, qName, "", "", "")()
+	q, err := goracle.NewQueue(ctx, conn, qName, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+	events := make(chan goracle.Event, 1)
+	s, err := q.Subscribe(ctx, func(e goracle.Event) {
+		select {
+		case events <- e:
+		default:
+		}
+	})
+	if err != nil {
+		errS := errors.Cause(err).Error()
+		if strings.Contains(errS, "ORA-29970:") || strings.Contains(errS, "ORA-65131:") || strings.Contains(errS, "ORA-29972:") {
+			t.Skip(err.Error())
+		}
+		t.Fatalf("%+v", err)
+	}
+	if err = q.SetEnqOptions(goracle.EnqOptions{Visibility: goracle.VisibleImmediate}); err != nil {
+		t.Fatal(err)
+	}
+	if err = q.Enqueue([]goracle.Message{{Raw: []byte("notify")}}); err != nil {
+		t.Fatal(err)
+	}
+	// The notification needs the DB to reach the client, which may be firewalled.
+	select {
+	case e := <-events:
+		t.Logf("event: %+v", e)
+		if e.Type != goracle.EvtAQ {
+			t.Errorf("got event type %v, wanted EvtAQ", e.Type)
+		}
+	case <-time.After(5 * time.Second):
+		t.Log("no notification arrived")
+	}
+	if err = s.Close(); err != nil {
+		t.Error(err)
+	}
+	if err = s.Close(); err != nil {
+		t.Error(err)
+	}
+}